@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/config"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/dns"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/pac"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
+)
+
+// exportConfig converts the profiles of names to the configuration (the same format as cli config file).
+// Saved secrets are decrypted, so the caller must omit or encrypt them before writing.
+func exportConfig(pref fyne.Preferences, names []string) *config.Config {
+	c := &config.Config{Profiles: make(map[string]*config.Profile)}
+	current := currentProfileName(pref)
+	for _, name := range names {
+		c.Profiles[name] = exportProfile(newProfilePreferences(pref, name))
+		if name == current || c.DefaultProfile == "" {
+			c.DefaultProfile = name
+		}
+	}
+	return c
+}
+
+func exportProfile(pref fyne.Preferences) *config.Profile {
+	p := &config.Profile{}
+	if !pref.Bool(PrefHasPreference) {
+		return p
+	}
+	boolPtr := func(b bool) *bool {
+		return &b
+	}
+	p.Remote = pref.String(PrefRemoteAddr)
+	p.LocalAddr = pref.String(PrefLocalAddr)
+	p.Http = boolPtr(pref.Bool(PrefHttpEnable))
+	p.HttpAddr = pref.String(PrefHttpLocalAddr)
+	p.SkipTLSVerify = boolPtr(pref.Bool(PrefSkipTSLVerify))
+	p.AutoReconnect = boolPtr(pref.Bool(PrefAutoReconnect))
+	if pref.Bool(PrefSaveToken) {
+		p.Token, _ = decrypt(pref.String(PrefAuthToken))
+	}
+
+	p.Vpn.Enable = boolPtr(pref.Bool(PrefVpnEnable))
+	p.Vpn.Auto = boolPtr(pref.Bool(PrefVpnAuto))
+	p.Vpn.DirectProbe = pref.String(PrefVpnDirectProbe)
+	p.Vpn.Host = pref.String(PrefVpnHostInput)
+	if pref.Int(PrefVpnAuthMethod) == vpn.VpnAuthMethodQRCode {
+		p.Vpn.AuthMethod = config.AuthMethodQRCode
+	} else {
+		p.Vpn.AuthMethod = config.AuthMethodPasswd
+	}
+	p.Vpn.Username = pref.String(PrefVpnUsername)
+	if pref.Bool(PrefSaveVpnPwd) {
+		p.Vpn.Password, _ = decrypt(pref.String(PrefVpnPassword))
+	}
+	p.Vpn.ForceLogout = boolPtr(pref.Bool(PrefVpnForceLogout))
+	p.Vpn.HostEncrypt = boolPtr(pref.Bool(PrefVpnHostEncrypt))
+	p.Vpn.CredentialHelper = pref.String(PrefVpnCredHelper)
+	p.RouteFile = pref.String(PrefRouteFile)
+	for _, line := range strings.Split(pref.String(PrefForwards), "\n") {
+		if local, remote, ok := strings.Cut(line, "="); ok {
+			p.Forwards = append(p.Forwards, config.Forward{Local: local, Remote: remote})
+		}
+	}
+	p.Pac.Addr = pref.String(PrefPacAddr)
+	p.Pac.Rules = pac.ParseRules(pref.String(PrefPacRules))
+	p.Dns.Addr = pref.String(PrefDnsAddr)
+	p.Dns.Zones = dns.ParseZones(pref.String(PrefDnsZones))
+	p.Dns.Upstream = pref.String(PrefDnsUpstream)
+	p.Dns.Fallback = pref.String(PrefDnsFallback)
+	p.Sharing.Allow = splitLines(pref.String(PrefShareAllow))
+	if users, _ := decrypt(pref.String(PrefShareUsers)); users != "" {
+		p.Sharing.Users = splitLines(users)
+	}
+	p.Sharing.MaxConnections = pref.Int(PrefShareMaxConns)
+	return p
+}
+
+// importConfig adds all profiles in the configuration.
+// A profile is renamed if the name already exists.
+// It returns the names of added profiles, and the name of the imported default profile.
+func importConfig(pref fyne.Preferences, c *config.Config) ([]string, string, error) {
+	var names []string
+	var selected string
+	for _, name := range c.ProfileNames() {
+		newName := name
+		for i := 2; checkProfileName(pref, newName) != nil; i++ {
+			newName = fmt.Sprintf("%s (%d)", name, i)
+		}
+		if err := createProfile(pref, newName); err != nil {
+			return names, selected, err
+		}
+		importProfile(c.Profiles[name], newProfilePreferences(pref, newName))
+		names = append(names, newName)
+		if name == c.DefaultProfile || selected == "" {
+			selected = newName
+		}
+	}
+	return names, selected, nil
+}
+
+// importProfile saves the values of p to the profile preferences.
+// Values not set in p are set to the defaults of ui.
+// Secrets are encrypted by the key, and they are dropped if the key is locked.
+func importProfile(p *config.Profile, pref fyne.Preferences) {
+	boolOr := func(b *bool, fallback bool) bool {
+		if b == nil {
+			return fallback
+		}
+		return *b
+	}
+	pref.SetBool(PrefHasPreference, true)
+	pref.SetString(PrefRemoteAddr, p.Remote)
+	pref.SetString(PrefLocalAddr, p.LocalAddr)
+	pref.SetBool(PrefHttpEnable, boolOr(p.Http, false))
+	pref.SetString(PrefHttpLocalAddr, p.HttpAddr)
+	pref.SetBool(PrefSkipTSLVerify, boolOr(p.SkipTLSVerify, false))
+	pref.SetBool(PrefAutoReconnect, boolOr(p.AutoReconnect, false))
+	if p.Token != "" && !keyLocked() {
+		pref.SetBool(PrefSaveToken, true)
+		pref.SetString(PrefAuthToken, encrypt(p.Token))
+	}
+
+	pref.SetBool(PrefVpnEnable, boolOr(p.Vpn.Enable, true))
+	pref.SetBool(PrefVpnAuto, boolOr(p.Vpn.Auto, false))
+	pref.SetString(PrefVpnDirectProbe, p.Vpn.DirectProbe)
+	pref.SetString(PrefVpnHostInput, p.Vpn.Host)
+	if p.Vpn.AuthMethod == config.AuthMethodQRCode {
+		pref.SetInt(PrefVpnAuthMethod, vpn.VpnAuthMethodQRCode)
+	} else {
+		pref.SetInt(PrefVpnAuthMethod, vpn.VpnAuthMethodPasswd)
+	}
+	pref.SetString(PrefVpnUsername, p.Vpn.Username)
+	if p.Vpn.Password != "" && !keyLocked() {
+		pref.SetBool(PrefSaveVpnPwd, true)
+		pref.SetString(PrefVpnPassword, encrypt(p.Vpn.Password))
+	}
+	pref.SetBool(PrefVpnForceLogout, boolOr(p.Vpn.ForceLogout, true))
+	pref.SetBool(PrefVpnHostEncrypt, boolOr(p.Vpn.HostEncrypt, true))
+	pref.SetString(PrefVpnCredHelper, p.Vpn.CredentialHelper)
+	pref.SetString(PrefRouteFile, p.RouteFile)
+	forwards := make([]string, 0, len(p.Forwards))
+	for _, f := range p.ExtraForwards() {
+		forwards = append(forwards, f.String())
+	}
+	pref.SetString(PrefForwards, strings.Join(forwards, "\n"))
+	pref.SetString(PrefPacAddr, p.Pac.Addr)
+	pref.SetString(PrefPacRules, strings.Join(p.Pac.Rules, "\n"))
+	pref.SetString(PrefDnsAddr, p.Dns.Addr)
+	pref.SetString(PrefDnsZones, strings.Join(p.Dns.Zones, ","))
+	pref.SetString(PrefDnsUpstream, p.Dns.Upstream)
+	pref.SetString(PrefDnsFallback, p.Dns.Fallback)
+	pref.SetString(PrefShareAllow, strings.Join(p.Sharing.Allow, "\n"))
+	if len(p.Sharing.Users) != 0 && !keyLocked() {
+		pref.SetString(PrefShareUsers, encrypt(strings.Join(p.Sharing.Users, "\n")))
+	}
+	pref.SetInt(PrefShareMaxConns, p.Sharing.MaxConnections)
+}
+
+// splitLines returns the non-empty lines of s.
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
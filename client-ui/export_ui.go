@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/config"
+)
+
+const (
+	exportCurrentProfile = "current profile"
+	exportAllProfiles    = "all profiles"
+	exportOmitSecrets    = "omit secrets"
+	exportEncryptSecrets = "encrypt secrets with passphrase"
+	exportToFile         = "file"
+	exportToLink         = "link (copy to clipboard)"
+)
+
+// showExportDialog exports current profile or all profiles to a file or a wssocks-ustb:// link.
+func (p *ProfilesUI) showExportDialog() {
+	// save ui values first, so that they are also exported.
+	if p.OnSave != nil {
+		p.OnSave(p.Current())
+	}
+	uiProfiles := widget.NewSelect([]string{exportCurrentProfile, exportAllProfiles}, nil)
+	uiProfiles.SetSelected(exportCurrentProfile)
+	uiPassphrase := widget.NewPasswordEntry()
+	uiPassphrase.Disable()
+	uiSecrets := widget.NewRadioGroup([]string{exportOmitSecrets, exportEncryptSecrets}, func(s string) {
+		if s == exportEncryptSecrets {
+			uiPassphrase.Enable()
+		} else {
+			uiPassphrase.Disable()
+		}
+	})
+	uiSecrets.SetSelected(exportOmitSecrets)
+	uiTarget := widget.NewRadioGroup([]string{exportToFile, exportToLink}, nil)
+	uiTarget.SetSelected(exportToFile)
+
+	items := []*widget.FormItem{
+		{Text: "profiles", Widget: uiProfiles},
+		{Text: "secrets", Widget: uiSecrets},
+		{Text: "passphrase", Widget: uiPassphrase},
+		{Text: "export to", Widget: uiTarget},
+	}
+	dialog.ShowForm("Export profiles", "Export", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		names := []string{p.CurrentName()}
+		if uiProfiles.Selected == exportAllProfiles {
+			names = p.Names()
+		}
+		c := exportConfig(p.pref, names)
+		if uiSecrets.Selected == exportEncryptSecrets {
+			if uiPassphrase.Text == "" {
+				dialog.ShowError(errors.New("passphrase can not be empty"), p.win)
+				return
+			}
+			if keyLocked() {
+				dialog.ShowError(ErrKeyLocked, p.win)
+				return
+			}
+			if err := c.EncryptSecrets(uiPassphrase.Text); err != nil {
+				dialog.ShowError(err, p.win)
+				return
+			}
+		} else {
+			c.OmitSecrets()
+		}
+
+		if uiTarget.Selected == exportToLink {
+			link, err := c.Link()
+			if err != nil {
+				dialog.ShowError(err, p.win)
+				return
+			}
+			p.win.Clipboard().SetContent(link)
+			dialog.ShowInformation("Export profiles", "The link is copied to clipboard.", p.win)
+			return
+		}
+		data, err := c.Marshal()
+		if err != nil {
+			dialog.ShowError(err, p.win)
+			return
+		}
+		saveDialog := dialog.NewFileSave(func(w fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, p.win)
+				return
+			}
+			if w == nil {
+				return // cancelled
+			}
+			defer w.Close()
+			if _, err := w.Write(data); err != nil {
+				dialog.ShowError(err, p.win)
+			}
+		}, p.win)
+		saveDialog.SetFileName("wssocks-ustb.yaml")
+		saveDialog.Show()
+	}, p.win)
+}
+
+// showImportDialog imports profiles from a wssocks-ustb:// link, the pasted config content, or a config file.
+func (p *ProfilesUI) showImportDialog() {
+	uiLink := widget.NewMultiLineEntry()
+	uiLink.SetPlaceHolder(config.LinkScheme + "://import?config=...\n(leave it empty to choose a file)")
+	uiLink.Wrapping = fyne.TextWrapBreak
+	items := []*widget.FormItem{{Text: "link", Widget: uiLink}}
+	dialog.ShowForm("Import profiles", "Import", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		text := strings.TrimSpace(uiLink.Text)
+		if text == "" {
+			dialog.ShowFileOpen(func(r fyne.URIReadCloser, err error) {
+				if err != nil {
+					dialog.ShowError(err, p.win)
+					return
+				}
+				if r == nil {
+					return // cancelled
+				}
+				defer r.Close()
+				data, err := io.ReadAll(r)
+				if err != nil {
+					dialog.ShowError(err, p.win)
+					return
+				}
+				p.importData(config.Parse(data))
+			}, p.win)
+			return
+		}
+		if config.IsLink(text) {
+			p.importData(config.ParseLink(text))
+		} else {
+			p.importData(config.Parse([]byte(text)))
+		}
+	}, p.win)
+}
+
+// importData asks the passphrase if secrets are encrypted, and adds the profiles in c.
+func (p *ProfilesUI) importData(c *config.Config, err error) {
+	if err != nil {
+		dialog.ShowError(err, p.win)
+		return
+	}
+	if len(c.Profiles) == 0 {
+		dialog.ShowError(errors.New("no profile found"), p.win)
+		return
+	}
+	if !c.HasEncryptedSecrets() {
+		p.importConfig(c)
+		return
+	}
+	uiPassphrase := widget.NewPasswordEntry()
+	uiPassphrase.SetPlaceHolder("leave it empty to skip secrets")
+	items := []*widget.FormItem{{Text: "passphrase", Widget: uiPassphrase}}
+	dialog.ShowForm("Secrets are encrypted", "OK", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		if uiPassphrase.Text == "" {
+			c.OmitSecrets()
+		} else if err := c.DecryptSecrets(uiPassphrase.Text); err != nil {
+			dialog.ShowError(err, p.win)
+			return
+		}
+		p.importConfig(c)
+	}, p.win)
+}
+
+func (p *ProfilesUI) importConfig(c *config.Config) {
+	names, selected, err := importConfig(p.pref, c)
+	if err != nil {
+		dialog.ShowError(err, p.win)
+	}
+	if len(names) == 0 {
+		return
+	}
+	if p.CanSwitch == nil || p.CanSwitch() {
+		p.Switch(selected)
+	} else {
+		p.refresh()
+	}
+	dialog.ShowInformation("Import profiles", fmt.Sprintf("Imported profiles: %s", strings.Join(names, ", ")), p.win)
+}
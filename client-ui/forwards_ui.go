@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+)
+
+// ForwardsUI is a table of local port forwardings through the tunnel, one forwarding per row.
+type ForwardsUI struct {
+	rows    *fyne.Container
+	entries [][2]*widget.Entry // local and remote address of each row
+}
+
+func NewForwardsUI() *ForwardsUI {
+	return &ForwardsUI{rows: container.NewVBox()}
+}
+
+func (f *ForwardsUI) GetContainer() fyne.CanvasObject {
+	btnAdd := widget.NewButtonWithIcon("add", theme.ContentAddIcon(), func() {
+		f.addRow("", "")
+	})
+	header := container.NewGridWithColumns(2, widget.NewLabel("local address"), widget.NewLabel("remote address"))
+	return container.NewVBox(header, f.rows, btnAdd)
+}
+
+// addRow appends a row with the local and remote address.
+func (f *ForwardsUI) addRow(local, remote string) {
+	uiLocal := &widget.Entry{PlaceHolder: "e.g. 127.0.0.1:15432", Text: local}
+	uiRemote := &widget.Entry{PlaceHolder: "e.g. db.internal:5432", Text: remote}
+	entries := [2]*widget.Entry{uiLocal, uiRemote}
+	f.entries = append(f.entries, entries)
+
+	var row *fyne.Container
+	btnRemove := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		for i, e := range f.entries {
+			if e == entries {
+				f.entries = append(f.entries[:i], f.entries[i+1:]...)
+				break
+			}
+		}
+		f.rows.Remove(row)
+	})
+	row = container.NewBorder(nil, nil, nil, btnRemove, container.NewGridWithColumns(2, uiLocal, uiRemote))
+	f.rows.Add(row)
+}
+
+// Forwards returns the forwardings in the table, the empty rows are skipped.
+func (f *ForwardsUI) Forwards() ([]extra.Forward, error) {
+	var forwards []extra.Forward
+	for _, e := range f.entries {
+		forward := extra.Forward{Local: strings.TrimSpace(e[0].Text), Remote: strings.TrimSpace(e[1].Text)}
+		if forward.Local == "" && forward.Remote == "" {
+			continue
+		}
+		if err := forward.Validate(); err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, forward)
+	}
+	return forwards, nil
+}
+
+// Save saves the rows as lines of local=remote, the empty rows are skipped.
+func (f *ForwardsUI) Save(pref fyne.Preferences) {
+	var lines []string
+	for _, e := range f.entries {
+		local, remote := strings.TrimSpace(e[0].Text), strings.TrimSpace(e[1].Text)
+		if local != "" || remote != "" {
+			lines = append(lines, local+"="+remote)
+		}
+	}
+	pref.SetString(PrefForwards, strings.Join(lines, "\n"))
+}
+
+// Load resets the table and loads the rows from pref.
+func (f *ForwardsUI) Load(pref fyne.Preferences) {
+	f.entries = nil
+	f.rows.RemoveAll()
+	for _, line := range strings.Split(pref.String(PrefForwards), "\n") {
+		if local, remote, ok := strings.Cut(line, "="); ok {
+			f.addRow(local, remote)
+		}
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -18,7 +21,9 @@ import (
 	"fyne.io/fyne/v2/widget"
 	resource "github.com/rep1ace/wssocks-plugin-smu/client-ui/resources"
 	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/proxyenv"
 	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
+	"github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/nc"
 	pluginversion "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/version"
 	"github.com/genshen/wssocks/client"
 	"github.com/genshen/wssocks/version"
@@ -43,11 +48,8 @@ const (
 	btnStopping
 )
 
-const (
-	ProxyCommandGit = iota
-	ProxyCommandHttp
-	ProxyCommandSsh
-)
+// TextCopyPacURL is the item copying url of PAC file, following the kinds of proxy settings.
+const TextCopyPacURL = "PAC URL"
 
 const (
 	TextVpnAuthMethodPasswd = "Password"
@@ -78,6 +80,14 @@ func acquireLocalLock() bool {
 }
 
 func main() {
+	// the client binary itself is used as ssh ProxyCommand, see copyToClipboard.
+	if len(os.Args) > 1 && os.Args[1] == "nc" {
+		if err := nc.Main(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	if !acquireLocalLock() {
 		// show a simple error dialog or just exit
 		// Since we can't easily show a dialog without an app instance, we'll try to create a temp one
@@ -116,6 +126,10 @@ func main() {
 	wssApp.SetIcon(fyne.NewStaticResource("icon", appIconData))
 
 	w := wssApp.NewWindow(AppName)
+	// profiles of settings, preferences of old versions are migrated to the default profile.
+	profiles := NewProfilesUI(wssApp.Preferences(), w)
+	// load the key for saved secrets, and migrate secrets saved by old versions.
+	keyErr := loadEncryptionKey(wssApp.Storage().RootURI().Path(), wssApp.Preferences())
 	//w.SetFixedSize(true)
 	//w.Resize(fyne.NewSize(100, 100))
 
@@ -127,8 +141,9 @@ func main() {
 	uiHttpLocalAddr := &widget.Entry{PlaceHolder: "http listen address", Text: "127.0.0.1:1086"}
 	uiSkipTSLVerify := newCheckbox("", false, nil)
 	uiSaveToken := newCheckbox("save token", false, nil)
+	uiAutoReconnect := newCheckbox("", false, nil)
 
-	loadBasicPreference(wssApp.Preferences(), uiLocalAddr, uiRemoteAddr, uiHttpLocalAddr, uiAuthToken, uiHttpEnable, uiSkipTSLVerify, uiSaveToken)
+	loadBasicPreference(profiles.Current(), uiLocalAddr, uiRemoteAddr, uiHttpLocalAddr, uiAuthToken, uiHttpEnable, uiSkipTSLVerify, uiSaveToken, uiAutoReconnect)
 
 	uiHttpEnable.OnChanged = func(checked bool) {
 		if checked {
@@ -139,24 +154,64 @@ func main() {
 	}
 
 	// create vpn ui and necessary callbacks.
-	vpnUi, onLoadValue, onVpnClose := loadVpnUI(&wssApp)
+	vpnSettings, onLoadValue := loadVpnUI(&wssApp, profiles.Current())
+	routingUI := NewRoutingUI()
+	routingUI.Load(profiles.Current())
+	forwardsUI := NewForwardsUI()
+	forwardsUI.Load(profiles.Current())
+	sharingUI := NewSharingUI()
+	sharingUI.Load(profiles.Current())
 
 	btnStart := widget.NewButtonWithIcon("Start", theme.MailSendIcon(), nil)
 	btnStart.Importance = widget.HighImportance
 
 	btnStatus := btnStopped
 	var handles extra.TaskHandles
-	var ignoreWaitErr = true
+	statsUI := NewStatsUI(&handles)
+	// the button state is driven by the lifecycle events of the client.
+	handles.Subscribe(func(e extra.Event) {
+		fyne.Do(func() {
+			switch e.Phase {
+			case extra.PhaseLoggingIn:
+				btnStart.SetText("Logging in")
+			case extra.PhaseCaptcha:
+				btnStart.SetText("Captcha")
+			case extra.PhaseNegotiating:
+				btnStart.SetText("Negotiating")
+			case extra.PhaseReconnecting:
+				// the client can still be stopped while reconnecting.
+				btnStart.SetText("Reconnecting")
+			case extra.PhaseConnected:
+				btnStart.SetText("Stop")
+				btnStatus = btnRunning
+				statsUI.Start()
+				if err := routingUI.SetSystemProxy(); err != nil {
+					dialog.ShowError(fmt.Errorf("set system proxy failed: %w", err), w)
+				}
+			case extra.PhaseDisconnected:
+				// the connection is lost, but not stopped by user.
+				dialog.ShowError(e.Err, w)
+			case extra.PhaseStopped:
+				btnStart.SetText("Start")
+				btnStatus = btnStopped
+				statsUI.Stop()
+				if err := routingUI.RestoreSystemProxy(); err != nil {
+					dialog.ShowError(fmt.Errorf("restore system proxy failed: %w", err), w)
+				}
+				routingUI.Stop()
+			}
+		})
+	})
 	btnStart.OnTapped = func() {
 		if btnStatus == btnRunning { // running can stop
 			btnStatus = btnStopping
-			ignoreWaitErr = true
 			btnStart.SetText("Stopping")
 			handles.NotifyCloseWrapper()
 			btnStart.SetText("Start")
 			btnStatus = btnStopped
 		} else if btnStatus == btnStopped { // stopped can run
-			if vpnUiValue := onLoadValue(); vpnUiValue.Enable && vpnUiValue.PasswdAuth.Password == "" {
+			// the password can be empty if it is provided by credential helper.
+			if vpnUiValue := onLoadValue(); vpnUiValue.Enable && vpnUiValue.PasswdAuth.Password == "" && vpnUiValue.CredentialHelper == "" {
 				dialog.ShowInformation("Error", "Please input vpn password", w)
 				return
 			}
@@ -167,9 +222,25 @@ func main() {
 					LocalHttpAddr:   uiHttpLocalAddr.Text,
 					SkipTLSVerify:   uiSkipTSLVerify.Checked,
 				},
-				UstbVpn:    onLoadValue(),
-				RemoteAddr: uiRemoteAddr.Text,
-				AuthToken:  uiAuthToken.Text,
+				UstbVpn:       onLoadValue(),
+				RemoteAddr:    uiRemoteAddr.Text,
+				AuthToken:     uiAuthToken.Text,
+				AutoReconnect: extra.AutoReconnectOptions{Enable: uiAutoReconnect.Checked},
+			}
+			forwards, err := forwardsUI.Forwards()
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			options.Forwards = forwards
+			if options.Sharing, err = sharingUI.Sharing(); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			// the PAC file and dns are served and the rules file is watched until the client is stopped.
+			if err := routingUI.Start(&options, handles.DialTunnel); err != nil {
+				dialog.ShowError(err, w)
+				return
 			}
 			btnStatus = btnStarting
 			btnStart.SetText("Loading")
@@ -177,33 +248,13 @@ func main() {
 			// Run connection in a goroutine to avoid blocking UI (especially for captcha)
 			go func() {
 				if err := handles.StartWssocks(options); err != nil {
-					// log error
 					fyne.Do(func() {
 						dialog.ShowError(err, w)
-						btnStart.SetText("Start")
-						btnStatus = btnStopped
 					})
 					return
 				}
-				fyne.Do(func() {
-					btnStart.SetText("Stop")
-					btnStatus = btnRunning
-				})
-
-				// Wait for connection to close
-				// the `ignoreWaitErr` the same as swiftui.
-				ignoreWaitErr = false
-				// wait error and stop the client
-				if err := handles.Wait(); err != nil && !ignoreWaitErr {
-					fyne.Do(func() {
-						dialog.ShowError(err, w)
-					})
-				}
-				fyne.Do(func() {
-					btnStart.SetText("Start")
-					btnStatus = btnStopped
-				})
-				ignoreWaitErr = true
+				// wait for connection to close, errors are shown by the lifecycle events.
+				_ = handles.Wait()
 			}()
 		}
 	}
@@ -231,20 +282,40 @@ func main() {
 		{Text: "http(s) proxy", Widget: uiHttpEnable},
 		{Text: "http(s) address", Widget: uiHttpLocalAddr},
 		{Text: "skip TSL verify", Widget: uiSkipTSLVerify},
+		{Text: "auto reconnect", Widget: uiAutoReconnect},
 	}}
 
+	copyProxySettings := func(kind string) {
+		if kind == TextCopyPacURL {
+			routingUI.CopyPacURL(w)
+			return
+		}
+		httpAddr := ""
+		if uiHttpEnable.Checked {
+			httpAddr = uiHttpLocalAddr.Text
+		}
+		copyToClipboard(kind, proxyenv.Options{
+			Socks5Addr: uiLocalAddr.Text,
+			HttpAddr:   httpAddr,
+			NcCommand:  ncCommand(uiLocalAddr.Text),
+			Hosts:      routingUI.Hosts(),
+		}, w)
+	}
+	var copyTitles []string
+	for _, kind := range proxyenv.Kinds {
+		copyTitles = append(copyTitles, proxyenv.Title(kind))
+	}
+	copyTitles = append(copyTitles, TextCopyPacURL)
 	selectCopyProxyCommand := container.NewBorder(nil, nil, nil, nil,
-		NewWSelectWithCopyProxyCommand([]string{"git", "http/https", "ssh/sftp/scp"},
+		NewWSelectWithCopyProxyCommand(copyTitles,
 			func(sel *widget.Select, value string) {
 				if value != "" {
+					i := sel.SelectedIndex()
 					sel.ClearSelected()
-					switch value {
-					case "git":
-						copyToClipboard(ProxyCommandGit, uiLocalAddr.Text, uiHttpLocalAddr.Text, w)
-					case "http/https":
-						copyToClipboard(ProxyCommandHttp, uiLocalAddr.Text, uiHttpLocalAddr.Text, w)
-					case "ssh/sftp/scp":
-						copyToClipboard(ProxyCommandSsh, uiLocalAddr.Text, uiHttpLocalAddr.Text, w)
+					if i >= 0 && i < len(proxyenv.Kinds) {
+						copyProxySettings(proxyenv.Kinds[i])
+					} else {
+						copyProxySettings(TextCopyPacURL)
 					}
 				}
 			},
@@ -252,11 +323,16 @@ func main() {
 	)
 
 	w.SetContent(container.NewVBox(
+		profiles.GetContainer(),
 		container.NewAppTabs(
 			container.NewTabItem("Basic", widget.NewCard("", "wssocks settings", basicUi)),
 			container.NewTabItem("SMU VPN", container.NewVBox(
-				widget.NewCard("", "SMU VPN settings", vpnUi)),
+				widget.NewCard("", "SMU VPN settings", vpnSettings.GetContainer())),
 			),
+			container.NewTabItem("Routing", widget.NewCard("", "split routing", routingUI.GetContainer())),
+			container.NewTabItem("Forwards", widget.NewCard("", "port forwarding through the tunnel", forwardsUI.GetContainer())),
+			container.NewTabItem("Sharing", widget.NewCard("", "share the tunnel in LAN", sharingUI.GetContainer())),
+			container.NewTabItem("Stats", widget.NewCard("", "traffic statistics", statsUI.GetContainer())),
 		),
 		btnStart,
 		selectCopyProxyCommand,
@@ -278,6 +354,9 @@ func main() {
 				container.NewHBox(
 					layout.NewSpacer(),
 					widget.NewToolbar(
+						widget.NewToolbarAction(theme.SettingsIcon(), func() {
+							showMasterPassphraseDialog(w, wssApp.Preferences())
+						}),
 						widget.NewToolbarAction(theme.HelpIcon(), func() {
 							if err := fyne.CurrentApp().OpenURL(docUrl); err != nil {
 								dialog.ShowError(fmt.Errorf("open link %s failed", docUrl), w)
@@ -289,19 +368,69 @@ func main() {
 		),
 	))
 
+	saveProfile := func(pref fyne.Preferences) {
+		saveBasicPreference(pref, uiLocalAddr, uiRemoteAddr, uiHttpLocalAddr, uiAuthToken, uiHttpEnable, uiSkipTSLVerify, uiSaveToken, uiAutoReconnect)
+		vpnSettings.Save(pref)
+		routingUI.Save(pref)
+		forwardsUI.Save(pref)
+		sharingUI.Save(pref)
+	}
 	savePreferences := func() {
-		if btnStatus == btnRunning { // running can stop
+		saveProfile(profiles.Current())
+	}
+
+	// load saved secrets, and show a warning if any of them can not be decrypted.
+	loadSecrets := func(pref fyne.Preferences) {
+		var warnings []string
+		if err := loadTokenPreference(pref, uiAuthToken); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+		if err := vpnSettings.LoadSecrets(pref); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+		if err := sharingUI.LoadSecrets(pref); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+		if len(warnings) != 0 {
+			dialog.ShowInformation("Warning", strings.Join(warnings, "\n"), w)
 		}
-		saveBasicPreference(wssApp.Preferences(), uiLocalAddr, uiRemoteAddr, uiHttpLocalAddr, uiAuthToken, uiHttpEnable, uiSkipTSLVerify, uiSaveToken)
-		onVpnClose()
 	}
+	// load a profile to ui, after resetting ui to default values.
+	loadProfile := func(pref fyne.Preferences) {
+		uiLocalAddr.SetText("127.0.0.1:1080")
+		uiRemoteAddr.SetText("")
+		uiAuthToken.SetText("")
+		uiHttpEnable.SetChecked(false)
+		uiHttpLocalAddr.SetText("127.0.0.1:1086")
+		uiSkipTSLVerify.SetChecked(false)
+		uiAutoReconnect.SetChecked(false)
+		uiSaveToken.SetChecked(false)
+		loadBasicPreference(pref, uiLocalAddr, uiRemoteAddr, uiHttpLocalAddr, uiAuthToken, uiHttpEnable, uiSkipTSLVerify, uiSaveToken, uiAutoReconnect)
+		vpnSettings.Load(pref)
+		routingUI.Load(pref)
+		forwardsUI.Load(pref)
+		sharingUI.Load(pref)
+		if !keyLocked() {
+			loadSecrets(pref)
+		}
+	}
+	profiles.CanSwitch = func() bool {
+		return btnStatus == btnStopped
+	}
+	profiles.OnSave = saveProfile
+	profiles.OnLoad = loadProfile
 
 	if desk, ok := wssApp.(desktop.App); ok {
 		m := fyne.NewMenu(AppName,
 			fyne.NewMenuItem("Show Window", func() {
 				w.Show()
 			}),
-			fyne.NewMenuItem("Copy Proxy Command", nil),
+			fyne.NewMenuItem("Profiles", nil),
+			fyne.NewMenuItem("Copy Proxy Settings", nil),
+			fyne.NewMenuItem("Master Passphrase", func() {
+				w.Show()
+				showMasterPassphraseDialog(w, wssApp.Preferences())
+			}),
 			fyne.NewMenuItem("Exit", func() {
 				// Stop if running
 				if btnStatus == btnRunning {
@@ -309,23 +438,42 @@ func main() {
 					btnStart.SetText("Stopping")
 					handles.NotifyCloseWrapper()
 				}
+				// the app may quit before the client is stopped.
+				routingUI.RestoreSystemProxy()
 				savePreferences()
 				wssApp.Quit()
 			}),
 		)
-		m.Items[1].ChildMenu = fyne.NewMenu("",
-			fyne.NewMenuItem("Git", func() {
-				copyToClipboard(ProxyCommandGit, uiLocalAddr.Text, uiHttpLocalAddr.Text, w)
-			}),
-			fyne.NewMenuItem("HTTP/HTTPS", func() {
-				copyToClipboard(ProxyCommandHttp, uiLocalAddr.Text, uiHttpLocalAddr.Text, w)
-			}),
-			fyne.NewMenuItem("SSH/SFTP/SCP", func() {
-				copyToClipboard(ProxyCommandSsh, uiLocalAddr.Text, uiHttpLocalAddr.Text, w)
-			}),
-		)
+		m.Items[1].ChildMenu = profiles.TrayMenu()
+		var copyItems []*fyne.MenuItem
+		for _, kind := range append(append([]string{}, proxyenv.Kinds...), TextCopyPacURL) {
+			kind := kind
+			copyItems = append(copyItems, fyne.NewMenuItem(proxyenv.Title(kind), func() {
+				copyProxySettings(kind)
+			}))
+		}
+		m.Items[2].ChildMenu = fyne.NewMenu("", copyItems...)
 		desk.SetSystemTrayMenu(m)
 		desk.SetSystemTrayWindow(w)
+		// update profiles in tray menu
+		profiles.OnChanged = func() {
+			m.Items[1].ChildMenu = profiles.TrayMenu()
+			m.Refresh()
+		}
+	}
+
+	// restore the system proxy settings left by a crash.
+	if err := routingUI.RestoreSystemProxy(); err != nil {
+		dialog.ShowError(fmt.Errorf("restore system proxy failed: %w", err), w)
+	}
+	if keyErr != nil {
+		dialog.ShowError(fmt.Errorf("load key of saved secrets failed: %w", keyErr), w)
+	} else if keyLocked() {
+		showUnlockDialog(w, func() {
+			loadSecrets(profiles.Current())
+		})
+	} else {
+		loadSecrets(profiles.Current())
 	}
 
 	w.SetCloseIntercept(func() {
@@ -340,6 +488,7 @@ func main() {
 			btnStart.SetText("Stopping")
 			handles.NotifyCloseWrapper()
 		}
+		routingUI.RestoreSystemProxy()
 		savePreferences()
 	})
 	//w.SetOnClosed() todo
@@ -347,13 +496,12 @@ func main() {
 }
 
 // loadVpnUI creates ui for ustb vpn, including auth method selection and the input box.
-// it returns callback function: onAppClose for saving preference,
-// loadUiValue for loading value from the input box.
-func loadVpnUI(wssApp *fyne.App) (*fyne.Container, func() vpn.UstbVpn, func()) {
+// it returns the vpn settings ui (for saving and loading preference),
+// and callback function loadUiValue for loading value from the input box.
+func loadVpnUI(wssApp *fyne.App, pref fyne.Preferences) (*VpnSettingsUI, func() vpn.UstbVpn) {
 	// the vpn UI and vpn settings UI
-	vpnSettings := VpnSettingsUI{}
-	vpnSettings.Init((*wssApp).Preferences())
-	vpnUi := vpnSettings.GetContainer()
+	vpnSettings := &VpnSettingsUI{}
+	vpnSettings.Init(pref)
 
 	loadUiValues := func() vpn.UstbVpn {
 		vals := vpn.UstbVpn{
@@ -424,17 +572,14 @@ func loadVpnUI(wssApp *fyne.App) (*fyne.Container, func() vpn.UstbVpn, func()) {
 		vpnSettings.LoadSettingsValues(&vals)
 		return vals
 	}
-	onVpnClose := func() {
-		vpnSettings.Save((*wssApp).Preferences())
-	}
-	return vpnUi, loadUiValues, onVpnClose
+	return vpnSettings, loadUiValues
 }
 
 // NewWSelectWithCopyProxyCommand is copied from widget.NewSelect.
 func NewWSelectWithCopyProxyCommand(options []string, changed func(sel *widget.Select, val string)) *widget.Select {
 	s := &widget.Select{
 		Options:     options,
-		PlaceHolder: "(copy proxy command)",
+		PlaceHolder: "(copy proxy settings)",
 	}
 	s.OnChanged = func(val string) {
 		changed(s, val)
@@ -443,27 +588,25 @@ func NewWSelectWithCopyProxyCommand(options []string, changed func(sel *widget.S
 	return s
 }
 
-func copyToClipboard(category int, socksAddr string, httpAddr string, win fyne.Window) {
-	var text = ""
-	var nc = "nc -x" // darwin or linux
-	var ncCmdType = "ncat"
-	if runtime.GOOS == "windows" {
-		nc = "connect -S"
-	}
-	switch category {
-	case ProxyCommandGit:
-		text = fmt.Sprintf("export GIT_SSH_COMMAND=\"ssh -o ProxyCommand='%s %s %%h %%p' \"", nc, socksAddr)
-		break
-	case ProxyCommandHttp:
-		text = fmt.Sprintf("export https_proxy=http://%s http_proxy=http://%s", socksAddr, httpAddr)
-		break
-	case ProxyCommandSsh:
-		if runtime.GOOS == "windows" && ncCmdType == "ncat" {
-			text = fmt.Sprintf("ssh -o ProxyCommand='ncat --proxy %s --proxy-type socks5 %%h %%p'", socksAddr)
-		} else {
-			text = fmt.Sprintf("ssh -o ProxyCommand='%s %s %%h %%p'", nc, socksAddr)
-		}
-		break
+// copyToClipboard copies the proxy settings of kind (see proxyenv.Kinds) to clipboard.
+func copyToClipboard(kind string, options proxyenv.Options, win fyne.Window) {
+	text, err := proxyenv.Generate(kind, options)
+	if err != nil {
+		dialog.ShowError(err, win)
+		return
 	}
 	win.Clipboard().SetContent(text)
 }
+
+// ncCommand returns the built-in nc command of this binary (see main) using the socks5 proxy,
+// so that the ProxyCommand does not depend on nc, connect or ncat installed on the machine.
+func ncCommand(socksAddr string) string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	if runtime.GOOS == "windows" {
+		exe = filepath.ToSlash(exe) // the command may be run by sh, e.g. in git bash
+	}
+	return proxyenv.NcCommand(exe, socksAddr)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/genshen/wssocks/client"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/pac"
+)
+
+// PacUI holds the settings of PAC file, and serves it while the client is running.
+type PacUI struct {
+	uiPacAddr  *widget.Entry
+	uiPacRules *widget.Entry
+	server     *pac.Server
+}
+
+func NewPacUI() *PacUI {
+	rules := widget.NewMultiLineEntry()
+	rules.PlaceHolder = "domains and ipv4 networks using the proxy,\none per line, e.g.\n*.smu.edu.cn\n10.0.0.0/8"
+	rules.SetMinRowsVisible(4)
+	return &PacUI{
+		uiPacAddr:  &widget.Entry{PlaceHolder: "(optional) e.g. 127.0.0.1:1087"},
+		uiPacRules: rules,
+	}
+}
+
+// FormItems returns the items of PAC settings, which are shown in the form of Routing tab.
+func (p *PacUI) FormItems() []*widget.FormItem {
+	return []*widget.FormItem{
+		{Text: "PAC address", Widget: p.uiPacAddr},
+		{Text: "PAC rules", Widget: p.uiPacRules},
+	}
+}
+
+func (p *PacUI) Save(pref fyne.Preferences) {
+	pref.SetString(PrefPacAddr, strings.TrimSpace(p.uiPacAddr.Text))
+	pref.SetString(PrefPacRules, strings.Join(p.Rules(), "\n"))
+}
+
+// Load resets the settings and loads them from pref.
+func (p *PacUI) Load(pref fyne.Preferences) {
+	p.uiPacAddr.SetText(pref.String(PrefPacAddr))
+	p.uiPacRules.SetText(pref.String(PrefPacRules))
+}
+
+// Rules returns the rules of PAC file.
+func (p *PacUI) Rules() []string {
+	return pac.ParseRules(p.uiPacRules.Text)
+}
+
+// Check checks the rules before starting the client.
+func (p *PacUI) Check() error {
+	return pac.ValidateRules(p.Rules())
+}
+
+// Start serves the PAC file for the local listeners in options, if the PAC address is set.
+// Both Start and Stop must be called in ui thread.
+func (p *PacUI) Start(options client.Options) error {
+	addr := strings.TrimSpace(p.uiPacAddr.Text)
+	if addr == "" || p.server != nil {
+		return nil
+	}
+	script, err := pac.Generate(p.Rules(), options)
+	if err != nil {
+		return err
+	}
+	server, err := pac.Listen(addr, script)
+	if err != nil {
+		return err
+	}
+	go server.Serve()
+	p.server = server
+	return nil
+}
+
+// Stop stops serving the PAC file.
+func (p *PacUI) Stop() {
+	if p.server == nil {
+		return
+	}
+	p.server.Close()
+	p.server = nil
+}
+
+// ServingURL returns the url of PAC file if it is being served, or an empty string.
+func (p *PacUI) ServingURL() string {
+	if p.server == nil {
+		return ""
+	}
+	return pac.URL(p.server.Addr())
+}
+
+// CopyURL copies the url of PAC file to clipboard.
+func (p *PacUI) CopyURL(win fyne.Window) {
+	addr := strings.TrimSpace(p.uiPacAddr.Text)
+	if addr == "" {
+		dialog.ShowError(errors.New("PAC address is not set (see Routing tab)"), win)
+		return
+	}
+	win.Clipboard().SetContent(pac.URL(addr))
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 
 	"fyne.io/fyne/v2"
@@ -15,7 +17,10 @@ const (
 	PrefHttpEnable     = "http_enable"
 	PrefHttpLocalAddr  = "http_local_addr"
 	PrefSkipTSLVerify  = "skip_TSL_verify"
+	PrefAutoReconnect  = "auto_reconnect"
 	PrefVpnEnable      = "vpn_enable"
+	PrefVpnAuto        = "vpn_auto"
+	PrefVpnDirectProbe = "vpn_direct_probe"
 	PrefVpnAuthMethod  = "auth_method"
 	PrefVpnForceLogout = "vpn_force_logout"
 	PrefVpnHostEncrypt = "vpn_host_encrypt"
@@ -23,20 +28,36 @@ const (
 	PrefVpnUsername    = "vpn_username"
 	PrefVpnPassword    = "vpn_password"
 	PrefSaveVpnPwd     = "save_vpn_password"
+	PrefVpnCredHelper  = "vpn_credential_helper"
 	PrefAuthToken      = "auth_token"
 	PrefSaveToken      = "save_token"
+	PrefRouteFile      = "route_file"
+	PrefForwards       = "forwards" // one local=remote per line
+	PrefPacAddr        = "pac_addr"
+	PrefPacRules       = "pac_rules" // one rule per line
+	PrefDnsAddr        = "dns_addr"
+	PrefDnsZones       = "dns_zones" // separated by commas
+	PrefDnsUpstream    = "dns_upstream"
+	PrefDnsFallback    = "dns_fallback"
+	PrefSystemProxy    = "system_proxy"
+	PrefShareAllow     = "share_allow" // one CIDR or IP per line
+	PrefShareUsers     = "share_users" // encrypted, one username:password per line
+	PrefShareMaxConns  = "share_max_conns"
 )
 
 func saveBasicPreference(pref fyne.Preferences, uiLocalAddr, uiRemoteAddr,
 	uiHttpLocalAddr, uiAuthToken *widget.Entry, uiHttpEnable *widget.Check,
-	uiSkipTSLVerify, uiSaveToken *widget.Check) {
+	uiSkipTSLVerify, uiSaveToken, uiAutoReconnect *widget.Check) {
 	pref.SetBool(PrefHasPreference, true)
 	pref.SetString(PrefLocalAddr, uiLocalAddr.Text)
 	pref.SetString(PrefRemoteAddr, uiRemoteAddr.Text)
 
 	pref.SetBool(PrefSaveToken, uiSaveToken.Checked)
 	if uiSaveToken.Checked {
-		pref.SetString(PrefAuthToken, encrypt(uiAuthToken.Text))
+		// keep the saved token if it is locked by master passphrase
+		if !keyLocked() {
+			pref.SetString(PrefAuthToken, encrypt(uiAuthToken.Text))
+		}
 	} else {
 		pref.SetString(PrefAuthToken, "")
 	}
@@ -44,11 +65,14 @@ func saveBasicPreference(pref fyne.Preferences, uiLocalAddr, uiRemoteAddr,
 	pref.SetBool(PrefHttpEnable, uiHttpEnable.Checked)
 	pref.SetString(PrefHttpLocalAddr, uiHttpLocalAddr.Text)
 	pref.SetBool(PrefSkipTSLVerify, uiSkipTSLVerify.Checked)
+	pref.SetBool(PrefAutoReconnect, uiAutoReconnect.Checked)
 }
 
 func saveVPNMainPreference(pref fyne.Preferences,
-	uiVpnEnable *widget.Check) {
+	uiVpnEnable, uiVpnAuto *widget.Check, uiDirectProbe *widget.Entry) {
 	pref.SetBool(PrefVpnEnable, uiVpnEnable.Checked)
+	pref.SetBool(PrefVpnAuto, uiVpnAuto.Checked)
+	pref.SetString(PrefVpnDirectProbe, strings.TrimSpace(uiDirectProbe.Text))
 }
 
 func saveVPNPreference(pref fyne.Preferences, uiVpnForceLogout, uiVpnHostEncrypt, uiSaveVpnPwd *widget.Check,
@@ -62,7 +86,9 @@ func saveVPNPreference(pref fyne.Preferences, uiVpnForceLogout, uiVpnHostEncrypt
 	pref.SetBool(PrefSaveVpnPwd, uiSaveVpnPwd.Checked)
 	pref.SetString(PrefVpnHostInput, uiVpnHostInput.Text)
 	pref.SetString(PrefVpnUsername, uiVpnUsername.Text)
-	if uiSaveVpnPwd.Checked && uiVpnPassword.Text != "" {
+	if uiSaveVpnPwd.Checked && keyLocked() {
+		// keep the saved password if it is locked by master passphrase
+	} else if uiSaveVpnPwd.Checked && uiVpnPassword.Text != "" {
 		pref.SetString(PrefVpnPassword, encrypt(uiVpnPassword.Text))
 	} else {
 		pref.SetString(PrefVpnPassword, "")
@@ -70,9 +96,16 @@ func saveVPNPreference(pref fyne.Preferences, uiVpnForceLogout, uiVpnHostEncrypt
 	pref.SetInt(PrefVpnAuthMethod, vpn.VpnAuthMethodPasswd)
 }
 
+func saveVpnCredHelperPreference(pref fyne.Preferences, uiCredHelper *widget.Entry) {
+	if !pref.Bool(PrefHasPreference) {
+		return
+	}
+	pref.SetString(PrefVpnCredHelper, strings.TrimSpace(uiCredHelper.Text))
+}
+
 func loadBasicPreference(pref fyne.Preferences, uiLocalAddr, uiRemoteAddr,
 	uiHttpLocalAddr, uiAuthToken *widget.Entry, uiHttpEnable *widget.Check,
-	uiSkipTSLVerify, uiSaveToken *widget.Check) {
+	uiSkipTSLVerify, uiSaveToken, uiAutoReconnect *widget.Check) {
 	if !pref.Bool(PrefHasPreference) {
 
 		uiHttpLocalAddr.Disable()
@@ -100,23 +133,22 @@ func loadBasicPreference(pref fyne.Preferences, uiLocalAddr, uiRemoteAddr,
 	if pref.Bool(PrefSkipTSLVerify) {
 		uiSkipTSLVerify.SetChecked(true)
 	}
-
-	// auth token
-	if saveToken := pref.Bool(PrefSaveToken); saveToken {
-		uiSaveToken.SetChecked(true)
-		if token := pref.String(PrefAuthToken); token != "" {
-			uiAuthToken.SetText(decrypt(token))
-		}
-	} else {
-		uiSaveToken.SetChecked(false)
+	// auto reconnect (default false)
+	if pref.Bool(PrefAutoReconnect) {
+		uiAutoReconnect.SetChecked(true)
 	}
 
+	// auth token, it is loaded in loadTokenPreference.
+	uiSaveToken.SetChecked(pref.Bool(PrefSaveToken))
+
 	if !uiHttpEnable.Checked {
 		uiHttpLocalAddr.Disable()
+	} else {
+		uiHttpLocalAddr.Enable()
 	}
 }
 
-func loadVPNMainPreference(pref fyne.Preferences, uiVpnEnable *widget.Check) {
+func loadVPNMainPreference(pref fyne.Preferences, uiVpnEnable, uiVpnAuto *widget.Check, uiDirectProbe *widget.Entry) {
 	if !pref.Bool(PrefHasPreference) {
 		return
 	}
@@ -124,6 +156,9 @@ func loadVPNMainPreference(pref fyne.Preferences, uiVpnEnable *widget.Check) {
 	if enable := pref.Bool(PrefVpnEnable); !enable {
 		uiVpnEnable.SetChecked(enable) // toggle default value
 	} // else, default value(true) or preference is true, dont touch it.
+	// vpn auto mode (default false)
+	uiVpnAuto.SetChecked(pref.Bool(PrefVpnAuto))
+	uiDirectProbe.SetText(pref.String(PrefVpnDirectProbe))
 
 }
 
@@ -148,12 +183,48 @@ func loadVpnPreference(pref fyne.Preferences, uiVpnForceLogout, uiVpnHostEncrypt
 	if username := pref.String(PrefVpnUsername); strings.TrimSpace(username) != "" {
 		uiVpnUsername.SetText(strings.TrimSpace(username))
 	}
-	if savePwd := pref.Bool(PrefSaveVpnPwd); savePwd {
-		uiSaveVpnPwd.SetChecked(true)
-		if password := pref.String(PrefVpnPassword); password != "" {
-			uiVpnPassword.SetText(decrypt(password))
-		}
-	} else {
-		uiSaveVpnPwd.SetChecked(false)
+	// password is loaded in loadVpnPasswordPreference.
+	uiSaveVpnPwd.SetChecked(pref.Bool(PrefSaveVpnPwd))
+}
+
+// ErrSecretDecrypt is returned if a saved secret can not be decrypted.
+var ErrSecretDecrypt = errors.New("can not be decrypted, please input it again")
+
+// loadTokenPreference decrypts the saved auth token.
+// It is separated from loadBasicPreference, because the key may be locked by master passphrase at startup.
+func loadTokenPreference(pref fyne.Preferences, uiAuthToken *widget.Entry) error {
+	uiAuthToken.SetText("")
+	if !pref.Bool(PrefHasPreference) || !pref.Bool(PrefSaveToken) {
+		return nil
+	}
+	return loadSecretPreference(pref, PrefAuthToken, "auth token", uiAuthToken)
+}
+
+// loadVpnPasswordPreference decrypts the saved vpn password.
+func loadVpnPasswordPreference(pref fyne.Preferences, uiVpnPassword *widget.Entry) error {
+	uiVpnPassword.SetText("")
+	if !pref.Bool(PrefHasPreference) || !pref.Bool(PrefSaveVpnPwd) {
+		return nil
+	}
+	return loadSecretPreference(pref, PrefVpnPassword, "vpn password", uiVpnPassword)
+}
+
+func loadSecretPreference(pref fyne.Preferences, prefKey, name string, entry *widget.Entry) error {
+	ciphertext := pref.String(prefKey)
+	if ciphertext == "" {
+		return nil
+	}
+	plaintext, err := decrypt(ciphertext)
+	if err != nil {
+		return fmt.Errorf("saved %s %w", name, ErrSecretDecrypt)
+	}
+	entry.SetText(plaintext)
+	return nil
+}
+
+func loadVpnCredHelperPreference(pref fyne.Preferences, uiCredHelper *widget.Entry) {
+	if !pref.Bool(PrefHasPreference) {
+		return
 	}
+	uiCredHelper.SetText(pref.String(PrefVpnCredHelper))
 }
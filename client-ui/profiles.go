@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"fyne.io/fyne/v2"
+)
+
+const (
+	PrefProfiles       = "profiles"        // names of all profiles
+	PrefCurrentProfile = "current_profile" // name of selected profile
+	DefaultProfileName = "default"
+)
+
+// preference keys saved in each profile, grouped by value type.
+var (
+	profileBoolKeys = []string{PrefHasPreference, PrefHttpEnable, PrefSkipTSLVerify, PrefVpnEnable,
+		PrefVpnForceLogout, PrefVpnHostEncrypt, PrefSaveVpnPwd, PrefSaveToken, PrefAutoReconnect, PrefVpnAuto,
+		PrefSystemProxy}
+	profileStringKeys = []string{PrefLocalAddr, PrefRemoteAddr, PrefHttpLocalAddr, PrefVpnHostInput,
+		PrefVpnUsername, PrefVpnPassword, PrefVpnCredHelper, PrefAuthToken, PrefVpnDirectProbe, PrefRouteFile,
+		PrefForwards, PrefPacAddr, PrefPacRules, PrefDnsAddr, PrefDnsZones, PrefDnsUpstream, PrefDnsFallback,
+		PrefShareAllow, PrefShareUsers}
+	profileIntKeys = []string{PrefVpnAuthMethod, PrefShareMaxConns}
+)
+
+// ProfilePreferences is the preferences of one profile.
+// It adds the profile prefix to keys, so the existing preference load/save functions can be used for a profile.
+type ProfilePreferences struct {
+	fyne.Preferences
+	prefix string
+}
+
+func newProfilePreferences(pref fyne.Preferences, name string) *ProfilePreferences {
+	return &ProfilePreferences{Preferences: pref, prefix: "profile." + name + "."}
+}
+
+func (p *ProfilePreferences) Bool(key string) bool {
+	return p.Preferences.Bool(p.prefix + key)
+}
+
+func (p *ProfilePreferences) BoolWithFallback(key string, fallback bool) bool {
+	return p.Preferences.BoolWithFallback(p.prefix+key, fallback)
+}
+
+func (p *ProfilePreferences) SetBool(key string, value bool) {
+	p.Preferences.SetBool(p.prefix+key, value)
+}
+
+func (p *ProfilePreferences) Int(key string) int {
+	return p.Preferences.Int(p.prefix + key)
+}
+
+func (p *ProfilePreferences) IntWithFallback(key string, fallback int) int {
+	return p.Preferences.IntWithFallback(p.prefix+key, fallback)
+}
+
+func (p *ProfilePreferences) SetInt(key string, value int) {
+	p.Preferences.SetInt(p.prefix+key, value)
+}
+
+func (p *ProfilePreferences) String(key string) string {
+	return p.Preferences.String(p.prefix + key)
+}
+
+func (p *ProfilePreferences) StringWithFallback(key, fallback string) string {
+	return p.Preferences.StringWithFallback(p.prefix+key, fallback)
+}
+
+func (p *ProfilePreferences) SetString(key string, value string) {
+	p.Preferences.SetString(p.prefix+key, value)
+}
+
+func (p *ProfilePreferences) RemoveValue(key string) {
+	p.Preferences.RemoveValue(p.prefix + key)
+}
+
+// migrateProfiles moves the preferences saved by old versions (without profiles) to the default profile.
+// If there is no preference, an empty default profile is created.
+func migrateProfiles(pref fyne.Preferences) {
+	if len(profileNames(pref)) != 0 {
+		return
+	}
+	if pref.Bool(PrefHasPreference) {
+		dst := newProfilePreferences(pref, DefaultProfileName)
+		copyProfile(pref, dst)
+		removeProfileValues(pref)
+	}
+	pref.SetStringList(PrefProfiles, []string{DefaultProfileName})
+	pref.SetString(PrefCurrentProfile, DefaultProfileName)
+}
+
+func profileNames(pref fyne.Preferences) []string {
+	return pref.StringList(PrefProfiles)
+}
+
+// currentProfileName returns the name of selected profile, or the first profile if it is not found.
+func currentProfileName(pref fyne.Preferences) string {
+	names := profileNames(pref)
+	current := pref.String(PrefCurrentProfile)
+	for _, name := range names {
+		if name == current {
+			return current
+		}
+	}
+	if len(names) != 0 {
+		return names[0]
+	}
+	return DefaultProfileName
+}
+
+// allProfilePreferences returns preferences of all profiles.
+func allProfilePreferences(pref fyne.Preferences) []fyne.Preferences {
+	var prefs []fyne.Preferences
+	for _, name := range profileNames(pref) {
+		prefs = append(prefs, newProfilePreferences(pref, name))
+	}
+	return prefs
+}
+
+func checkProfileName(pref fyne.Preferences, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("profile name can not be empty")
+	}
+	for _, n := range profileNames(pref) {
+		if n == name {
+			return fmt.Errorf("profile `%s` already exists", name)
+		}
+	}
+	return nil
+}
+
+// createProfile adds an empty profile.
+func createProfile(pref fyne.Preferences, name string) error {
+	if err := checkProfileName(pref, name); err != nil {
+		return err
+	}
+	pref.SetStringList(PrefProfiles, append(profileNames(pref), name))
+	return nil
+}
+
+// duplicateProfile adds a profile with the same preferences as profile src.
+func duplicateProfile(pref fyne.Preferences, src, dst string) error {
+	if err := createProfile(pref, dst); err != nil {
+		return err
+	}
+	copyProfile(newProfilePreferences(pref, src), newProfilePreferences(pref, dst))
+	return nil
+}
+
+func renameProfile(pref fyne.Preferences, oldName, newName string) error {
+	if err := checkProfileName(pref, newName); err != nil {
+		return err
+	}
+	names := profileNames(pref)
+	for i, name := range names {
+		if name == oldName {
+			names[i] = newName
+		}
+	}
+	old := newProfilePreferences(pref, oldName)
+	copyProfile(old, newProfilePreferences(pref, newName))
+	removeProfileValues(old)
+	pref.SetStringList(PrefProfiles, names)
+	if pref.String(PrefCurrentProfile) == oldName {
+		pref.SetString(PrefCurrentProfile, newName)
+	}
+	return nil
+}
+
+func deleteProfile(pref fyne.Preferences, name string) error {
+	names := profileNames(pref)
+	if len(names) <= 1 {
+		return errors.New("can not delete the last profile")
+	}
+	var left []string
+	for _, n := range names {
+		if n != name {
+			left = append(left, n)
+		}
+	}
+	removeProfileValues(newProfilePreferences(pref, name))
+	pref.SetStringList(PrefProfiles, left)
+	if pref.String(PrefCurrentProfile) == name {
+		pref.SetString(PrefCurrentProfile, left[0])
+	}
+	return nil
+}
+
+// copyProfile copies the profile values which are set in src to dst.
+func copyProfile(src, dst fyne.Preferences) {
+	for _, key := range profileBoolKeys {
+		// the value is not set if the fallback is returned.
+		if v := src.BoolWithFallback(key, true); v == src.BoolWithFallback(key, false) {
+			dst.SetBool(key, v)
+		}
+	}
+	for _, key := range profileStringKeys {
+		if v := src.StringWithFallback(key, "\x00"); v != "\x00" {
+			dst.SetString(key, v)
+		}
+	}
+	for _, key := range profileIntKeys {
+		if v := src.IntWithFallback(key, math.MinInt32); v != math.MinInt32 {
+			dst.SetInt(key, v)
+		}
+	}
+}
+
+func removeProfileValues(pref fyne.Preferences) {
+	for _, keys := range [][]string{profileBoolKeys, profileStringKeys, profileIntKeys} {
+		for _, key := range keys {
+			pref.RemoveValue(key)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+var ErrSwitchRunning = errors.New("please stop the client before switching profile")
+
+// ProfilesUI shows the profile selection and the actions of managing profiles.
+type ProfilesUI struct {
+	pref     fyne.Preferences // the app preferences (not a profile)
+	win      fyne.Window
+	uiSelect *widget.Select
+
+	CanSwitch func() bool                 // returns false if current profile can not be changed, e.g. the client is running.
+	OnSave    func(pref fyne.Preferences) // saves ui values to the profile.
+	OnLoad    func(pref fyne.Preferences) // loads values of the profile to ui.
+	OnChanged func()                      // called after profiles or the selection are changed.
+}
+
+// NewProfilesUI creates profile ui, and migrates preferences of old versions to the default profile.
+func NewProfilesUI(pref fyne.Preferences, win fyne.Window) *ProfilesUI {
+	migrateProfiles(pref)
+	p := &ProfilesUI{pref: pref, win: win}
+	p.uiSelect = widget.NewSelect(profileNames(pref), func(name string) {
+		p.Switch(name)
+	})
+	p.uiSelect.SetSelected(currentProfileName(pref))
+	return p
+}
+
+// Current returns the preferences of selected profile.
+func (p *ProfilesUI) Current() fyne.Preferences {
+	return newProfilePreferences(p.pref, p.CurrentName())
+}
+
+func (p *ProfilesUI) CurrentName() string {
+	return currentProfileName(p.pref)
+}
+
+func (p *ProfilesUI) Names() []string {
+	return profileNames(p.pref)
+}
+
+// Switch saves the values in ui to current profile, and loads the profile of name to ui.
+func (p *ProfilesUI) Switch(name string) {
+	if name == p.CurrentName() {
+		return
+	}
+	if p.CanSwitch != nil && !p.CanSwitch() {
+		dialog.ShowError(ErrSwitchRunning, p.win)
+		p.refresh()
+		return
+	}
+	if p.OnSave != nil {
+		p.OnSave(p.Current())
+	}
+	p.pref.SetString(PrefCurrentProfile, name)
+	if p.OnLoad != nil {
+		p.OnLoad(p.Current())
+	}
+	p.refresh()
+}
+
+// refresh updates profile selection and notifies the changes.
+func (p *ProfilesUI) refresh() {
+	p.uiSelect.Options = profileNames(p.pref)
+	p.uiSelect.SetSelected(p.CurrentName())
+	p.uiSelect.Refresh()
+	if p.OnChanged != nil {
+		p.OnChanged()
+	}
+}
+
+func (p *ProfilesUI) GetContainer() fyne.CanvasObject {
+	return container.NewBorder(nil, nil, widget.NewLabel("profile"),
+		widget.NewToolbar(
+			widget.NewToolbarAction(theme.ContentAddIcon(), p.showCreateDialog),
+			widget.NewToolbarAction(theme.ContentCopyIcon(), p.showDuplicateDialog),
+			widget.NewToolbarAction(theme.DocumentCreateIcon(), p.showRenameDialog),
+			widget.NewToolbarAction(theme.DeleteIcon(), p.showDeleteDialog),
+			widget.NewToolbarSeparator(),
+			widget.NewToolbarAction(theme.DownloadIcon(), p.showImportDialog),
+			widget.NewToolbarAction(theme.UploadIcon(), p.showExportDialog),
+		),
+		p.uiSelect,
+	)
+}
+
+// showNameDialog asks a profile name and calls onName with the name.
+func (p *ProfilesUI) showNameDialog(title, name string, onName func(name string) error) {
+	uiName := newEntryWithText(name)
+	dialog.ShowForm(title, "OK", "Cancel", []*widget.FormItem{{Text: "name", Widget: uiName}}, func(ok bool) {
+		if !ok {
+			return
+		}
+		if err := onName(uiName.Text); err != nil {
+			dialog.ShowError(err, p.win)
+		}
+	}, p.win)
+}
+
+func (p *ProfilesUI) showCreateDialog() {
+	if p.CanSwitch != nil && !p.CanSwitch() {
+		dialog.ShowError(ErrSwitchRunning, p.win)
+		return
+	}
+	p.showNameDialog("New profile", "", func(name string) error {
+		if err := createProfile(p.pref, name); err != nil {
+			return err
+		}
+		p.Switch(name)
+		return nil
+	})
+}
+
+func (p *ProfilesUI) showDuplicateDialog() {
+	if p.CanSwitch != nil && !p.CanSwitch() {
+		dialog.ShowError(ErrSwitchRunning, p.win)
+		return
+	}
+	current := p.CurrentName()
+	p.showNameDialog("Duplicate profile", current+" copy", func(name string) error {
+		// save ui values first, so that they are also duplicated.
+		if p.OnSave != nil {
+			p.OnSave(p.Current())
+		}
+		if err := duplicateProfile(p.pref, current, name); err != nil {
+			return err
+		}
+		p.Switch(name)
+		return nil
+	})
+}
+
+func (p *ProfilesUI) showRenameDialog() {
+	current := p.CurrentName()
+	p.showNameDialog("Rename profile", current, func(name string) error {
+		if name == current {
+			return nil
+		}
+		if p.OnSave != nil {
+			p.OnSave(p.Current())
+		}
+		if err := renameProfile(p.pref, current, name); err != nil {
+			return err
+		}
+		p.refresh()
+		return nil
+	})
+}
+
+func (p *ProfilesUI) showDeleteDialog() {
+	if p.CanSwitch != nil && !p.CanSwitch() {
+		dialog.ShowError(ErrSwitchRunning, p.win)
+		return
+	}
+	current := p.CurrentName()
+	dialog.ShowConfirm("Delete profile", fmt.Sprintf("Delete profile `%s`?", current), func(ok bool) {
+		if !ok {
+			return
+		}
+		if err := deleteProfile(p.pref, current); err != nil {
+			dialog.ShowError(err, p.win)
+			return
+		}
+		// the deleted profile is not saved.
+		if p.OnLoad != nil {
+			p.OnLoad(p.Current())
+		}
+		p.refresh()
+	}, p.win)
+}
+
+// TrayMenu returns the menu for switching profiles quickly.
+func (p *ProfilesUI) TrayMenu() *fyne.Menu {
+	var items []*fyne.MenuItem
+	current := p.CurrentName()
+	for _, name := range p.Names() {
+		name := name
+		item := fyne.NewMenuItem(name, func() {
+			p.Switch(name)
+		})
+		item.Checked = name == current
+		items = append(items, item)
+	}
+	return fyne.NewMenu("", items...)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+	"github.com/genshen/wssocks/client"
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/dns"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/proxyenv"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/route"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/sysproxy"
+)
+
+// RoutingUI holds the settings of split routing: the routing rules file of local proxy,
+// the PAC file (see PacUI) and local dns server which are served while the client is running,
+// and the desktop proxy settings which are set while the client is connected (on Linux).
+type RoutingUI struct {
+	uiRouteFile   *widget.Entry
+	pac           *PacUI
+	uiDnsAddr     *widget.Entry
+	uiDnsZones    *widget.Entry
+	uiDnsUpstream *widget.Entry
+	uiDnsFallback *widget.Entry
+	uiSysProxy    *widget.Check
+	dns           *dns.Server
+	stopWatch     context.CancelFunc // stops watching the routing rules file
+	sysProxy      *sysproxy.Proxy    // nil if the desktop proxy settings are not supported
+	listeners     client.Options     // local listeners of the started client
+}
+
+func NewRoutingUI() *RoutingUI {
+	p := &RoutingUI{
+		uiRouteFile:   &widget.Entry{PlaceHolder: "(optional) path of routing rules file"},
+		pac:           NewPacUI(),
+		uiDnsAddr:     &widget.Entry{PlaceHolder: "(optional) e.g. 127.0.0.1:5353"},
+		uiDnsZones:    &widget.Entry{PlaceHolder: "internal zones, e.g. smu.edu.cn"},
+		uiDnsUpstream: &widget.Entry{PlaceHolder: "campus resolver, e.g. 10.0.0.53"},
+		uiDnsFallback: &widget.Entry{PlaceHolder: "(optional) resolver of other names"},
+		uiSysProxy:    newCheckbox("set GNOME/KDE proxy (or PAC URL) while running", false, nil),
+	}
+	if path, err := sysproxy.DefaultBackupPath(); err == nil && runtime.GOOS == "linux" {
+		p.sysProxy = sysproxy.New(sysproxy.ExecRunner{}, path)
+	} else {
+		p.uiSysProxy.Disable()
+	}
+	return p
+}
+
+func (p *RoutingUI) GetContainer() fyne.CanvasObject {
+	items := []*widget.FormItem{{Text: "rules file", Widget: p.uiRouteFile}}
+	items = append(items, p.pac.FormItems()...)
+	return &widget.Form{Items: append(items, []*widget.FormItem{
+		{Text: "DNS address", Widget: p.uiDnsAddr},
+		{Text: "DNS zones", Widget: p.uiDnsZones},
+		{Text: "DNS upstream", Widget: p.uiDnsUpstream},
+		{Text: "DNS fallback", Widget: p.uiDnsFallback},
+		{Text: "system proxy", Widget: p.uiSysProxy},
+	}...)}
+}
+
+func (p *RoutingUI) Save(pref fyne.Preferences) {
+	pref.SetString(PrefRouteFile, strings.TrimSpace(p.uiRouteFile.Text))
+	p.pac.Save(pref)
+	pref.SetString(PrefDnsAddr, strings.TrimSpace(p.uiDnsAddr.Text))
+	pref.SetString(PrefDnsZones, strings.Join(dns.ParseZones(p.uiDnsZones.Text), ","))
+	pref.SetString(PrefDnsUpstream, strings.TrimSpace(p.uiDnsUpstream.Text))
+	pref.SetString(PrefDnsFallback, strings.TrimSpace(p.uiDnsFallback.Text))
+	pref.SetBool(PrefSystemProxy, p.uiSysProxy.Checked)
+}
+
+// Load resets the settings and loads them from pref.
+func (p *RoutingUI) Load(pref fyne.Preferences) {
+	p.uiRouteFile.SetText(pref.String(PrefRouteFile))
+	p.pac.Load(pref)
+	p.uiDnsAddr.SetText(pref.String(PrefDnsAddr))
+	p.uiDnsZones.SetText(pref.String(PrefDnsZones))
+	p.uiDnsUpstream.SetText(pref.String(PrefDnsUpstream))
+	p.uiDnsFallback.SetText(pref.String(PrefDnsFallback))
+	p.uiSysProxy.SetChecked(pref.Bool(PrefSystemProxy))
+}
+
+// Start loads the routing rules into options, and serves the PAC file for the local listeners in options,
+// and the local dns server whose internal queries are sent by dial.
+// The rules file is watched for changes until Stop is called.
+// Both Start and Stop must be called in ui thread.
+func (p *RoutingUI) Start(options *extra.Options, dial dns.Dialer) error {
+	p.Stop()
+	p.listeners = options.Options
+	if err := p.pac.Check(); err != nil {
+		return err
+	}
+	if path := strings.TrimSpace(p.uiRouteFile.Text); path != "" {
+		router, err := route.Load(path)
+		if err != nil {
+			return fmt.Errorf("load routing rules: %w", err)
+		}
+		var ctx context.Context
+		ctx, p.stopWatch = context.WithCancel(context.Background())
+		go router.Watch(ctx, route.WatchInterval)
+		options.Router = router
+	}
+	if err := p.pac.Start(options.Options); err != nil {
+		p.Stop()
+		return err
+	}
+	if addr := strings.TrimSpace(p.uiDnsAddr.Text); addr != "" {
+		server, err := dns.Listen(dns.Options{
+			Addr:     addr,
+			Zones:    dns.ParseZones(p.uiDnsZones.Text),
+			Upstream: strings.TrimSpace(p.uiDnsUpstream.Text),
+			Fallback: strings.TrimSpace(p.uiDnsFallback.Text),
+		}, dial)
+		if err != nil {
+			p.Stop()
+			return fmt.Errorf("start dns server: %w", err)
+		}
+		go server.Serve()
+		p.dns = server
+	}
+	return nil
+}
+
+// Stop stops serving the PAC file and dns, and watching the rules file.
+func (p *RoutingUI) Stop() {
+	if p.stopWatch != nil {
+		p.stopWatch()
+		p.stopWatch = nil
+	}
+	p.pac.Stop()
+	if p.dns != nil {
+		p.dns.Close()
+		p.dns = nil
+	}
+}
+
+// Hosts returns the internal hosts of the PAC rules and DNS zones, used in the generated proxy settings.
+func (p *RoutingUI) Hosts() []string {
+	return proxyenv.Hosts(p.pac.Rules(), dns.ParseZones(p.uiDnsZones.Text))
+}
+
+// SetSystemProxy sets the desktop proxy settings to the PAC URL (if the PAC file is served) or the local listeners,
+// if it is enabled. It is called when the client is connected.
+func (p *RoutingUI) SetSystemProxy() error {
+	if p.sysProxy == nil || !p.uiSysProxy.Checked {
+		return nil
+	}
+	settings := sysproxy.Settings{Socks5Addr: p.listeners.LocalSocks5Addr}
+	if p.listeners.HttpEnabled {
+		settings.HttpAddr = p.listeners.LocalHttpAddr
+	}
+	settings.PacURL = p.pac.ServingURL()
+	return p.sysProxy.Set(settings)
+}
+
+// RestoreSystemProxy restores the desktop proxy settings changed by SetSystemProxy.
+// It is called when the client is stopped, and on starting to restore the settings left by a crash.
+func (p *RoutingUI) RestoreSystemProxy() error {
+	if p.sysProxy == nil {
+		return nil
+	}
+	return p.sysProxy.Restore()
+}
+
+// CopyPacURL copies the url of PAC file to clipboard.
+func (p *RoutingUI) CopyPacURL(win fyne.Window) {
+	p.pac.CopyURL(win)
+}
@@ -4,67 +4,317 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+
+	"fyne.io/fyne/v2"
+	"golang.org/x/crypto/argon2"
+)
+
+// SecretKeyFile is the file (in app storage directory) keeping the key for encrypting secrets in preferences.
+const SecretKeyFile = "secret.key"
+
+// OldKeyFile keeps the previous key (encrypted by current key) after the key is changed.
+// The preferences are saved asynchronously by fyne, so if the app is killed before the re-encrypted secrets
+// are saved, the secrets left encrypted by the previous key are recovered on next start (see recoverSecrets).
+const OldKeyFile = SecretKeyFile + ".old"
+
+const (
+	keyKdfRandom = "random" // a random key is saved in the key file.
+	keyKdfArgon2 = "argon2" // the key is derived from user master passphrase, only the salt is saved.
 )
 
+// legacyKey is the hard-coded key used before per-installation keys.
+// It is only used for migrating the preferences saved by old versions.
+var legacyKey = []byte("TheQuickBrownFoxJumpsOverTheLazy") // 32 chars
+
 // key is 32 bytes for AES-256.
-// Note: Hardcoding the key is not truly secure against reverse engineering,
-// but it prevents plain text storage in the preference file.
-var key = []byte("TheQuickBrownFoxJumpsOverTheLazy") // 32 chars
+// It is loaded from the key file or derived from master passphrase. It is nil if the key is locked.
+var key []byte
+
+var ErrKeyLocked = errors.New("the master passphrase is required to decrypt saved secrets")
+
+// secret preferences encrypted by key.
+var secretPrefKeys = []string{PrefAuthToken, PrefVpnPassword, PrefShareUsers}
+
+// keyFileContent is the json content of the key file.
+type keyFileContent struct {
+	Kdf   string `json:"kdf"`
+	Key   string `json:"key,omitempty"`   // base64 key, if Kdf is keyKdfRandom
+	Salt  string `json:"salt,omitempty"`  // base64 salt, if Kdf is keyKdfArgon2
+	Check string `json:"check,omitempty"` // base64 hash of derived key, for checking passphrase
+}
+
+var keyFilePath string
+var keyFile keyFileContent
+
+// keyPref is the preferences keeping the secrets, for recovering them after the key is unlocked.
+var keyPref fyne.Preferences
+
+// loadEncryptionKey reads the key file in dir. If the key file does not exist (first run),
+// a random key is generated and secrets saved with the legacy key are migrated.
+// If the key is derived from master passphrase, key keeps locked until unlockKey is called.
+func loadEncryptionKey(dir string, pref fyne.Preferences) error {
+	keyFilePath = filepath.Join(dir, SecretKeyFile)
+	keyPref = pref
+	data, err := os.ReadFile(keyFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		newKey, err := randomBytes(32)
+		if err != nil {
+			return err
+		}
+		return changeKey(pref, legacyKey, newKey, keyFileContent{Kdf: keyKdfRandom, Key: base64.StdEncoding.EncodeToString(newKey)})
+	} else if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, &keyFile); err != nil {
+		return fmt.Errorf("bad key file %s: %w", keyFilePath, err)
+	}
+	switch keyFile.Kdf {
+	case keyKdfRandom:
+		if key, err = base64.StdEncoding.DecodeString(keyFile.Key); err != nil || len(key) != 32 {
+			key = nil
+			return fmt.Errorf("bad key in key file %s", keyFilePath)
+		}
+		recoverSecrets(pref)
+	case keyKdfArgon2:
+		key = nil // locked
+	default:
+		return fmt.Errorf("unknown kdf `%s` in key file %s", keyFile.Kdf, keyFilePath)
+	}
+	return nil
+}
+
+// keyLocked returns true if the master passphrase is required but not input yet.
+func keyLocked() bool {
+	return key == nil
+}
+
+// unlockKey derives key from master passphrase and checks it.
+func unlockKey(passphrase string) error {
+	if keyFile.Kdf != keyKdfArgon2 {
+		return nil
+	}
+	salt, err := base64.StdEncoding.DecodeString(keyFile.Salt)
+	if err != nil {
+		return err
+	}
+	derived := deriveKey(passphrase, salt)
+	if subtle.ConstantTimeCompare([]byte(keyCheck(derived)), []byte(keyFile.Check)) != 1 {
+		return errors.New("wrong master passphrase")
+	}
+	key = derived
+	recoverSecrets(keyPref)
+	return nil
+}
+
+// setMasterPassphrase changes the key to a key derived from passphrase,
+// or a new random key if passphrase is empty. Saved secrets are re-encrypted by the new key.
+func setMasterPassphrase(pref fyne.Preferences, passphrase string) error {
+	if keyLocked() {
+		return ErrKeyLocked
+	}
+	var newKey []byte
+	var content keyFileContent
+	if passphrase == "" {
+		k, err := randomBytes(32)
+		if err != nil {
+			return err
+		}
+		newKey = k
+		content = keyFileContent{Kdf: keyKdfRandom, Key: base64.StdEncoding.EncodeToString(newKey)}
+	} else {
+		salt, err := randomBytes(16)
+		if err != nil {
+			return err
+		}
+		newKey = deriveKey(passphrase, salt)
+		content = keyFileContent{Kdf: keyKdfArgon2, Salt: base64.StdEncoding.EncodeToString(salt), Check: keyCheck(newKey)}
+	}
+	return changeKey(pref, key, newKey, content)
+}
+
+// changeKey re-encrypts the secrets from oldKey to newKey, and replaces the key file with content.
+// The old key file is written before the key file is replaced, so the secrets can always be decrypted
+// by the key file or the old key file, whenever the app is killed.
+func changeKey(pref fyne.Preferences, oldKey, newKey []byte, content keyFileContent) error {
+	if err := writeOldKeyFile(oldKey, newKey); err != nil {
+		return err
+	}
+	if err := writeKeyFile(content); err != nil {
+		return err
+	}
+	migrateSecrets(pref, oldKey, newKey)
+	key = newKey
+	return nil
+}
+
+// hasMasterPassphrase returns true if the key is derived from master passphrase.
+func hasMasterPassphrase() bool {
+	return keyFile.Kdf == keyKdfArgon2
+}
+
+func writeKeyFile(content keyFileContent) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFilePath), 0700); err != nil {
+		return err
+	}
+	// write to a temp file and rename it, so that the key would not be lost if writing fails.
+	tmp := keyFilePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, keyFilePath); err != nil {
+		return err
+	}
+	keyFile = content
+	return nil
+}
+
+// writeOldKeyFile saves oldKey encrypted by newKey to the old key file.
+func writeOldKeyFile(oldKey, newKey []byte) error {
+	ciphertext := encryptWithKey(newKey, base64.StdEncoding.EncodeToString(oldKey))
+	if ciphertext == "" {
+		return errors.New("failed to encrypt the previous key")
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFilePath), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(filepath.Dir(keyFilePath), OldKeyFile), []byte(ciphertext), 0600)
+}
+
+// recoverSecrets re-encrypts the secrets left encrypted by the key in old key file (see changeKey).
+// The old key file is removed once no secret is encrypted by it, which is checked on every start,
+// because the re-encrypted secrets are not known to be saved until then.
+func recoverSecrets(pref fyne.Preferences) {
+	path := filepath.Join(filepath.Dir(keyFilePath), OldKeyFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	// it can not be decrypted if the key file was not replaced after writing it.
+	encoded, err := decryptWithKey(key, string(data))
+	if err != nil {
+		os.Remove(path)
+		return
+	}
+	oldKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		os.Remove(path)
+		return
+	}
+	if migrateSecrets(pref, oldKey, key) == 0 {
+		os.Remove(path)
+	}
+}
+
+// migrateSecrets re-encrypts secrets in all profiles from oldKey to newKey, and returns the number of them.
+// Values which can not be decrypted by oldKey are kept as they are.
+func migrateSecrets(pref fyne.Preferences, oldKey, newKey []byte) int {
+	n := 0
+	for _, profilePref := range allProfilePreferences(pref) {
+		for _, prefKey := range secretPrefKeys {
+			if ciphertext := profilePref.String(prefKey); ciphertext != "" {
+				if plaintext, err := decryptWithKey(oldKey, ciphertext); err == nil {
+					profilePref.SetString(prefKey, encryptWithKey(newKey, plaintext))
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+func deriveKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, 32)
+}
+
+func keyCheck(k []byte) string {
+	check := argon2.IDKey(k, []byte("wssocks-ustb key check"), 1, 1024, 1, 16)
+	return base64.StdEncoding.EncodeToString(check)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
 
 func encrypt(plaintext string) string {
+	return encryptWithKey(key, plaintext)
+}
+
+func decrypt(ciphertext string) (string, error) {
+	if keyLocked() {
+		return "", ErrKeyLocked
+	}
+	return decryptWithKey(key, ciphertext)
+}
+
+func encryptWithKey(k []byte, plaintext string) string {
 	if plaintext == "" {
 		return ""
 	}
-	c, err := aes.NewCipher(key)
+	c, err := aes.NewCipher(k)
 	if err != nil {
-		return plaintext // Fallback to plaintext on error (should not happen)
+		return "" // never save plaintext, e.g. the key is locked.
 	}
 
 	gcm, err := cipher.NewGCM(c)
 	if err != nil {
-		return plaintext
+		return ""
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return plaintext
+		return ""
 	}
 
 	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
 	return base64.StdEncoding.EncodeToString(ciphertext)
 }
 
-func decrypt(ciphertext string) string {
+func decryptWithKey(k []byte, ciphertext string) (string, error) {
 	if ciphertext == "" {
-		return ""
+		return "", nil
 	}
 	data, err := base64.StdEncoding.DecodeString(ciphertext)
 	if err != nil {
-		return "" // Return empty on decoding error
+		return "", err
 	}
 
-	c, err := aes.NewCipher(key)
+	c, err := aes.NewCipher(k)
 	if err != nil {
-		return ""
+		return "", err
 	}
 
 	gcm, err := cipher.NewGCM(c)
 	if err != nil {
-		return ""
+		return "", err
 	}
 
 	nonceSize := gcm.NonceSize()
 	if len(data) < nonceSize {
-		return ""
+		return "", errors.New("ciphertext too short")
 	}
 
 	nonce, ciphertextBytes := data[:nonceSize], data[nonceSize:]
 	plaintext, err := gcm.Open(nil, nonce, ciphertextBytes, nil)
 	if err != nil {
-		return "" // Return empty on decryption error
+		return "", err
 	}
 
-	return string(plaintext)
+	return string(plaintext), nil
 }
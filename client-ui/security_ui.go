@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// showUnlockDialog asks the master passphrase to unlock saved secrets,
+// and calls onUnlocked after the key is unlocked.
+// If the user cancels it, saved secrets are kept in preference and not loaded.
+func showUnlockDialog(win fyne.Window, onUnlocked func()) {
+	uiPassphrase := &widget.Entry{PlaceHolder: "master passphrase", Password: true}
+	var d dialog.Dialog
+	d = dialog.NewForm("Unlock saved secrets", "Unlock", "Skip",
+		[]*widget.FormItem{{Text: "passphrase", Widget: uiPassphrase}},
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := unlockKey(uiPassphrase.Text); err != nil {
+				dialog.ShowError(err, win)
+				// ask again
+				showUnlockDialog(win, onUnlocked)
+				return
+			}
+			onUnlocked()
+		}, win)
+	uiPassphrase.OnSubmitted = func(_ string) {
+		d.Hide()
+		if err := unlockKey(uiPassphrase.Text); err != nil {
+			dialog.ShowError(err, win)
+			showUnlockDialog(win, onUnlocked)
+			return
+		}
+		onUnlocked()
+	}
+	d.Show()
+	win.Canvas().Focus(uiPassphrase)
+}
+
+// showMasterPassphraseDialog sets, changes or removes (empty passphrase) the master passphrase.
+func showMasterPassphraseDialog(win fyne.Window, pref fyne.Preferences) {
+	if keyLocked() {
+		dialog.ShowError(ErrKeyLocked, win)
+		return
+	}
+	uiPassphrase := &widget.Entry{PlaceHolder: "empty to remove passphrase", Password: true}
+	uiConfirm := &widget.Entry{PlaceHolder: "input passphrase again", Password: true}
+	hint := "Saved secrets are encrypted by a random key of this installation."
+	if hasMasterPassphrase() {
+		hint = "Saved secrets are encrypted by your master passphrase."
+	}
+	dialog.ShowForm("Master passphrase", "Save", "Cancel",
+		[]*widget.FormItem{
+			{Text: "", Widget: widget.NewLabel(hint)},
+			{Text: "passphrase", Widget: uiPassphrase},
+			{Text: "confirm", Widget: uiConfirm},
+		},
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			if uiPassphrase.Text != uiConfirm.Text {
+				dialog.ShowError(errors.New("the two passphrases do not match"), win)
+				return
+			}
+			if err := setMasterPassphrase(pref, uiPassphrase.Text); err != nil {
+				dialog.ShowError(err, win)
+				return
+			}
+			if uiPassphrase.Text == "" {
+				dialog.ShowInformation("Master passphrase", "Master passphrase is removed.", win)
+			} else {
+				dialog.ShowInformation("Master passphrase", "Master passphrase is set, "+
+					"it will be asked when the client starts.", win)
+			}
+		}, win)
+}
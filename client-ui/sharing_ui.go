@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+)
+
+// SharingUI is the settings of sharing the tunnel with other machines in LAN.
+// The sharing mode is enabled if the allowed networks are not empty.
+type SharingUI struct {
+	uiAllow    *widget.Entry
+	uiUsers    *widget.Entry // one username:password per line, saved as a secret
+	uiMaxConns *widget.Entry
+}
+
+func NewSharingUI() *SharingUI {
+	return &SharingUI{
+		uiAllow:    &widget.Entry{MultiLine: true, PlaceHolder: "one CIDR or IP per line, e.g. 192.168.1.0/24"},
+		uiUsers:    &widget.Entry{MultiLine: true, PlaceHolder: "one username:password per line, empty to disable authentication"},
+		uiMaxConns: &widget.Entry{PlaceHolder: "max active connections of each client, 0 for unlimited"},
+	}
+}
+
+func (s *SharingUI) GetContainer() fyne.CanvasObject {
+	hint := widget.NewLabel("Set the socks5 (and http) address to 0.0.0.0 to listen in LAN.\n" +
+		"Connections from this machine are always allowed without authentication.")
+	hint.Wrapping = fyne.TextWrapWord
+	return container.NewVBox(&widget.Form{Items: []*widget.FormItem{
+		{Text: "allowed clients", Widget: s.uiAllow},
+		{Text: "users", Widget: s.uiUsers},
+		{Text: "max connections", Widget: s.uiMaxConns},
+	}}, hint)
+}
+
+// Sharing returns the sharing options, or nil if sharing is disabled.
+func (s *SharingUI) Sharing() (*extra.Sharing, error) {
+	if strings.TrimSpace(s.uiAllow.Text) == "" {
+		return nil, nil
+	}
+	maxConns := 0
+	if text := strings.TrimSpace(s.uiMaxConns.Text); text != "" {
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, fmt.Errorf("bad max connections `%s` of sharing", text)
+		}
+		maxConns = n
+	}
+	return extra.ParseSharing(extra.SplitAllow(s.uiAllow.Text), splitLines(s.uiUsers.Text), maxConns)
+}
+
+func (s *SharingUI) Save(pref fyne.Preferences) {
+	pref.SetString(PrefShareAllow, strings.TrimSpace(s.uiAllow.Text))
+	// keep the saved users if they are locked by master passphrase
+	if !keyLocked() {
+		pref.SetString(PrefShareUsers, encrypt(strings.TrimSpace(s.uiUsers.Text)))
+	}
+	maxConns, _ := strconv.Atoi(strings.TrimSpace(s.uiMaxConns.Text))
+	pref.SetInt(PrefShareMaxConns, maxConns)
+}
+
+// Load loads the settings except the users, which are loaded by LoadSecrets.
+func (s *SharingUI) Load(pref fyne.Preferences) {
+	s.uiAllow.SetText(pref.String(PrefShareAllow))
+	s.uiUsers.SetText("")
+	s.uiMaxConns.SetText("")
+	if n := pref.Int(PrefShareMaxConns); n != 0 {
+		s.uiMaxConns.SetText(strconv.Itoa(n))
+	}
+}
+
+// LoadSecrets decrypts the saved users.
+func (s *SharingUI) LoadSecrets(pref fyne.Preferences) error {
+	s.uiUsers.SetText("")
+	return loadSecretPreference(pref, PrefShareUsers, "sharing users", s.uiUsers)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+)
+
+// statsTopHosts is the number of hosts shown in stats panel.
+const statsTopHosts = 8
+
+// StatsUI shows the traffic and connection statistics of the running client.
+type StatsUI struct {
+	handles      *extra.TaskHandles
+	uiTraffic    *widget.Label
+	uiConns      *widget.Label
+	uiReconnects *widget.Label
+	uiDuration   *widget.Label
+	uiHosts      *widget.Label
+	stop         chan struct{}
+}
+
+func NewStatsUI(handles *extra.TaskHandles) *StatsUI {
+	return &StatsUI{
+		handles:      handles,
+		uiTraffic:    widget.NewLabel("-"),
+		uiConns:      widget.NewLabel("-"),
+		uiReconnects: widget.NewLabel("-"),
+		uiDuration:   widget.NewLabel("-"),
+		uiHosts:      widget.NewLabel("-"),
+	}
+}
+
+func (s *StatsUI) GetContainer() fyne.CanvasObject {
+	return &widget.Form{Items: []*widget.FormItem{
+		{Text: "traffic", Widget: s.uiTraffic},
+		{Text: "connections", Widget: s.uiConns},
+		{Text: "reconnects", Widget: s.uiReconnects},
+		{Text: "running time", Widget: s.uiDuration},
+		{Text: "hosts", Widget: s.uiHosts},
+	}}
+}
+
+// Start refreshes the statistics every second until Stop is called.
+// Both Start and Stop must be called in ui thread.
+func (s *StatsUI) Start() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				fyne.Do(s.refresh)
+			}
+		}
+	}(s.stop)
+}
+
+// Stop stops refreshing, and keeps the last statistics shown.
+func (s *StatsUI) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.stop = nil
+	s.refresh()
+}
+
+func (s *StatsUI) refresh() {
+	stats := s.handles.Stats()
+	s.uiTraffic.SetText(fmt.Sprintf("↑ %s  ↓ %s", extra.FormatBytes(stats.BytesUp), extra.FormatBytes(stats.BytesDown)))
+	s.uiConns.SetText(fmt.Sprintf("%d active, %d total", stats.ActiveConnections, stats.TotalConnections))
+	s.uiReconnects.SetText(fmt.Sprintf("%d", stats.Reconnects))
+	s.uiDuration.SetText(time.Since(stats.Since).Truncate(time.Second).String())
+
+	// hosts with most connections
+	hosts := make([]string, 0, len(stats.Destinations))
+	for host := range stats.Destinations {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		a, b := stats.Destinations[hosts[i]], stats.Destinations[hosts[j]]
+		if a.TotalConnections != b.TotalConnections {
+			return a.TotalConnections > b.TotalConnections
+		}
+		return hosts[i] < hosts[j]
+	})
+	if len(hosts) > statsTopHosts {
+		hosts = hosts[:statsTopHosts]
+	}
+	var lines []string
+	for _, host := range hosts {
+		d := stats.Destinations[host]
+		lines = append(lines, fmt.Sprintf("%s (%d/%d, %s)", host, d.ActiveConnections, d.TotalConnections,
+			extra.FormatBytes(d.BytesUp+d.BytesDown)))
+	}
+	if len(lines) == 0 {
+		s.uiHosts.SetText("-")
+	} else {
+		s.uiHosts.SetText(strings.Join(lines, "\n"))
+	}
+}
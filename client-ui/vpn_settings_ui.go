@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
@@ -10,50 +12,83 @@ import (
 
 type VpnSettingsUI struct {
 	uiVpnEnable      *widget.Check
+	uiVpnAuto        *widget.Check
+	uiDirectProbe    *widget.Entry
 	uiVpnForceLogout *widget.Check
 	uiVpnHostEncrypt *widget.Check
 	uiVpnHostInput   *widget.Entry
 	uiVpnUsername    *widget.Entry
 	uiVpnPassword    *widget.Entry
 	uiSavePassword   *widget.Check
+	uiCredHelper     *widget.Entry
 }
 
 func (v *VpnSettingsUI) Init(pref fyne.Preferences) {
 	v.uiVpnEnable = newCheckbox("enable smu vpn", true, nil)
+	v.uiVpnAuto = newCheckbox("skip vpn if directly reachable (on campus)", false, nil)
+	v.uiDirectProbe = &widget.Entry{PlaceHolder: "(optional) host:port, default is the server"}
 	v.uiVpnForceLogout = newCheckbox("", true, nil)
 	v.uiVpnHostEncrypt = newCheckbox("", true, nil)
 	v.uiVpnHostInput = &widget.Entry{PlaceHolder: "vpn hostname", Text: "n.ustb.edu.cn"}
 	v.uiVpnUsername = &widget.Entry{PlaceHolder: "vpn username", Text: ""}
 	v.uiVpnPassword = &widget.Entry{PlaceHolder: "vpn password", Text: "", Password: true}
 	v.uiSavePassword = newCheckbox("save password", false, nil)
+	v.uiCredHelper = &widget.Entry{PlaceHolder: "(optional) e.g. git credential-store", Text: ""}
+	v.Load(pref)
+}
+
+// Load resets the settings to default values, and then loads values from preference (e.g. a profile).
+func (v *VpnSettingsUI) Load(pref fyne.Preferences) {
+	v.uiVpnEnable.SetChecked(true)
+	v.uiVpnAuto.SetChecked(false)
+	v.uiDirectProbe.SetText("")
+	v.uiVpnForceLogout.SetChecked(true)
+	v.uiVpnHostEncrypt.SetChecked(true)
+	v.uiVpnHostInput.SetText("n.ustb.edu.cn")
+	v.uiVpnUsername.SetText("")
+	v.uiVpnPassword.SetText("")
+	v.uiSavePassword.SetChecked(false)
+	v.uiCredHelper.SetText("")
 
 	// load Preference
-	loadVPNMainPreference(pref, v.uiVpnEnable)
+	loadVPNMainPreference(pref, v.uiVpnEnable, v.uiVpnAuto, v.uiDirectProbe)
 	// pass nil as auth method radio group, as we removed it.
 	loadVpnPreference(pref, v.uiVpnForceLogout, v.uiVpnHostEncrypt, v.uiSavePassword, v.uiVpnHostInput, v.uiVpnUsername, v.uiVpnPassword)
+	loadVpnCredHelperPreference(pref, v.uiCredHelper)
+}
+
+// LoadSecrets loads the saved password, it must be called after the key is unlocked.
+func (v *VpnSettingsUI) LoadSecrets(pref fyne.Preferences) error {
+	return loadVpnPasswordPreference(pref, v.uiVpnPassword)
 }
 
 func (v *VpnSettingsUI) Save(pref fyne.Preferences) {
-	saveVPNMainPreference(pref, v.uiVpnEnable)
+	saveVPNMainPreference(pref, v.uiVpnEnable, v.uiVpnAuto, v.uiDirectProbe)
 	saveVPNPreference(pref, v.uiVpnForceLogout, v.uiVpnHostEncrypt, v.uiSavePassword, v.uiVpnHostInput, v.uiVpnUsername, v.uiVpnPassword)
+	saveVpnCredHelperPreference(pref, v.uiCredHelper)
 }
 
 func (v *VpnSettingsUI) GetContainer() *fyne.Container {
 	return container.NewVBox(
 		&widget.Form{Items: []*widget.FormItem{
 			{Text: "enable", Widget: v.uiVpnEnable},
+			{Text: "auto", Widget: v.uiVpnAuto},
+			{Text: "direct probe", Widget: v.uiDirectProbe},
 			{Text: "force logout", Widget: v.uiVpnForceLogout},
 			{Text: "host encrypt", Widget: v.uiVpnHostEncrypt},
 			{Text: "vpn host", Widget: v.uiVpnHostInput},
 			{Text: "username", Widget: v.uiVpnUsername},
 			{Text: "password", Widget: v.uiVpnPassword},
 			{Text: "", Widget: v.uiSavePassword},
+			{Text: "credential helper", Widget: v.uiCredHelper},
 		}},
 	)
 }
 
 func (v *VpnSettingsUI) LoadSettingsValues(values *vpn.UstbVpn) {
 	values.Enable = v.uiVpnEnable.Checked
+	values.Auto = v.uiVpnAuto.Checked
+	values.DirectProbe = strings.TrimSpace(v.uiDirectProbe.Text)
 	values.ForceLogout = v.uiVpnForceLogout.Checked
 	values.HostEncrypt = v.uiVpnHostEncrypt.Checked
 	values.TargetVpn = v.uiVpnHostInput.Text
@@ -62,4 +97,5 @@ func (v *VpnSettingsUI) LoadSettingsValues(values *vpn.UstbVpn) {
 		Username: v.uiVpnUsername.Text,
 		Password: v.uiVpnPassword.Text,
 	}
+	values.CredentialHelper = v.uiCredHelper.Text
 }
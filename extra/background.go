@@ -5,91 +5,295 @@ package extra
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/rep1ace/wssocks-plugin-smu/plugins/ver"
-	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
 	"github.com/genshen/wssocks/client"
+	"github.com/genshen/wssocks/wss"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/route"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/broker"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 )
 
 type Options struct {
 	client.Options
 	vpn.UstbVpn
-	RemoteAddr string
-	AuthToken  string
+	RemoteAddr    string
+	AuthToken     string
+	Profile       string // name of the profile used, only for showing status
+	AutoReconnect AutoReconnectOptions
+	Router        *route.Router // routing rules of local connections, nil to route all connections through the tunnel
+	Forwards      []Forward     // local port forwardings through the tunnel
+	Sharing       *Sharing      // access control of local listeners for sharing in LAN, nil to disable sharing mode
+	TermView      bool          // show the table of connections in terminal (stdout must be a tty), for cli
 }
 
+var ErrNotConnected = errors.New("the client is not connected")
+
+// kinds of restarting the connection, see TaskHandles.Reconnect and TaskHandles.Relogin.
+const (
+	restartNone int32 = iota
+	restartReconnect
+	restartRelogin
+	restartReload // restart with new options, the old vpn session is logged out
+)
+
 type TaskHandles struct {
-	client.Handles
-	once *sync.Once
+	client.Handles   // only used for connecting to server, the local listeners are started by startClient.
+	once             *sync.Once
+	eg               *errgroup.Group
+	wsc              *wss.WebSocketClient
+	hb               *wss.HeartBeat
+	httpServer       *http.Server
+	listener         net.Listener
+	forwardListeners []net.Listener
+	events           eventSubscribers
+	stats            statsCounter
+	stopRequested    atomic.Bool
+	restart          atomic.Int32    // restartNone, restartReconnect or restartRelogin
+	phase            atomic.Value    // current Phase
+	options          Options         // options of the last start
+	vpn              *vpn.UstbVpn    // vpn plugin of the last successful connection, for reusing its session and logout
+	lock             sync.Mutex      // lock for once, wsc and options, which are replaced when the client is (re)started
+	connCtx          context.Context // it is canceled when current connection is closed
+	connCancel       context.CancelFunc
+	stopCtx          context.Context // it is canceled by NotifyCloseWrapper
+	stopCancel       context.CancelFunc
 }
 
 func (h *TaskHandles) NotifyCloseWrapper() {
-	h.NotifyClose(h.once, false)
+	h.stopRequested.Store(true)
+	if h.stopCancel != nil {
+		h.stopCancel()
+	}
+	h.closeConn()
 }
 
-var vpnPlugin *vpn.UstbVpn = nil
+// closeConn closes current connection and the local listeners.
+func (h *TaskHandles) closeConn() {
+	h.lock.Lock()
+	once := h.once
+	h.lock.Unlock()
+	if once == nil {
+		return // not started
+	}
+	once.Do(h.closeAll)
+}
 
-func loadPlugins(v vpn.UstbVpn) error {
-	if vpnPlugin == nil {
-		vpnPlugin = &v
-		// vpn.UstbVpn has implementations of both option plugin and request plugin
-		if err := client.AddPluginOption(vpnPlugin); err != nil {
+// Wait waits until the client is stopped, and emits PhaseDisconnected (if it is not stopped by user)
+// and PhaseStopped.
+// If Reconnect or Relogin is called, or the connection is lost with AutoReconnect enabled,
+// the connection is restarted in Wait, and Wait keeps waiting.
+func (h *TaskHandles) Wait() error {
+	if h.eg == nil {
+		return nil // not started
+	}
+	for {
+		err := h.eg.Wait()
+		restart := h.restart.Swap(restartNone)
+		if h.stopRequested.Load() {
+			h.emit(PhaseStopped, err)
 			return err
 		}
-		if err := client.AddPluginRequest(vpnPlugin); err != nil {
+		options := h.Options()
+		if restart == restartNone && !options.AutoReconnect.Enable {
+			reason := err
+			if reason == nil {
+				reason = ErrConnectionClosed
+			}
+			h.emit(PhaseDisconnected, reason)
+			h.emit(PhaseStopped, err)
 			return err
 		}
-		if err := client.AddPluginVersion(&ver.PluginVersionNeg{}); err != nil {
+
+		if restart == restartNone {
+			log.WithError(err).Warning("connection lost, reconnecting.")
+		}
+		h.emit(PhaseReconnecting, err)
+		switch restart {
+		case restartRelogin:
+			options.ForceLogout = true
+			// the session broker returns a new session instead of the current one.
+			if h.vpn != nil {
+				options.StaleSession = h.vpn.Session
+			}
+		case restartReload:
+			h.logout()
+		}
+		// the vpn session is reused, unless logging in again is requested.
+		reuseSession := restart == restartNone || restart == restartReconnect
+		if err := h.reconnect(options, reuseSession); err != nil {
+			if h.stopRequested.Load() {
+				h.emit(PhaseStopped, nil)
+				return nil
+			}
+			h.emit(PhaseDisconnected, err)
+			h.emit(PhaseStopped, err)
 			return err
 		}
-	} else {
-		// apply changed vpn config to plugin
-		*vpnPlugin = v
+		if h.stopRequested.Load() {
+			h.closeConn() // stopped while reconnecting
+		}
+		h.emit(PhaseConnected, nil)
+	}
+}
+
+// Reconnect closes current connection, and connects to server again (in Wait).
+func (h *TaskHandles) Reconnect() error {
+	return h.requestRestart(restartReconnect)
+}
+
+// Relogin is similar to Reconnect, but the vpn account is logged in again
+// and logged out forcibly on other devices.
+func (h *TaskHandles) Relogin() error {
+	if !h.Options().Enable {
+		return errors.New("vpn is not enabled")
+	}
+	return h.requestRestart(restartRelogin)
+}
+
+// Restart is similar to Reconnect, but the client is restarted with new options (e.g. reloaded configuration),
+// and the vpn session of current connection is logged out.
+func (h *TaskHandles) Restart(options Options) error {
+	if h.Phase() != PhaseConnected {
+		return ErrNotConnected
+	}
+	h.setOptions(options)
+	return h.requestRestart(restartReload)
+}
+
+// Logout logs out the vpn session of the last connection. It should be called after Wait returns.
+func (h *TaskHandles) Logout() error {
+	if h.vpn == nil {
+		return nil
+	}
+	return h.vpn.Logout()
+}
+
+func (h *TaskHandles) logout() {
+	if err := h.Logout(); err != nil {
+		log.WithError(err).Warning("failed to logout vpn.")
+	}
+}
+
+func (h *TaskHandles) requestRestart(kind int32) error {
+	if h.Phase() != PhaseConnected {
+		return ErrNotConnected
 	}
+	// the write of restart is observed by Wait, after the connection is closed.
+	h.restart.Store(kind)
+	h.closeConn()
 	return nil
 }
 
+// Phase returns current phase of the client.
+func (h *TaskHandles) Phase() Phase {
+	if phase, ok := h.phase.Load().(Phase); ok {
+		return phase
+	}
+	return PhaseStopped
+}
+
+// Options returns the options of the last start.
+func (h *TaskHandles) Options() Options {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.options
+}
+
+func (h *TaskHandles) setOptions(options Options) {
+	h.lock.Lock()
+	h.options = options
+	h.lock.Unlock()
+}
+
 func (h *TaskHandles) StartWssocks(options Options) error {
-	if err := loadPlugins(options.UstbVpn); err != nil {
+	h.stopRequested.Store(false)
+	h.stopCtx, h.stopCancel = context.WithCancel(context.Background())
+	h.restart.Store(restartNone)
+	h.setOptions(options)
+	h.stats.reset()
+	h.emit(PhaseConnecting, nil)
+	if err := h.connect(options); err != nil {
+		h.emit(PhaseStopped, err)
 		return err
 	}
+	h.emit(PhaseConnected, nil)
+	return nil
+}
+
+// startWssocks connects to the server and starts the local listeners.
+// It returns the vpn plugin of the connection (nil if it fails before the vpn is used), whose Session is
+// the session logged in or reused, even if the connection fails after that.
+func (h *TaskHandles) startWssocks(options Options) (*vpn.UstbVpn, error) {
+	if err := registerPlugins(); err != nil {
+		return nil, err
+	}
 
 	// check remote url
 	if options.RemoteAddr == "" {
-		return errors.New("empty remote address")
+		return nil, errors.New("empty remote address")
 	}
 	u, err := url.Parse(options.RemoteAddr)
 	if err != nil {
-		return err
+		return nil, err
 	} else {
 		options.RemoteUrl = u
 	}
 
-	options.RemoteHeaders = make(http.Header)
+	if options.RemoteHeaders == nil {
+		options.RemoteHeaders = make(http.Header)
+	}
 	if options.AuthToken != "" {
 		options.RemoteHeaders.Set("Key", options.AuthToken)
 	}
 
+	// the vpn plugin of this connection, it is called by the plugin dispatcher.
+	vpnPlugin := options.UstbVpn
+	if vpnPlugin.LoginObserver == nil {
+		vpnPlugin.LoginObserver = &h.stats
+	}
+	if vpnPlugin.Enable {
+		// share the vpn session with other local clients if the session broker is running.
+		if vpnPlugin.Broker == nil {
+			vpnPlugin.Broker = broker.Discover()
+		}
+		h.emit(PhaseLoggingIn, nil)
+	}
+	if captchaHandler := vpnPlugin.CaptchaHandler; captchaHandler != nil {
+		vpnPlugin.CaptchaHandler = func(imgData []byte) (string, error) {
+			h.emit(PhaseCaptcha, nil)
+			return captchaHandler(imgData)
+		}
+	}
+	dispatcher.add(options.RemoteUrl, &vpnPlugin)
+	defer dispatcher.remove(options.RemoteUrl)
+
 	h.Handles = *client.NewClientHandles()
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute) // fixme
+	ctx, cancel := context.WithTimeout(h.stopCtx, time.Minute) // fixme
 	defer cancel()
 
-	_, err = h.CreateServerConn(&options.Options, ctx)
+	wsc, err := h.CreateServerConn(&options.Options, ctx)
 	if err != nil {
-		return err
+		return &vpnPlugin, err
 	}
 	// server connect successfully
 
+	h.emit(PhaseNegotiating, nil)
 	if err := h.NegotiateVersion(ctx, options.RemoteAddr); err != nil {
-		return err
+		wsc.Close()
+		return &vpnPlugin, err
 	}
 
-	var once sync.Once
-	h.once = &once
-	h.StartClient(&options.Options, &once)
-	return nil
+	h.startClient(&options, wsc)
+	if ar := options.AutoReconnect; ar.Enable {
+		h.startProbe(wsc, ar.withDefaults(options.RemoteAddr))
+	}
+	return &vpnPlugin, nil
 }
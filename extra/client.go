@@ -0,0 +1,303 @@
+package extra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/genshen/wssocks/wss"
+	"github.com/genshen/wssocks/wss/term_view"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/route"
+	"github.com/segmentio/ksuid"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// startClient starts the local listeners (including port forwardings) and the websocket message loop.
+// It is similar to client.Handles.StartClient, but the local connections are counted for statistics,
+// and they are routed by options.Router.
+func (h *TaskHandles) startClient(options *Options, wsc *wss.WebSocketClient) {
+	c, router := &options.Options, options.Router
+	h.eg = &errgroup.Group{}
+	h.listener, h.forwardListeners = nil, nil
+	h.connCtx, h.connCancel = context.WithCancel(context.Background())
+	h.lock.Lock()
+	h.wsc = wsc
+	h.once = &sync.Once{}
+	h.lock.Unlock()
+
+	record := wss.NewConnRecord()
+	var plog *term_view.ProgressLog
+	if options.TermView {
+		// show the table of connections under the logs, like the client of wssocks in a tty.
+		plog = term_view.NewPLog(record)
+		log.SetOutput(plog)
+	}
+	record.OnChange = func(status wss.ConnStatus) {
+		h.stats.onConnChange(status)
+		if plog != nil {
+			plog.SetLogBuffer(record)
+			plog.Writer.Flush(nil)
+			return
+		}
+		if status.IsNew {
+			log.WithField("address", status.Address).Traceln("new proxy connection")
+		} else {
+			log.WithField("address", status.Address).Traceln("close proxy connection")
+		}
+	}
+
+	// start websocket message listen.
+	h.eg.Go(func() error {
+		defer h.once.Do(h.closeAll)
+		if err := wsc.ListenIncomeMsg(1 << 29); err != nil && !h.closedByUser() {
+			return fmt.Errorf("error websocket read %w", err)
+		}
+		return nil
+	})
+	// send heart beats.
+	heartbeat, hbCtx := wss.NewHeartBeat(wsc)
+	h.hb = heartbeat
+	h.eg.Go(func() error {
+		defer h.once.Do(h.closeAll)
+		if err := heartbeat.Start(hbCtx, time.Minute); err != nil && !h.closedByUser() {
+			return fmt.Errorf("heartbeat ending %w", err)
+		}
+		return nil
+	})
+
+	// http listening
+	if c.HttpEnabled {
+		log.WithField("http listen address", c.LocalHttpAddr).
+			Info("listening on local address for incoming proxy requests.")
+		handle := wss.NewHttpProxy(wsc, record)
+		var handler http.Handler = &routedHttpProxy{tunnel: &handle, router: router}
+		if options.Sharing != nil {
+			handler = &sharingHttpHandler{sharing: options.Sharing, next: handler}
+		}
+		h.httpServer = &http.Server{Addr: c.LocalHttpAddr, Handler: handler, ConnContext: withCountingConn}
+		h.eg.Go(func() error {
+			defer h.once.Do(h.closeAll)
+			l, err := listen(c.LocalHttpAddr, options.Sharing, &h.stats)
+			if err != nil {
+				return err
+			}
+			if err := h.httpServer.Serve(&countingListener{Listener: l, stats: &h.stats}); err != nil &&
+				!errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		})
+	}
+
+	// start listen for socks5 and https connection.
+	l, err := listen(c.LocalSocks5Addr, options.Sharing, &h.stats)
+	if err != nil {
+		h.eg.Go(func() error {
+			h.once.Do(h.closeAll)
+			return fmt.Errorf("start client error %w", err)
+		})
+		return
+	}
+	h.listener = l
+	log.WithField("socks5 listen address", c.LocalSocks5Addr).
+		WithField("https enabled", c.HttpEnabled).
+		Info("listening on local address for incoming proxy requests.")
+	h.eg.Go(func() error {
+		defer h.once.Do(h.closeAll)
+		return h.serveSocks5(l, wsc, record, router, options.Sharing, c.HttpEnabled)
+	})
+	h.startForwards(options.Forwards, options.Sharing, wsc, record)
+}
+
+// closedByUser returns true if the connection is closed by NotifyCloseWrapper or restarting by request,
+// so that the errors caused by closing are ignored.
+func (h *TaskHandles) closedByUser() bool {
+	return h.stopRequested.Load() || h.restart.Load() != restartNone
+}
+
+// closeAll stops all connections and tasks. It is called only once (by h.once).
+func (h *TaskHandles) closeAll() {
+	h.lock.Lock()
+	wsc := h.wsc
+	h.lock.Unlock()
+	if h.connCancel != nil {
+		h.connCancel()
+	}
+	if h.listener != nil {
+		h.listener.Close()
+	}
+	for _, l := range h.forwardListeners {
+		l.Close()
+	}
+	if h.httpServer != nil {
+		h.httpServer.Shutdown(context.TODO())
+	}
+	if h.hb != nil {
+		h.hb.Close()
+	}
+	if wsc != nil {
+		wsc.Close()
+	}
+}
+
+// listen listens on addr, and checks the clients by sharing if it is not nil.
+func listen(addr string, sharing *Sharing, stats *statsCounter) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if sharing == nil {
+		if ip := remoteIP(l.Addr()); ip != nil && !ip.IsLoopback() {
+			log.WithField("address", addr).Warning("the local listener is open to other machines, " +
+				"consider enabling sharing mode for access control.")
+		}
+		return l, nil
+	}
+	return newSharingListener(l, sharing, stats), nil
+}
+
+// serveSocks5 accepts socks5 and https proxy connections and forwards them to wssocks server.
+// The proxy requests from other machines are authenticated if sharing has users.
+func (h *TaskHandles) serveSocks5(l net.Listener, wsc *wss.WebSocketClient, record *wss.ConnRecord,
+	router *route.Router, sharing *Sharing, enableHttp bool) error {
+	parser := wss.NewClient()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if h.closedByUser() {
+				return nil
+			}
+			return fmt.Errorf("tcp accept error: %w", err)
+		}
+		conn := newCountingConn(c, &h.stats)
+		go func() {
+			defer conn.Close()
+			// In reply, we can get proxy type, target address and first send data.
+			var firstSendData []byte
+			var proxyType int
+			var addr string
+			var err error
+			if sharing.authRequired(remoteIP(c.RemoteAddr())) {
+				proxyType, addr, err = sharing.acceptRequest(conn, enableHttp)
+			} else {
+				firstSendData, proxyType, addr, err = parser.Reply(conn, enableHttp)
+			}
+			if errors.Is(err, ErrAuthFailed) {
+				log.WithField("client", c.RemoteAddr().String()).Warning("proxy authentication failed.")
+				return
+			}
+			if err != nil {
+				log.Error("reply error: ", err)
+				return
+			}
+			// connections not through the tunnel are not counted.
+			switch d := router.Route(addr); d.Action {
+			case route.Reject:
+				log.WithField("address", addr).WithField("rule", d.Rule).Debug("connection is rejected by routing rule.")
+				replyRejected(conn.Conn, proxyType)
+				return
+			case route.Direct:
+				if err := transDirect(conn.Conn, proxyType, addr); err != nil {
+					log.WithField("address", addr).WithError(err).Debug("direct connection error.")
+				}
+				return
+			}
+			conn.address.Store(addr)
+
+			record.Update(wss.ConnStatus{IsNew: true, Address: addr, Type: proxyType})
+			defer record.Update(wss.ConnStatus{IsNew: false, Address: addr, Type: proxyType})
+
+			// on connection established, copy data now.
+			if err := transData(wsc, conn, firstSendData, proxyType, addr); err != nil {
+				log.Error("trans error: ", err)
+			}
+		}()
+	}
+}
+
+// transData copies data between the local connection and the proxy in websocket connection.
+func transData(wsc *wss.WebSocketClient, conn net.Conn, firstSendData []byte, proxyType int, addr string) error {
+	type Done struct {
+		tell bool
+		err  error
+	}
+	done := make(chan Done, 2)
+
+	// create a with proxy with callback func
+	proxy := wsc.NewProxy(func(id ksuid.KSUID, data wss.ServerData) {
+		if _, err := conn.Write(data.Data); err != nil {
+			done <- Done{true, err}
+		}
+	}, func(id ksuid.KSUID, tell bool) {
+		done <- Done{tell, nil}
+	}, func(id ksuid.KSUID, err error) {
+		if err != nil {
+			done <- Done{true, err}
+		}
+	})
+
+	// tell server to establish connection
+	if err := proxy.Establish(wsc, firstSendData, proxyType, addr); err != nil {
+		wsc.RemoveProxy(proxy.Id)
+		if err := wsc.TellClose(proxy.Id); err != nil {
+			log.Error("close error", err)
+		}
+		return err
+	}
+
+	// trans incoming data from proxy client application.
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := wss.NewWebSocketWriterWithMutex(&wsc.ConcurrentWebSocket, proxy.Id, ctx)
+	go func() {
+		_, err := io.Copy(writer, conn)
+		if err != nil {
+			log.Error("write error: ", err)
+		}
+		done <- Done{true, err}
+	}()
+	defer writer.CloseWsWriter(cancel) // cancel data writing
+
+	d := <-done
+	wsc.RemoveProxy(proxy.Id)
+	if d.tell {
+		if err := wsc.TellClose(proxy.Id); err != nil {
+			return err
+		}
+	}
+	return d.err
+}
+
+// DialTunnel connects to addr through the tunnel of current connection.
+// The returned connection is not counted for statistics.
+func (h *TaskHandles) DialTunnel(ctx context.Context, addr string) (net.Conn, error) {
+	h.lock.Lock()
+	wsc := h.wsc
+	h.lock.Unlock()
+	if wsc == nil || h.Phase() != PhaseConnected {
+		return nil, ErrNotConnected
+	}
+
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		if err := transData(wsc, remote, nil, wss.ProxyTypeSocks5, addr); err != nil {
+			log.WithField("address", addr).WithError(err).Debug("tunnel connection error.")
+		}
+	}()
+	// the server replies the socks5 success message once it connects to addr, or closes the proxy on failure.
+	if deadline, ok := ctx.Deadline(); ok {
+		local.SetReadDeadline(deadline)
+	}
+	if _, err := io.ReadFull(local, make([]byte, len(socks5Succeeded))); err != nil {
+		local.Close()
+		return nil, fmt.Errorf("connect to %s through the tunnel: %w", addr, err)
+	}
+	local.SetReadDeadline(time.Time{})
+	return local, nil
+}
@@ -0,0 +1,329 @@
+// Package config provides the configuration file (yaml) shared by cli and gui clients.
+// A configuration file contains multiple named profiles, e.g.:
+//
+//	default_profile: home
+//	profiles:
+//	  home:
+//	    remote: wss://proxy.example.com
+//	    token: xxx
+//	    local_addr: 127.0.0.1:1080
+//	    http: true
+//	    http_addr: 127.0.0.1:1086
+//	    vpn:
+//	      enable: true
+//	      username: xxx
+//	    pac:
+//	      addr: 127.0.0.1:1087
+//	      rules: ["*.smu.edu.cn", 10.0.0.0/8]
+//	    dns:
+//	      addr: 127.0.0.1:5353
+//	      zones: [smu.edu.cn]
+//	      upstream: 10.0.0.53
+//	    forwards:
+//	      - {local: 127.0.0.1:15432, remote: db.internal:5432}
+//	  lab:
+//	    remote: ws://10.0.0.1:1088
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/dns"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/pac"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	AuthMethodPasswd = "password"
+	AuthMethodQRCode = "qrcode"
+)
+
+type Config struct {
+	DefaultProfile string              `yaml:"default_profile,omitempty"`
+	Profiles       map[string]*Profile `yaml:"profiles"`
+}
+
+// Profile is one set of connection settings.
+// Empty (or nil) fields are not set in the file, and the defaults or command line flags are used.
+type Profile struct {
+	Remote        string    `yaml:"remote,omitempty" json:"remote,omitempty"`
+	Token         string    `yaml:"token,omitempty" json:"token,omitempty"`
+	LocalAddr     string    `yaml:"local_addr,omitempty" json:"local_addr,omitempty"`
+	Http          *bool     `yaml:"http,omitempty" json:"http,omitempty"`
+	HttpAddr      string    `yaml:"http_addr,omitempty" json:"http_addr,omitempty"`
+	SkipTLSVerify *bool     `yaml:"skip_tls_verify,omitempty" json:"skip_tls_verify,omitempty"`
+	AutoReconnect *bool     `yaml:"auto_reconnect,omitempty" json:"auto_reconnect,omitempty"`
+	RouteFile     string    `yaml:"route_file,omitempty" json:"route_file,omitempty"` // file of routing rules, see package route
+	Forwards      []Forward `yaml:"forwards,omitempty" json:"forwards,omitempty"`
+	Vpn           Vpn       `yaml:"vpn,omitempty" json:"vpn,omitempty"`
+	Pac           Pac       `yaml:"pac,omitempty" json:"pac,omitempty"`
+	Dns           Dns       `yaml:"dns,omitempty" json:"dns,omitempty"`
+	Sharing       Sharing   `yaml:"sharing,omitempty" json:"sharing,omitempty"`
+}
+
+// Forward is a local port forwarding through the tunnel, see extra.Forward.
+type Forward struct {
+	Local  string `yaml:"local" json:"local"`   // local listening address, e.g. 127.0.0.1:15432
+	Remote string `yaml:"remote" json:"remote"` // target address, e.g. db.internal:5432
+}
+
+// Vpn is the vpn settings in a profile.
+type Vpn struct {
+	Enable           *bool  `yaml:"enable,omitempty" json:"enable,omitempty"`
+	Auto             *bool  `yaml:"auto,omitempty" json:"auto,omitempty"`                 // skip vpn if the server is directly reachable
+	DirectProbe      string `yaml:"direct_probe,omitempty" json:"direct_probe,omitempty"` // host:port for checking direct reachability
+	Host             string `yaml:"host,omitempty" json:"host,omitempty"`
+	AuthMethod       string `yaml:"auth_method,omitempty" json:"auth_method,omitempty"` // AuthMethodPasswd or AuthMethodQRCode
+	Username         string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password         string `yaml:"password,omitempty" json:"password,omitempty"`
+	ForceLogout      *bool  `yaml:"force_logout,omitempty" json:"force_logout,omitempty"`
+	HostEncrypt      *bool  `yaml:"host_encrypt,omitempty" json:"host_encrypt,omitempty"`
+	CredentialHelper string `yaml:"credential_helper,omitempty" json:"credential_helper,omitempty"`
+}
+
+// Pac is the settings of serving PAC file in a profile, see package pac for the rules.
+type Pac struct {
+	Addr  string   `yaml:"addr,omitempty" json:"addr,omitempty"` // loopback address for serving PAC file
+	Rules []string `yaml:"rules,omitempty" json:"rules,omitempty"`
+}
+
+// Dns is the settings of local dns server in a profile, see package dns.
+type Dns struct {
+	Addr     string   `yaml:"addr,omitempty" json:"addr,omitempty"`         // loopback address of the dns server
+	Zones    []string `yaml:"zones,omitempty" json:"zones,omitempty"`       // internal zones resolved over the tunnel
+	Upstream string   `yaml:"upstream,omitempty" json:"upstream,omitempty"` // campus resolver of internal zones
+	Fallback string   `yaml:"fallback,omitempty" json:"fallback,omitempty"` // resolver of other names, system resolver if empty
+}
+
+// Sharing is the access control of local listeners for sharing the tunnel in LAN, see extra.Sharing.
+// The sharing mode is enabled if Allow is not empty.
+type Sharing struct {
+	Allow          []string `yaml:"allow,omitempty" json:"allow,omitempty"` // CIDRs or IPs of allowed clients
+	Users          []string `yaml:"users,omitempty" json:"users,omitempty"` // username:password of proxy authentication
+	MaxConnections int      `yaml:"max_connections,omitempty" json:"max_connections,omitempty"`
+}
+
+// DefaultPath returns the path of default configuration file,
+// e.g. ~/.config/wssocks-ustb/config.yaml on linux.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "wssocks-ustb", "config.yaml"), nil
+}
+
+// Load reads and parses configuration file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse parses configuration file content.
+func Parse(data []byte) (*Config, error) {
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	for name, p := range c.Profiles {
+		if p == nil {
+			c.Profiles[name] = &Profile{}
+			continue
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+	}
+	return &c, nil
+}
+
+// Validate checks the values in the profile.
+func (p *Profile) Validate() error {
+	switch p.Vpn.AuthMethod {
+	case "", AuthMethodPasswd, AuthMethodQRCode:
+	default:
+		return fmt.Errorf("unknown vpn auth method `%s`", p.Vpn.AuthMethod)
+	}
+	for _, f := range p.ExtraForwards() {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := pac.ValidateRules(p.Pac.Rules); err != nil {
+		return err
+	}
+	if p.Dns.Addr != "" {
+		if err := p.Dns.Options().Validate(); err != nil {
+			return err
+		}
+	}
+	if len(p.Sharing.Allow) != 0 {
+		if _, err := p.Sharing.Options(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtraForwards returns the port forwardings in the profile.
+func (p *Profile) ExtraForwards() []extra.Forward {
+	var forwards []extra.Forward
+	for _, f := range p.Forwards {
+		forwards = append(forwards, extra.Forward{Local: f.Local, Remote: f.Remote})
+	}
+	return forwards
+}
+
+// Options returns the options of dns server.
+func (d Dns) Options() dns.Options {
+	return dns.Options{Addr: d.Addr, Zones: d.Zones, Upstream: d.Upstream, Fallback: d.Fallback}
+}
+
+// Options returns the sharing options of extra, or nil if sharing is disabled.
+// The encrypted passwords must be decrypted before.
+func (s Sharing) Options() (*extra.Sharing, error) {
+	if len(s.Allow) == 0 {
+		return nil, nil
+	}
+	return extra.ParseSharing(s.Allow, s.Users, s.MaxConnections)
+}
+
+// ProfileNames returns sorted names of all profiles.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the profile by name.
+// If name is empty, the default profile is returned,
+// or the only profile if the default profile is not specified.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		if len(c.Profiles) == 1 {
+			for _, p := range c.Profiles {
+				return p, nil
+			}
+		}
+		return nil, errors.New("no profile is specified and no default profile in config")
+	}
+	if p, ok := c.Profiles[name]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("profile `%s` not found in config", name)
+}
+
+// Flags returns the values of the profile as command line flags of client sub-command.
+// Only the fields set in the profile are returned.
+func (p *Profile) Flags() map[string]string {
+	flags := make(map[string]string)
+	setString := func(name, value string) {
+		if value != "" {
+			flags[name] = value
+		}
+	}
+	setBool := func(name string, value *bool) {
+		if value != nil {
+			flags[name] = strconv.FormatBool(*value)
+		}
+	}
+	setString("remote", p.Remote)
+	setString("key", p.Token)
+	setString("addr", p.LocalAddr)
+	setBool("http", p.Http)
+	setString("http-addr", p.HttpAddr)
+	setBool("skip-tls-verify", p.SkipTLSVerify)
+	setBool("auto-reconnect", p.AutoReconnect)
+	setString("route-file", p.RouteFile)
+	if forwards := p.ExtraForwards(); len(forwards) != 0 {
+		items := make([]string, 0, len(forwards))
+		for _, f := range forwards {
+			items = append(items, f.String())
+		}
+		setString("forward", strings.Join(items, ","))
+	}
+	setBool("vpn-enable", p.Vpn.Enable)
+	setBool("vpn-auto", p.Vpn.Auto)
+	setString("vpn-direct-probe", p.Vpn.DirectProbe)
+	setString("vpn-host", p.Vpn.Host)
+	setString("vpn-username", p.Vpn.Username)
+	setString("vpn-password", p.Vpn.Password)
+	setBool("vpn-force-logout", p.Vpn.ForceLogout)
+	setBool("vpn-host-encrypt", p.Vpn.HostEncrypt)
+	setString("vpn-credential-helper", p.Vpn.CredentialHelper)
+	setString("vpn-auth-method", p.Vpn.AuthMethod)
+	setString("pac-addr", p.Pac.Addr)
+	setString("pac-rules", strings.Join(p.Pac.Rules, ","))
+	setString("dns-addr", p.Dns.Addr)
+	setString("dns-zones", strings.Join(p.Dns.Zones, ","))
+	setString("dns-upstream", p.Dns.Upstream)
+	setString("dns-fallback", p.Dns.Fallback)
+	setString("share-allow", strings.Join(p.Sharing.Allow, ","))
+	setString("share-users", extra.JoinUsers(p.Sharing.Users))
+	if p.Sharing.MaxConnections != 0 {
+		setString("share-max-conns", strconv.Itoa(p.Sharing.MaxConnections))
+	}
+	return flags
+}
+
+// Apply sets the fields of options which are set in the profile.
+// Other fields in options are kept, so the caller can fill defaults in options before.
+func (p *Profile) Apply(options *extra.Options) {
+	applyString := func(dst *string, value string) {
+		if value != "" {
+			*dst = value
+		}
+	}
+	applyBool := func(dst *bool, value *bool) {
+		if value != nil {
+			*dst = *value
+		}
+	}
+	applyString(&options.RemoteAddr, p.Remote)
+	applyString(&options.AuthToken, p.Token)
+	applyString(&options.LocalSocks5Addr, p.LocalAddr)
+	applyBool(&options.HttpEnabled, p.Http)
+	applyString(&options.LocalHttpAddr, p.HttpAddr)
+	applyBool(&options.SkipTLSVerify, p.SkipTLSVerify)
+	applyBool(&options.AutoReconnect.Enable, p.AutoReconnect)
+	if len(p.Forwards) != 0 {
+		options.Forwards = p.ExtraForwards()
+	}
+	// the sharing is checked in Validate.
+	if sharing, err := p.Sharing.Options(); err == nil && sharing != nil {
+		options.Sharing = sharing
+	}
+
+	applyBool(&options.Enable, p.Vpn.Enable)
+	applyBool(&options.Auto, p.Vpn.Auto)
+	applyString(&options.DirectProbe, p.Vpn.DirectProbe)
+	applyString(&options.TargetVpn, p.Vpn.Host)
+	switch p.Vpn.AuthMethod {
+	case AuthMethodPasswd:
+		options.AuthMethod = vpn.VpnAuthMethodPasswd
+	case AuthMethodQRCode:
+		options.AuthMethod = vpn.VpnAuthMethodQRCode
+	}
+	applyString(&options.PasswdAuth.Username, p.Vpn.Username)
+	applyString(&options.PasswdAuth.Password, p.Vpn.Password)
+	applyBool(&options.ForceLogout, p.Vpn.ForceLogout)
+	applyBool(&options.HostEncrypt, p.Vpn.HostEncrypt)
+	applyString(&options.CredentialHelper, p.Vpn.CredentialHelper)
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
+)
+
+const testConfig = `
+default_profile: home
+profiles:
+  home:
+    remote: wss://proxy.example.com
+    token: abc
+    http: true
+    auto_reconnect: true
+    route_file: /etc/wssocks-ustb/rules
+    forwards:
+      - {local: 127.0.0.1:15432, remote: db.internal:5432}
+      - {local: ":2222", remote: "lab.smu.edu.cn:22"}
+    vpn:
+      enable: true
+      auto: true
+      username: user1
+      host_encrypt: false
+      auth_method: qrcode
+    pac:
+      addr: 127.0.0.1:1087
+      rules: ["*.smu.edu.cn", 10.0.0.0/8]
+    dns:
+      addr: 127.0.0.1:5353
+      zones: [smu.edu.cn, campus.local]
+      upstream: 10.0.0.53
+  lab:
+    remote: ws://10.0.0.1:1088
+    local_addr: 127.0.0.1:2080
+`
+
+func TestProfile(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := c.ProfileNames(); len(names) != 2 || names[0] != "home" || names[1] != "lab" {
+		t.Error("profile names are not as expected:", names)
+	}
+
+	p, err := c.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Remote != "wss://proxy.example.com" {
+		t.Error("default profile is not loaded, remote:", p.Remote)
+	}
+	if _, err := c.Profile("backup-server"); err == nil {
+		t.Error("expect error for unknown profile")
+	}
+
+	flags := p.Flags()
+	expected := map[string]string{
+		"remote":           "wss://proxy.example.com",
+		"key":              "abc",
+		"http":             "true",
+		"auto-reconnect":   "true",
+		"route-file":       "/etc/wssocks-ustb/rules",
+		"forward":          "127.0.0.1:15432=db.internal:5432,:2222=lab.smu.edu.cn:22",
+		"vpn-enable":       "true",
+		"vpn-auto":         "true",
+		"vpn-username":     "user1",
+		"vpn-host-encrypt": "false",
+		"vpn-auth-method":  "qrcode",
+		"pac-addr":         "127.0.0.1:1087",
+		"pac-rules":        "*.smu.edu.cn,10.0.0.0/8",
+		"dns-addr":         "127.0.0.1:5353",
+		"dns-zones":        "smu.edu.cn,campus.local",
+		"dns-upstream":     "10.0.0.53",
+	}
+	if len(flags) != len(expected) {
+		t.Error("flags are not as expected:", flags)
+	}
+	for k, v := range expected {
+		if flags[k] != v {
+			t.Errorf("flag %s is `%s`, but `%s` is expected", k, flags[k], v)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := extra.Options{}
+	options.LocalSocks5Addr = "127.0.0.1:1080"
+	options.HostEncrypt = true
+
+	p, _ := c.Profile("home")
+	p.Apply(&options)
+	if options.LocalSocks5Addr != "127.0.0.1:1080" {
+		t.Error("unset field is changed:", options.LocalSocks5Addr)
+	}
+	if options.HostEncrypt || !options.Enable || !options.HttpEnabled || !options.AutoReconnect.Enable || !options.Auto {
+		t.Error("bool fields are not applied")
+	}
+	if options.AuthMethod != vpn.VpnAuthMethodQRCode || options.PasswdAuth.Username != "user1" || options.AuthToken != "abc" {
+		t.Error("options are not applied:", options)
+	}
+	if len(options.Forwards) != 2 || options.Forwards[0].Remote != "db.internal:5432" {
+		t.Error("forwardings are not applied:", options.Forwards)
+	}
+}
+
+func TestBadAuthMethod(t *testing.T) {
+	if _, err := Parse([]byte("profiles:\n  a:\n    vpn:\n      auth_method: sms\n")); err == nil {
+		t.Error("expect error for unknown auth method")
+	}
+}
+
+func TestBadForward(t *testing.T) {
+	if _, err := Parse([]byte("profiles:\n  a:\n    forwards:\n      - {local: 15432, remote: db.internal:5432}\n")); err == nil {
+		t.Error("expect error for forwarding without local port")
+	}
+}
+
+func TestBadDns(t *testing.T) {
+	if _, err := Parse([]byte("profiles:\n  a:\n    dns:\n      addr: 127.0.0.1:5353\n      zones: [smu.edu.cn]\n")); err == nil {
+		t.Error("expect error for internal zones without upstream")
+	}
+}
+
+func TestSharing(t *testing.T) {
+	c, err := Parse([]byte("profiles:\n  a:\n    sharing:\n      allow: [192.168.1.0/24, 10.0.0.8]\n" +
+		"      users: [\"alice:secret\", \"bob:a,b:c\\\\d\"]\n      max_connections: 16\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := c.Profile("a")
+	options := extra.Options{}
+	p.Apply(&options)
+	if s := options.Sharing; s == nil || len(s.Allow) != 2 || s.Users["alice"] != "secret" || s.MaxConnections != 16 {
+		t.Errorf("sharing is not applied: %+v", options.Sharing)
+	}
+	// the password of bob contains the separators.
+	if password := options.Sharing.Users["bob"]; password != `a,b:c\d` {
+		t.Errorf("unexpected password of bob: %q", password)
+	}
+	flags := p.Flags()
+	if flags["share-allow"] != "192.168.1.0/24,10.0.0.8" || flags["share-max-conns"] != "16" {
+		t.Errorf("unexpected flags: %v", flags)
+	}
+	if users := extra.SplitUsers(flags["share-users"]); !reflect.DeepEqual(users, p.Sharing.Users) {
+		t.Errorf("users in flags: got %q, want %q", users, p.Sharing.Users)
+	}
+
+	if _, err := Parse([]byte("profiles:\n  a:\n    sharing:\n      allow: [192.168.1.0/33]\n")); err == nil {
+		t.Error("expect error for bad network of sharing")
+	}
+	if _, err := Parse([]byte("profiles:\n  a:\n    sharing:\n      allow: [192.168.1.0/24]\n      users: [alice]\n")); err == nil {
+		t.Error("expect error for user without password")
+	}
+}
+
+func TestBadPacRule(t *testing.T) {
+	if _, err := Parse([]byte("profiles:\n  a:\n    pac:\n      rules: [fd00::/8]\n")); err == nil {
+		t.Error("expect error for ipv6 pac rule")
+	}
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"gopkg.in/yaml.v3"
+)
+
+// EncryptedPrefix is the prefix of secrets encrypted by passphrase in configuration file,
+// e.g. `token: enc:xxxx`.
+const EncryptedPrefix = "enc:"
+
+// LinkScheme is the scheme of links carrying a configuration,
+// e.g. wssocks-ustb://import?config=<base64 url encoded yaml>.
+const LinkScheme = "wssocks-ustb"
+
+var ErrPassphraseRequired = errors.New("passphrase is required to decrypt secrets in config")
+var ErrWrongPassphrase = errors.New("wrong passphrase for secrets in config")
+
+// Marshal returns the yaml content of the configuration.
+func (c *Config) Marshal() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+// Save writes the configuration to path.
+// The file is only readable by current user, as it may contain secrets.
+func (c *Config) Save(path string) error {
+	data, err := c.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Link returns the wssocks-ustb:// link carrying the configuration.
+func (c *Config) Link() (string, error) {
+	data, err := c.Marshal()
+	if err != nil {
+		return "", err
+	}
+	u := url.URL{Scheme: LinkScheme, Host: "import",
+		RawQuery: url.Values{"config": {base64.RawURLEncoding.EncodeToString(data)}}.Encode()}
+	return u.String(), nil
+}
+
+// IsLink returns true if s looks like a wssocks-ustb:// link.
+func IsLink(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), LinkScheme+"://")
+}
+
+// ParseLink parses the configuration carried by a wssocks-ustb:// link.
+func ParseLink(link string) (*Config, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return nil, fmt.Errorf("parse link: %w", err)
+	}
+	if u.Scheme != LinkScheme || u.Host != "import" {
+		return nil, fmt.Errorf("unsupported link `%s://%s`", u.Scheme, u.Host)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(u.Query().Get("config"))
+	if err != nil {
+		return nil, fmt.Errorf("parse link: %w", err)
+	}
+	return Parse(data)
+}
+
+// OmitSecrets removes token, vpn password and the users of sharing from all profiles.
+func (c *Config) OmitSecrets() {
+	for _, p := range c.Profiles {
+		p.Token = ""
+		p.Vpn.Password = ""
+		p.Sharing.Users = nil
+	}
+}
+
+// EncryptSecrets encrypts token, vpn password and the users of sharing in all profiles by passphrase.
+func (c *Config) EncryptSecrets(passphrase string) error {
+	for _, p := range c.Profiles {
+		if err := p.EncryptSecrets(passphrase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecryptSecrets decrypts the secrets encrypted by EncryptSecrets in all profiles.
+func (c *Config) DecryptSecrets(passphrase string) error {
+	for name, p := range c.Profiles {
+		if err := p.DecryptSecrets(passphrase); err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// HasEncryptedSecrets returns true if any profile has secrets encrypted by passphrase.
+func (c *Config) HasEncryptedSecrets() bool {
+	for _, p := range c.Profiles {
+		if p.HasEncryptedSecrets() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Profile) secrets() []*string {
+	secrets := []*string{&p.Token, &p.Vpn.Password}
+	for i := range p.Sharing.Users {
+		secrets = append(secrets, &p.Sharing.Users[i])
+	}
+	return secrets
+}
+
+func (p *Profile) EncryptSecrets(passphrase string) error {
+	for _, s := range p.secrets() {
+		if *s == "" || strings.HasPrefix(*s, EncryptedPrefix) {
+			continue
+		}
+		ciphertext, err := encryptSecret(passphrase, *s)
+		if err != nil {
+			return err
+		}
+		*s = ciphertext
+	}
+	return nil
+}
+
+func (p *Profile) DecryptSecrets(passphrase string) error {
+	for _, s := range p.secrets() {
+		if !strings.HasPrefix(*s, EncryptedPrefix) {
+			continue
+		}
+		if passphrase == "" {
+			return ErrPassphraseRequired
+		}
+		plaintext, err := decryptSecret(passphrase, *s)
+		if err != nil {
+			return err
+		}
+		*s = plaintext
+	}
+	return nil
+}
+
+func (p *Profile) HasEncryptedSecrets() bool {
+	for _, s := range p.secrets() {
+		if strings.HasPrefix(*s, EncryptedPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+const secretSaltSize = 16
+
+func secretCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+// encryptSecret encrypts plaintext with a key derived from passphrase (argon2id, AES-256-GCM).
+// The result is EncryptedPrefix + base64(salt | nonce | ciphertext).
+func encryptSecret(passphrase, plaintext string) (string, error) {
+	salt := make([]byte, secretSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	gcm, err := secretCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	data := append(salt, gcm.Seal(nonce, nonce, []byte(plaintext), nil)...)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decryptSecret(passphrase, ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, EncryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("bad encrypted secret: %w", err)
+	}
+	if len(data) < secretSaltSize {
+		return "", errors.New("bad encrypted secret: too short")
+	}
+	gcm, err := secretCipher(passphrase, data[:secretSaltSize])
+	if err != nil {
+		return "", err
+	}
+	data = data[secretSaltSize:]
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("bad encrypted secret: too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncryptSecrets(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EncryptSecrets("pass"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(c.Profiles["home"].Token, EncryptedPrefix) {
+		t.Fatal("token is not encrypted:", c.Profiles["home"].Token)
+	}
+	if c.Profiles["lab"].HasEncryptedSecrets() {
+		t.Error("empty secrets should not be encrypted")
+	}
+
+	// round trip by file content
+	data, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.HasEncryptedSecrets() {
+		t.Fatal("encrypted secrets are lost")
+	}
+	if err := c.DecryptSecrets(""); !errors.Is(err, ErrPassphraseRequired) {
+		t.Error("expected ErrPassphraseRequired, got", err)
+	}
+	if err := c.DecryptSecrets("wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Error("expected ErrWrongPassphrase, got", err)
+	}
+	if err := c.DecryptSecrets("pass"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Profiles["home"].Token != "abc" {
+		t.Error("token is not decrypted:", c.Profiles["home"].Token)
+	}
+}
+
+func TestLink(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.OmitSecrets()
+	link, err := c.Link()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsLink(link) {
+		t.Fatal("not a link:", link)
+	}
+	c2, err := ParseLink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.DefaultProfile != "home" || c2.Profiles["lab"].LocalAddr != "127.0.0.1:2080" {
+		t.Error("config in link is not as expected")
+	}
+	if c2.Profiles["home"].Token != "" {
+		t.Error("secrets should be omitted")
+	}
+	if _, err := ParseLink("http://import?config=xx"); err == nil {
+		t.Error("expected error for bad link")
+	}
+}
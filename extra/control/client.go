@@ -0,0 +1,198 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Info is the address and token of a running control api.
+// Each client writes it to its own file (see InfoPath), so that the ctl command can find the api.
+type Info struct {
+	Addr  string `json:"addr"`
+	Token string `json:"token,omitempty"`
+	PID   int    `json:"pid,omitempty"` // process id of the client
+}
+
+// InfoDir returns the directory of info files, which is in the same directory as default configuration file.
+func InfoDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "wssocks-ustb", "control"), nil
+}
+
+// InfoPath returns the path of the info file of client process pid, in InfoDir.
+// The files are keyed by pid, so that the clients running at the same time do not overwrite each other.
+func InfoPath(pid int) (string, error) {
+	dir, err := InfoDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(pid)+".json"), nil
+}
+
+// WriteInfo writes info to path. The file is only readable by current user, because it contains the token.
+func WriteInfo(path string, info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ReadInfo reads info written by WriteInfo.
+func ReadInfo(path string) (Info, error) {
+	var info Info
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// ReadInfos reads all info files in dir, the files can not be read are skipped.
+func ReadInfos(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var infos []Info
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		if info, err := ReadInfo(filepath.Join(dir, e.Name())); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// RunningClient is a client found by Discover.
+type RunningClient struct {
+	Info
+	Status Status
+}
+
+// Discover returns the running clients in the info files of InfoDir, which use the profile
+// (all clients if profile is empty). The files left by crashed clients are skipped, because their api does not respond.
+func Discover(profile string) ([]RunningClient, error) {
+	dir, err := InfoDir()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ReadInfos(dir)
+	if err != nil {
+		return nil, err
+	}
+	var clients []RunningClient
+	for _, info := range infos {
+		status, err := NewClient(info).Status()
+		if err != nil || (profile != "" && status.Profile != profile) {
+			continue
+		}
+		clients = append(clients, RunningClient{Info: info, Status: status})
+	}
+	return clients, nil
+}
+
+// Client calls the control api.
+type Client struct {
+	info   Info
+	base   string
+	client *http.Client
+}
+
+// NewClient creates a client of the control api listening on info.Addr (see Listen).
+func NewClient(info Info) *Client {
+	c := &Client{info: info, base: "http://" + info.Addr, client: &http.Client{Timeout: 10 * time.Second}}
+	if strings.HasPrefix(info.Addr, UnixPrefix) {
+		path := strings.TrimPrefix(info.Addr, UnixPrefix)
+		c.base = "http://unix"
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+func (c *Client) Status() (Status, error) {
+	var status Status
+	err := c.call(http.MethodGet, "/status", &status)
+	return status, err
+}
+
+func (c *Client) Stats() (Stats, error) {
+	var stats Stats
+	err := c.call(http.MethodGet, "/stats", &stats)
+	return stats, err
+}
+
+// Reconnect asks the client to reconnect, and returns the status after the request is accepted.
+func (c *Client) Reconnect() (Status, error) {
+	var status Status
+	err := c.call(http.MethodPost, "/reconnect", &status)
+	return status, err
+}
+
+// Relogin asks the client to reconnect and log in vpn again.
+func (c *Client) Relogin() (Status, error) {
+	var status Status
+	err := c.call(http.MethodPost, "/relogin", &status)
+	return status, err
+}
+
+// Stop asks the client to stop.
+func (c *Client) Stop() error {
+	return c.call(http.MethodPost, "/stop", nil)
+}
+
+func (c *Client) call(method, path string, result interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.info.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.info.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("control api: %s", resp.Status)
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			return ErrUnauthorized
+		}
+		return errors.New(e.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,106 @@
+package control
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+)
+
+func TestListenAddress(t *testing.T) {
+	var handles extra.TaskHandles
+	if _, err := Listen("0.0.0.0:0", "token", &handles); !errors.Is(err, ErrNotLoopback) {
+		t.Error("expected ErrNotLoopback, got", err)
+	}
+	if _, err := Listen("127.0.0.1:0", "", &handles); !errors.Is(err, ErrEmptyToken) {
+		t.Error("expected ErrEmptyToken, got", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	var handles extra.TaskHandles
+	for _, addr := range []string{"127.0.0.1:0", UnixPrefix + filepath.Join(t.TempDir(), "control.sock")} {
+		s, err := Listen(addr, "token", &handles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Serve()
+		if path := strings.TrimPrefix(addr, UnixPrefix); path != addr && runtime.GOOS != "windows" {
+			if fi, err := os.Stat(path); err != nil || fi.Mode().Perm()&0077 != 0 {
+				t.Errorf("socket file should be only accessible by current user: %v %v", fi.Mode(), err)
+			}
+			if s.Addr() != addr {
+				t.Errorf("got address %s, want %s", s.Addr(), addr)
+			}
+			defer func() {
+				if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 0 {
+					t.Errorf("socket file should be removed after closing: %v", entries)
+				}
+			}()
+		}
+
+		if _, err := NewClient(Info{Addr: s.Addr(), Token: "wrong"}).Status(); !errors.Is(err, ErrUnauthorized) {
+			t.Error("expected ErrUnauthorized, got", err)
+		}
+		c := NewClient(Info{Addr: s.Addr(), Token: "token"})
+		status, err := c.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Phase != extra.PhaseStopped {
+			t.Error("unexpected phase", status.Phase)
+		}
+		if _, err := c.Reconnect(); err == nil || err.Error() != extra.ErrNotConnected.Error() {
+			t.Error("expected not connected error, got", err)
+		}
+		if _, err := c.Stats(); err != nil {
+			t.Error(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wssocks-ustb", "control", "1.json")
+	info := Info{Addr: "127.0.0.1:7080", Token: "token", PID: 1}
+	if err := WriteInfo(path, info); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != info {
+		t.Errorf("got %+v, want %+v", read, info)
+	}
+}
+
+func TestReadInfos(t *testing.T) {
+	dir := t.TempDir()
+	infos := []Info{{Addr: "127.0.0.1:7080", Token: "a", PID: 1}, {Addr: "unix:/tmp/control.sock", Token: "b", PID: 2}}
+	for _, info := range infos {
+		if err := WriteInfo(filepath.Join(dir, strconv.Itoa(info.PID)+".json"), info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "3.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadInfos(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(infos) || read[0] != infos[0] || read[1] != infos[1] {
+		t.Errorf("got %+v, want %+v", read, infos)
+	}
+	if read, err := ReadInfos(filepath.Join(dir, "missing")); err != nil || len(read) != 0 {
+		t.Errorf("missing directory: got %+v %v", read, err)
+	}
+}
@@ -0,0 +1,235 @@
+// Package control provides a local http api for controlling a running client,
+// e.g. checking status and statistics, reconnecting and stopping.
+// The api listens on a unix socket ("unix:" + path) or a loopback tcp address,
+// and requests must carry the token by `Authorization: Bearer <token>` header.
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+)
+
+// UnixPrefix is the prefix of address for listening on a unix socket.
+const UnixPrefix = "unix:"
+
+var (
+	ErrNotLoopback  = errors.New("control api can only listen on loopback address")
+	ErrEmptyToken   = errors.New("token of control api is required for tcp address (and unix socket on windows)")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Status is the status of a running client.
+type Status struct {
+	Phase      extra.Phase `json:"phase"`
+	Remote     string      `json:"remote"`
+	Profile    string      `json:"profile,omitempty"`
+	Vpn        bool        `json:"vpn"`
+	Socks5Addr string      `json:"socks5_addr"`
+	HttpAddr   string      `json:"http_addr,omitempty"` // empty if http proxy is disabled
+	Since      time.Time   `json:"since"`
+}
+
+// Stats is the traffic statistics of a running client.
+type Stats struct {
+	BytesUp           uint64                      `json:"bytes_up"`
+	BytesDown         uint64                      `json:"bytes_down"`
+	ActiveConnections uint64                      `json:"active_connections"`
+	TotalConnections  uint64                      `json:"total_connections"`
+	Reconnects        uint64                      `json:"reconnects"`
+	Since             time.Time                   `json:"since"`
+	Destinations      map[string]DestinationStats `json:"destinations,omitempty"`
+	Clients           map[string]ClientStats      `json:"clients,omitempty"` // only counted in sharing mode
+}
+
+type DestinationStats struct {
+	ActiveConnections uint64 `json:"active_connections"`
+	TotalConnections  uint64 `json:"total_connections"`
+	BytesUp           uint64 `json:"bytes_up"`
+	BytesDown         uint64 `json:"bytes_down"`
+}
+
+type ClientStats struct {
+	ActiveConnections uint64 `json:"active_connections"`
+	TotalConnections  uint64 `json:"total_connections"`
+	Rejected          uint64 `json:"rejected"`
+	BytesUp           uint64 `json:"bytes_up"`
+	BytesDown         uint64 `json:"bytes_down"`
+}
+
+// errorResponse is the response body if a request fails.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the control api of a TaskHandles.
+type Server struct {
+	handles  *extra.TaskHandles
+	token    string
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen listens on addr for the control api of handles.
+// The token can only be empty if addr is a unix socket (the socket file is only accessible by current user),
+// except on windows.
+func Listen(addr, token string, handles *extra.TaskHandles) (*Server, error) {
+	l, err := listen(addr, token)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{handles: handles, token: token, listener: l}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.get(s.status))
+	mux.HandleFunc("/stats", s.get(s.stats))
+	mux.HandleFunc("/reconnect", s.post(handles.Reconnect))
+	mux.HandleFunc("/relogin", s.post(handles.Relogin))
+	mux.HandleFunc("/stop", s.post(func() error {
+		handles.NotifyCloseWrapper()
+		return nil
+	}))
+	s.server = &http.Server{Handler: s.auth(mux), ReadHeaderTimeout: 10 * time.Second}
+	return s, nil
+}
+
+func listen(addr, token string) (net.Listener, error) {
+	if strings.HasPrefix(addr, UnixPrefix) {
+		if token == "" && !privateUnixSocket {
+			return nil, ErrEmptyToken
+		}
+		path := strings.TrimPrefix(addr, UnixPrefix)
+		// remove the socket file left by last run.
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return listenUnix(path)
+	}
+
+	if token == "" {
+		return nil, ErrEmptyToken
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("%w: %s", ErrNotLoopback, addr)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Addr returns the address of the listener, in the form accepted by NewClient.
+func (s *Server) Addr() string {
+	if s.listener.Addr().Network() == "unix" {
+		return UnixPrefix + s.listener.Addr().String()
+	}
+	return s.listener.Addr().String()
+}
+
+// Serve serves the api until Close is called.
+func (s *Server) Serve() error {
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the server. The requests in processing (e.g. the stop request) are finished before closing.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, errorResponse{Error: ErrUnauthorized.Error()})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) get(handler func() interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, handler())
+	}
+}
+
+func (s *Server) post(action func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+			return
+		}
+		if err := action(); err != nil {
+			writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, s.status())
+	}
+}
+
+func (s *Server) status() interface{} {
+	options := s.handles.Options()
+	status := Status{
+		Phase:      s.handles.Phase(),
+		Remote:     options.RemoteAddr,
+		Profile:    options.Profile,
+		Vpn:        options.UstbVpn.Enable,
+		Socks5Addr: options.LocalSocks5Addr,
+		Since:      s.handles.Stats().Since,
+	}
+	if options.HttpEnabled {
+		status.HttpAddr = options.LocalHttpAddr
+	}
+	return status
+}
+
+func (s *Server) stats() interface{} {
+	st := s.handles.Stats()
+	stats := Stats{
+		BytesUp:           st.BytesUp,
+		BytesDown:         st.BytesDown,
+		ActiveConnections: st.ActiveConnections,
+		TotalConnections:  st.TotalConnections,
+		Reconnects:        st.Reconnects,
+		Since:             st.Since,
+		Destinations:      make(map[string]DestinationStats, len(st.Destinations)),
+	}
+	for host, d := range st.Destinations {
+		stats.Destinations[host] = DestinationStats(d)
+	}
+	if len(st.Clients) != 0 {
+		stats.Clients = make(map[string]ClientStats, len(st.Clients))
+		for client, c := range st.Clients {
+			stats.Clients[client] = ClientStats(c)
+		}
+	}
+	return stats
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	maxCacheTTL      = time.Hour
+	negativeCacheTTL = 30 * time.Second // for responses without records, e.g. NXDOMAIN without SOA
+)
+
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheEntry struct {
+	msg     []byte
+	expires time.Time
+}
+
+// cache caches the responses by question, until the min ttl of records expires.
+// The ttl in cached responses is not decreased.
+type cache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[cacheKey]cacheEntry
+}
+
+func newCache(size int) *cache {
+	return &cache{size: size, entries: make(map[cacheKey]cacheEntry)}
+}
+
+// get returns a copy of the cached response of question with the id of query, or nil if it is not cached.
+func (c *cache) get(q question, id []byte, now time.Time) []byte {
+	key := cacheKey{q.name, q.qtype, q.qclass}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if now.After(e.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	msg := append([]byte(nil), e.msg...)
+	copy(msg, id[:2])
+	return msg
+}
+
+// put caches the response of question if it is successful or the name does not exist.
+func (c *cache) put(q question, msg []byte, now time.Time) {
+	if code := rcode(msg); code != RcodeSuccess && code != RcodeNameError {
+		return
+	}
+	ttl, ok, err := minTTL(msg)
+	if err != nil || (ok && ttl == 0) {
+		return
+	}
+	d := negativeCacheTTL
+	if ok {
+		d = time.Duration(ttl) * time.Second
+	}
+	if d > maxCacheTTL {
+		d = maxCacheTTL
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.entries) >= c.size {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	// still full, remove any entry.
+	for k := range c.entries {
+		if len(c.entries) < c.size {
+			break
+		}
+		delete(c.entries, k)
+	}
+	c.entries[cacheKey{q.name, q.qtype, q.qclass}] = cacheEntry{msg: append([]byte(nil), msg...), expires: now.Add(d)}
+}
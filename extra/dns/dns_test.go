@@ -0,0 +1,246 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newQuery builds a query with recursion desired.
+func newQuery(id uint16, name string, qtype uint16) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 0x01, 0, 0, 1, 0, 0, 0, 0, 0, 0) // RD, one question
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, classIN)
+}
+
+// answer builds a response of query with A records of ips.
+func answer(t *testing.T, query []byte, ttl uint32, ips ...string) []byte {
+	q, err := parseQuestion(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var answers [][]byte
+	for _, ip := range ips {
+		answers = append(answers, addressRecord(net.ParseIP(ip), ttl))
+	}
+	return newResponse(query, q, RcodeSuccess, answers...)
+}
+
+// tunnelDialer returns a Dialer answering queries with an A record of ip, like a resolver through the tunnel.
+func tunnelDialer(t *testing.T, ip string, dials *int32) Dialer {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		atomic.AddInt32(dials, 1)
+		if addr != "10.0.0.53:53" {
+			t.Errorf("dialing %s, want upstream 10.0.0.53:53", addr)
+		}
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			query, err := readTCP(server)
+			if err != nil {
+				return
+			}
+			writeTCP(server, answer(t, query, 300, ip))
+		}()
+		return client, nil
+	}
+}
+
+func TestMessage(t *testing.T) {
+	query := newQuery(0x1234, "Lib.SMU.edu.cn.", TypeA)
+	q, err := parseQuestion(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.name != "lib.smu.edu.cn" || q.qtype != TypeA || q.qclass != classIN || q.end != len(query) {
+		t.Errorf("unexpected question: %+v", q)
+	}
+	if _, err := parseQuestion(query[:q.end-1]); err == nil {
+		t.Error("truncated question should be rejected")
+	}
+
+	resp := answer(t, query, 120, "10.1.2.3", "10.1.2.4")
+	if resp[0] != 0x12 || resp[1] != 0x34 || resp[2]&0x80 == 0 || rcode(resp) != RcodeSuccess {
+		t.Errorf("unexpected response header: %x", resp[:headerLen])
+	}
+	if ttl, ok, err := minTTL(resp); err != nil || !ok || ttl != 120 {
+		t.Errorf("minTTL: got %d %v %v", ttl, ok, err)
+	}
+	if _, ok, err := minTTL(newResponse(query, q, RcodeNameError)); err != nil || ok {
+		t.Errorf("minTTL without records: got %v %v", ok, err)
+	}
+
+	var ips []string
+	for i := 0; i < 40; i++ {
+		ips = append(ips, "10.0.0.1")
+	}
+	long := answer(t, query, 60, ips...)
+	if tr := truncate(query, long, q); tr[2]&0x02 == 0 || len(tr) != q.end {
+		t.Errorf("long response should be truncated: %x", tr[:headerLen])
+	}
+	if tr := truncate(query, resp, q); len(tr) != len(resp) {
+		t.Error("short response should not be truncated")
+	}
+}
+
+func TestMatchZone(t *testing.T) {
+	zones := []string{"smu.edu.cn", ".Campus.Local."}
+	for name, zone := range map[string]string{
+		"smu.edu.cn":           "smu.edu.cn",
+		"LIB.smu.edu.cn.":      "smu.edu.cn",
+		"printer.campus.local": "campus.local",
+		"notsmu.edu.cn":        "",
+		"example.com":          "",
+	} {
+		if got := MatchZone(zones, name); got != zone {
+			t.Errorf("MatchZone(%q): got %q, want %q", name, got, zone)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	if _, err := Listen(Options{Addr: "0.0.0.0:0"}, nil); err != ErrNotLoopback {
+		t.Errorf("listening on non-loopback address: got %v, want %v", err, ErrNotLoopback)
+	}
+	if _, err := Listen(Options{Addr: "127.0.0.1:0", Zones: []string{"smu.edu.cn"}}, nil); err == nil {
+		t.Error("upstream should be required for internal zones")
+	}
+	if zones := ParseZones("smu.edu.cn, campus.local\nlab"); len(zones) != 3 || zones[1] != "campus.local" {
+		t.Errorf("ParseZones: got %q", zones)
+	}
+	if addr, err := withDefaultPort("[fd00::53]"); err != nil || addr != "[fd00::53]:53" {
+		t.Errorf("withDefaultPort: got %s %v", addr, err)
+	}
+}
+
+func TestInternalZone(t *testing.T) {
+	var dials int32
+	s, err := Listen(Options{Addr: "127.0.0.1:0", Zones: []string{"smu.edu.cn"}, Upstream: "10.0.0.53"},
+		tunnelDialer(t, "10.1.2.3", &dials))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i, id := range []uint16{1, 2} {
+		resp, err := s.Exchange(newQuery(id, "lib.smu.edu.cn", TypeA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binary.BigEndian.Uint16(resp) != id || rcode(resp) != RcodeSuccess {
+			t.Errorf("query %d: unexpected response header: %x", i, resp[:headerLen])
+		}
+		if !net.IP(resp[len(resp)-4:]).Equal(net.ParseIP("10.1.2.3")) {
+			t.Errorf("query %d: unexpected address %v", i, net.IP(resp[len(resp)-4:]))
+		}
+	}
+	if dials != 1 {
+		t.Errorf("the second query should be answered from cache, dials: %d", dials)
+	}
+}
+
+func TestServerFailure(t *testing.T) {
+	var dials int32
+	s, err := Listen(Options{Addr: "127.0.0.1:0", Zones: []string{"smu.edu.cn"}, Upstream: "10.0.0.53"},
+		func(ctx context.Context, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return nil, errors.New("not connected")
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := s.Exchange(newQuery(1, "lib.smu.edu.cn", TypeA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rcode(resp) != RcodeServerFailure {
+			t.Errorf("rcode: got %d, want %d", rcode(resp), RcodeServerFailure)
+		}
+	}
+	if dials != 2 {
+		t.Errorf("failures should not be cached, dials: %d", dials)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	fallback, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fallback.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := fallback.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			fallback.WriteTo(answer(t, buf[:n], 60, "93.184.216.34"), addr)
+		}
+	}()
+
+	var dials int32
+	s, err := Listen(Options{Addr: "127.0.0.1:0", Zones: []string{"smu.edu.cn"}, Upstream: "10.0.0.53",
+		Fallback: fallback.LocalAddr().String(), CacheSize: -1}, tunnelDialer(t, "10.1.2.3", &dials))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	resp, err := s.Exchange(newQuery(7, "example.com", TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !net.IP(resp[len(resp)-4:]).Equal(net.ParseIP("93.184.216.34")) {
+		t.Errorf("unexpected address %v", net.IP(resp[len(resp)-4:]))
+	}
+	if dials != 0 {
+		t.Errorf("names out of internal zones should not be sent through the tunnel, dials: %d", dials)
+	}
+}
+
+func TestServe(t *testing.T) {
+	var dials int32
+	s, err := Listen(Options{Addr: "127.0.0.1:0", Zones: []string{"smu.edu.cn"}, Upstream: "10.0.0.53"},
+		tunnelDialer(t, "10.1.2.3", &dials))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve() }()
+
+	for _, network := range []string{"udp", "tcp"} {
+		resolver := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, s.Addr())
+		}}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ips, err := resolver.LookupIP(ctx, "ip4", "lib.smu.edu.cn")
+		cancel()
+		if err != nil {
+			t.Fatalf("resolving by %s: %v", network, err)
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.1.2.3")) {
+			t.Errorf("resolving by %s: got %v", network, ips)
+		}
+	}
+
+	s.Close()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,172 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// types of resource records used by the server.
+const (
+	TypeA    uint16 = 1
+	TypeAAAA uint16 = 28
+)
+
+// response codes.
+const (
+	RcodeSuccess        = 0
+	RcodeServerFailure  = 2
+	RcodeNameError      = 3
+	RcodeNotImplemented = 4
+)
+
+const (
+	headerLen = 12
+	classIN   = 1
+	maxUDPLen = 512 // max length of udp message without EDNS
+)
+
+var errBadMessage = errors.New("bad dns message")
+
+// question is the (only) question in a query.
+type question struct {
+	name   string // lower case, without the trailing dot
+	qtype  uint16
+	qclass uint16
+	end    int // offset of the end of question section
+}
+
+// parseQuestion parses the header and question of msg, only one question is supported.
+func parseQuestion(msg []byte) (question, error) {
+	if len(msg) < headerLen {
+		return question{}, errBadMessage
+	}
+	if binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return question{}, errors.New("only one question is supported")
+	}
+	name, off, err := readName(msg, headerLen)
+	if err != nil {
+		return question{}, err
+	}
+	if off+4 > len(msg) {
+		return question{}, errBadMessage
+	}
+	return question{
+		name:   name,
+		qtype:  binary.BigEndian.Uint16(msg[off:]),
+		qclass: binary.BigEndian.Uint16(msg[off+2:]),
+		end:    off + 4,
+	}, nil
+}
+
+// readName reads the domain name at off (compression pointers are followed),
+// it returns the name in lower case without the trailing dot, and the offset after the name.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errBadMessage
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), end, nil
+		case l&0xC0 == 0xC0: // pointer
+			if off+1 >= len(msg) || jumps > 16 {
+				return "", 0, errBadMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			jumps++
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		case l&0xC0 != 0:
+			return "", 0, errBadMessage
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errBadMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// rcode returns the response code of msg.
+func rcode(msg []byte) int {
+	return int(msg[3] & 0x0F)
+}
+
+// minTTL returns the min ttl of records in answer and authority sections,
+// ok is false if there are no records.
+func minTTL(msg []byte) (ttl uint32, ok bool, err error) {
+	q, err := parseQuestion(msg)
+	if err != nil {
+		return 0, false, err
+	}
+	count := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:]))
+	off := q.end
+	for i := 0; i < count; i++ {
+		if _, off, err = readName(msg, off); err != nil {
+			return 0, false, err
+		}
+		if off+10 > len(msg) {
+			return 0, false, errBadMessage
+		}
+		rrTTL := binary.BigEndian.Uint32(msg[off+4:])
+		off += 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+		if off > len(msg) {
+			return 0, false, errBadMessage
+		}
+		if !ok || rrTTL < ttl {
+			ttl, ok = rrTTL, true
+		}
+	}
+	return ttl, ok, nil
+}
+
+// newResponse builds the response of query (with question q) with records in answer section.
+// The records must use a pointer to the question name.
+func newResponse(query []byte, q question, code int, answers ...[]byte) []byte {
+	resp := make([]byte, q.end, q.end+len(answers)*28)
+	copy(resp, query[:q.end])
+	resp[2] = 0x80 | (query[2] & 0x79) // QR, and opcode and RD of query
+	resp[3] = 0x80 | byte(code)        // RA
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(resp[8:], 0)
+	binary.BigEndian.PutUint16(resp[10:], 0)
+	for _, a := range answers {
+		resp = append(resp, a...)
+	}
+	return resp
+}
+
+// addressRecord returns an A or AAAA record of the question name.
+func addressRecord(ip net.IP, ttl uint32) []byte {
+	rtype, data := TypeA, ip.To4()
+	if data == nil {
+		rtype, data = TypeAAAA, ip.To16()
+	}
+	rr := []byte{0xC0, headerLen} // pointer to the question name
+	rr = binary.BigEndian.AppendUint16(rr, rtype)
+	rr = binary.BigEndian.AppendUint16(rr, classIN)
+	rr = binary.BigEndian.AppendUint32(rr, ttl)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(data)))
+	return append(rr, data...)
+}
+
+// truncate returns the response with only header and question, and the TC bit set,
+// if resp is too long for a udp reply of query without EDNS. The client retries by tcp then.
+func truncate(query, resp []byte, q question) []byte {
+	if len(resp) <= maxUDPLen || binary.BigEndian.Uint16(query[10:]) != 0 {
+		return resp
+	}
+	t := newResponse(query, q, rcode(resp))
+	t[2] |= 0x02
+	return t
+}
@@ -0,0 +1,356 @@
+// Package dns provides a local dns server for resolving internal names over the tunnel:
+// queries of internal zones are forwarded to a campus resolver by dns over tcp through the tunnel,
+// and other queries are sent to the system resolver (or a fallback resolver).
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCacheSize is the default number of cached responses.
+const DefaultCacheSize = 1024
+
+// queryTimeout is the timeout of resolving a query.
+const queryTimeout = 5 * time.Second
+
+var ErrNotLoopback = errors.New("dns server can only listen on loopback address")
+
+// Options are the options of dns server.
+type Options struct {
+	Addr      string   // loopback address for listening on both udp and tcp, e.g. 127.0.0.1:5353
+	Zones     []string // internal zones resolved over the tunnel, e.g. smu.edu.cn
+	Upstream  string   // the resolver (host:port, default port is 53) for internal zones, connected through the tunnel
+	Fallback  string   // the resolver (host:port) for other names by udp, the system resolver is used if it is empty
+	CacheSize int      // max cached responses, DefaultCacheSize if it is 0, negative to disable caching
+}
+
+// Dialer connects to addr through the tunnel.
+type Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+// Server is a local dns server.
+type Server struct {
+	options Options
+	dial    Dialer
+	cache   *cache
+	udp     net.PacketConn
+	tcp     net.Listener
+	wg      sync.WaitGroup
+}
+
+// ParseZones splits zones separated by commas or whitespace.
+func ParseZones(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// Validate checks the options without listening.
+func (o Options) Validate() error {
+	host, _, err := net.SplitHostPort(o.Addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return ErrNotLoopback
+	}
+	if len(o.Zones) != 0 && o.Upstream == "" {
+		return errors.New("upstream resolver of internal zones is required")
+	}
+	if _, err := withDefaultPort(o.Upstream); err != nil {
+		return fmt.Errorf("bad upstream resolver: %w", err)
+	}
+	if _, err := withDefaultPort(o.Fallback); err != nil {
+		return fmt.Errorf("bad fallback resolver: %w", err)
+	}
+	return nil
+}
+
+// Listen listens on options.Addr, the queries of internal zones are sent by dial.
+func Listen(options Options, dial Dialer) (*Server, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	options.Upstream, _ = withDefaultPort(options.Upstream)
+	options.Fallback, _ = withDefaultPort(options.Fallback)
+	zones := make([]string, 0, len(options.Zones))
+	for _, zone := range options.Zones {
+		zones = append(zones, strings.Trim(strings.ToLower(zone), "."))
+	}
+	options.Zones = zones
+	if options.CacheSize == 0 {
+		options.CacheSize = DefaultCacheSize
+	}
+
+	s := &Server{options: options, dial: dial}
+	if options.CacheSize > 0 {
+		s.cache = newCache(options.CacheSize)
+	}
+	var err error
+	if s.udp, err = net.ListenPacket("udp", options.Addr); err != nil {
+		return nil, err
+	}
+	// listen on the same port for tcp, the port of udp is used if it is chosen by system.
+	if s.tcp, err = net.Listen("tcp", s.udp.LocalAddr().String()); err != nil {
+		s.udp.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// withDefaultPort adds port 53 to addr if it has no port.
+func withDefaultPort(addr string) (string, error) {
+	if addr == "" {
+		return "", nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr, nil
+	}
+	if strings.Contains(strings.Trim(addr, "[]"), ":") && net.ParseIP(strings.Trim(addr, "[]")) == nil {
+		return "", fmt.Errorf("bad address `%s`", addr)
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), "53"), nil
+}
+
+// Addr returns the listening address.
+func (s *Server) Addr() string {
+	return s.udp.LocalAddr().String()
+}
+
+// Internal returns true if name is in the internal zones, see MatchZone.
+func (s *Server) Internal(name string) bool {
+	return MatchZone(s.options.Zones, name) != ""
+}
+
+// MatchZone returns the zone containing name, or empty if name is not in the zones.
+func MatchZone(zones []string, name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, zone := range zones {
+		zone = strings.Trim(strings.ToLower(zone), ".")
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return zone
+		}
+	}
+	return ""
+}
+
+// Serve serves the queries by udp and tcp until Close is called.
+func (s *Server) Serve() error {
+	errs := make(chan error, 2)
+	go func() { errs <- s.serveUDP() }()
+	go func() { errs <- s.serveTCP() }()
+	err := <-errs
+	s.Close()
+	if err2 := <-errs; err == nil {
+		err = err2
+	}
+	s.wg.Wait()
+	return err
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	s.tcp.Close()
+	return s.udp.Close()
+}
+
+func (s *Server) serveUDP() error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		query := append([]byte(nil), buf[:n]...)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			resp, q, err := s.exchange(query)
+			if err != nil {
+				log.WithError(err).Debug("bad dns query.")
+				return
+			}
+			s.udp.WriteTo(truncate(query, resp, q), addr)
+		}()
+	}
+}
+
+func (s *Server) serveTCP() error {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			for {
+				conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+				query, err := readTCP(conn)
+				if err != nil {
+					return
+				}
+				resp, _, err := s.exchange(query)
+				if err != nil {
+					log.WithError(err).Debug("bad dns query.")
+					return
+				}
+				if err := writeTCP(conn, resp); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Exchange resolves the query and returns the response.
+// A response with SERVFAIL is returned if the query can not be resolved.
+func (s *Server) Exchange(query []byte) ([]byte, error) {
+	resp, _, err := s.exchange(query)
+	return resp, err
+}
+
+func (s *Server) exchange(query []byte) ([]byte, question, error) {
+	q, err := parseQuestion(query)
+	if err != nil {
+		return nil, q, err
+	}
+	now := time.Now()
+	if s.cache != nil {
+		if resp := s.cache.get(q, query, now); resp != nil {
+			return resp, q, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	var resp []byte
+	internal := s.Internal(q.name)
+	switch {
+	case internal:
+		resp, err = s.exchangeTunnel(ctx, query)
+	case s.options.Fallback != "":
+		resp, err = exchangeUDP(ctx, s.options.Fallback, query)
+	default:
+		resp, err = resolveSystem(ctx, query, q)
+	}
+	if err == nil {
+		// the response must answer the question.
+		var rq question
+		if rq, err = parseQuestion(resp); err == nil && (rq.name != q.name || rq.qtype != q.qtype) {
+			err = errors.New("response does not match the query")
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithField("name", q.name).WithField("internal", internal).Warning("failed to resolve.")
+		return newResponse(query, q, RcodeServerFailure), q, nil
+	}
+	if s.cache != nil {
+		s.cache.put(q, resp, now)
+	}
+	return resp, q, nil
+}
+
+// exchangeTunnel sends query to the upstream resolver by dns over tcp through the tunnel.
+func (s *Server) exchangeTunnel(ctx context.Context, query []byte) ([]byte, error) {
+	conn, err := s.dial(ctx, s.options.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := writeTCP(conn, query); err != nil {
+		return nil, err
+	}
+	return readTCP(conn)
+}
+
+// exchangeUDP sends query to the resolver at addr by udp.
+func exchangeUDP(ctx context.Context, addr string, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// ignore responses of other queries.
+		if n >= headerLen && buf[0] == query[0] && buf[1] == query[1] {
+			return buf[:n], nil
+		}
+	}
+}
+
+// resolveSystem resolves A and AAAA queries by the system resolver, other types are not supported.
+func resolveSystem(ctx context.Context, query []byte, q question) ([]byte, error) {
+	network := "ip4"
+	switch q.qtype {
+	case TypeA:
+	case TypeAAAA:
+		network = "ip6"
+	default:
+		return newResponse(query, q, RcodeNotImplemented), nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, q.name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return newResponse(query, q, RcodeNameError), nil
+		}
+		return nil, err
+	}
+	answers := make([][]byte, 0, len(ips))
+	for _, ip := range ips {
+		answers = append(answers, addressRecord(ip, 60))
+	}
+	return newResponse(query, q, RcodeSuccess, answers...), nil
+}
+
+// readTCP reads a message with 2 bytes length prefix.
+func readTCP(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCP writes a message with 2 bytes length prefix.
+func writeTCP(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
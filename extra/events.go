@@ -0,0 +1,74 @@
+package extra
+
+import (
+	"errors"
+	"sync"
+)
+
+// Phase is the phase of the connection lifecycle.
+type Phase string
+
+const (
+	PhaseConnecting   Phase = "connecting"   // the client is starting
+	PhaseLoggingIn    Phase = "logging_in"   // logging in vpn
+	PhaseCaptcha      Phase = "captcha"      // captcha is needed for vpn login
+	PhaseNegotiating  Phase = "negotiating"  // negotiating version with server
+	PhaseConnected    Phase = "connected"    // the client is running
+	PhaseReconnecting Phase = "reconnecting" // the connection is lost and reconnecting
+	PhaseDisconnected Phase = "disconnected" // the connection is lost (not stopped by user), Err is the reason
+	PhaseStopped      Phase = "stopped"      // the client is stopped, Err is set if it is stopped by an error
+)
+
+// ErrConnectionClosed is the reason of PhaseDisconnected if the connection is closed without error.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// Event is a change of connection lifecycle.
+type Event struct {
+	Phase Phase
+	Err   error
+}
+
+// eventSubscribers keeps the callbacks subscribing events of TaskHandles.
+type eventSubscribers struct {
+	lock   sync.Mutex
+	nextId int
+	subs   map[int]func(Event)
+}
+
+// Subscribe registers the callback of lifecycle events.
+// The callback is called synchronously in the goroutine where the event happens (not the ui thread),
+// so it should not block. It returns a function to unsubscribe.
+func (h *TaskHandles) Subscribe(callback func(Event)) (unsubscribe func()) {
+	h.events.lock.Lock()
+	defer h.events.lock.Unlock()
+	if h.events.subs == nil {
+		h.events.subs = make(map[int]func(Event))
+	}
+	id := h.events.nextId
+	h.events.nextId++
+	h.events.subs[id] = callback
+	return func() {
+		h.events.lock.Lock()
+		defer h.events.lock.Unlock()
+		delete(h.events.subs, id)
+	}
+}
+
+func (h *TaskHandles) emit(phase Phase, err error) {
+	h.phase.Store(phase)
+	h.stats.onPhase(phase)
+	if phase == PhaseReconnecting {
+		h.stats.reconnects.Add(1)
+	}
+	h.events.lock.Lock()
+	subs := make([]func(Event), 0, len(h.events.subs))
+	for _, sub := range h.events.subs {
+		subs = append(subs, sub)
+	}
+	h.events.lock.Unlock()
+
+	e := Event{Phase: phase, Err: err}
+	for _, sub := range subs {
+		sub(e)
+	}
+}
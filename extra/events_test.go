@@ -0,0 +1,40 @@
+package extra
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	var h TaskHandles
+	var first, second []Event
+	unsubscribe := h.Subscribe(func(e Event) { first = append(first, e) })
+	var unsubscribeSelf func()
+	unsubscribeSelf = h.Subscribe(func(e Event) {
+		second = append(second, e)
+		// the subscribers can unsubscribe in the callback.
+		if e.Phase == PhaseDisconnected {
+			unsubscribeSelf()
+		}
+	})
+
+	h.emit(PhaseConnecting, nil)
+	h.emit(PhaseConnected, nil)
+	if h.Phase() != PhaseConnected {
+		t.Error("unexpected phase", h.Phase())
+	}
+	lost := errors.New("connection reset")
+	h.emit(PhaseDisconnected, lost)
+	unsubscribe()
+	h.emit(PhaseReconnecting, nil)
+
+	if len(first) != 3 || first[0].Phase != PhaseConnecting || first[2].Phase != PhaseDisconnected || first[2].Err != lost {
+		t.Errorf("unexpected events: %+v", first)
+	}
+	if len(second) != 3 {
+		t.Errorf("events after unsubscribing should not be received: %+v", second)
+	}
+	if h.Phase() != PhaseReconnecting || h.Stats().Reconnects != 1 {
+		t.Errorf("reconnecting should be counted: %v %d", h.Phase(), h.Stats().Reconnects)
+	}
+}
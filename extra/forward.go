@@ -0,0 +1,146 @@
+package extra
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/genshen/wssocks/wss"
+	log "github.com/sirupsen/logrus"
+)
+
+// Forward is a local tcp port forwarding (like ssh -L): the connections accepted on Local
+// are connected to Remote through the tunnel.
+type Forward struct {
+	Local  string // local listening address, the host is 127.0.0.1 if it is empty, e.g. 127.0.0.1:15432
+	Remote string // target address (host:port) connected by wssocks server, e.g. db.internal:5432
+}
+
+func (f Forward) String() string {
+	return f.Local + "=" + f.Remote
+}
+
+// Validate checks the addresses of f.
+func (f Forward) Validate() error {
+	if _, port, err := net.SplitHostPort(f.Local); err != nil || port == "" {
+		return fmt.Errorf("bad local address `%s` of forwarding", f.Local)
+	}
+	if host, port, err := net.SplitHostPort(f.Remote); err != nil || host == "" || port == "" {
+		return fmt.Errorf("bad remote address `%s` of forwarding", f.Remote)
+	}
+	return nil
+}
+
+// listenAddr returns the local address for listening, the host is loopback if it is empty.
+func (f Forward) listenAddr() string {
+	host, port, _ := net.SplitHostPort(f.Local)
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// ParseForward parses a forwarding in the form of `local=remote` (or `local->remote`),
+// e.g. 127.0.0.1:15432=db.internal:5432.
+func ParseForward(s string) (Forward, error) {
+	sep := "="
+	if strings.Contains(s, "->") {
+		sep = "->"
+	}
+	local, remote, ok := strings.Cut(s, sep)
+	if !ok {
+		return Forward{}, fmt.Errorf("bad forwarding `%s`, local=remote is required", s)
+	}
+	f := Forward{Local: strings.TrimSpace(local), Remote: strings.TrimSpace(remote)}
+	return f, f.Validate()
+}
+
+// ParseForwards parses forwardings separated by commas or new lines.
+func ParseForwards(s string) ([]Forward, error) {
+	var forwards []Forward
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		f, err := ParseForward(item)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, f)
+	}
+	return forwards, nil
+}
+
+// startForwards listens on the local addresses of forwards, and serves them until the connection is closed.
+// The clients are checked by sharing (if it is not nil).
+// If any of them can not be listened, the client is stopped with the error.
+func (h *TaskHandles) startForwards(forwards []Forward, sharing *Sharing, wsc *wss.WebSocketClient, record *wss.ConnRecord) {
+	for _, f := range forwards {
+		f := f
+		l, err := listen(f.listenAddr(), sharing, &h.stats)
+		if err != nil {
+			h.eg.Go(func() error {
+				h.once.Do(h.closeAll)
+				return fmt.Errorf("start forwarding %s error %w", f, err)
+			})
+			return
+		}
+		h.forwardListeners = append(h.forwardListeners, l)
+		log.WithField("local", l.Addr().String()).WithField("remote", f.Remote).
+			Info("listening on local address for port forwarding.")
+		h.eg.Go(func() error {
+			defer h.once.Do(h.closeAll)
+			return h.serveForward(l, f.Remote, wsc, record)
+		})
+	}
+}
+
+// serveForward accepts connections on l and connects them to remote through the tunnel.
+func (h *TaskHandles) serveForward(l net.Listener, remote string, wsc *wss.WebSocketClient, record *wss.ConnRecord) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if h.closedByUser() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("tcp accept error: %w", err)
+		}
+		conn := newCountingConn(c, &h.stats)
+		conn.address.Store(remote)
+		go func() {
+			defer conn.Close()
+			record.Update(wss.ConnStatus{IsNew: true, Address: remote, Type: wss.ProxyTypeSocks5})
+			defer record.Update(wss.ConnStatus{IsNew: false, Address: remote, Type: wss.ProxyTypeSocks5})
+			// the forwarded connection is established as a socks5 proxy, whose reply is not for the local client.
+			fc := &skipWriteConn{Conn: conn, skip: len(socks5Succeeded)}
+			if err := transData(wsc, fc, nil, wss.ProxyTypeSocks5, remote); err != nil {
+				log.WithField("address", remote).WithError(err).Debug("forwarding error.")
+			}
+		}()
+	}
+}
+
+// skipWriteConn drops the first skip bytes written to it.
+type skipWriteConn struct {
+	net.Conn
+	skip int
+}
+
+func (c *skipWriteConn) Write(b []byte) (int, error) {
+	n := len(b)
+	if c.skip > 0 {
+		skipped := c.skip
+		if skipped > n {
+			skipped = n
+		}
+		c.skip -= skipped
+		if b = b[skipped:]; len(b) == 0 {
+			return n, nil
+		}
+	}
+	if _, err := c.Conn.Write(b); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,127 @@
+package extra
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/genshen/wssocks/wss"
+)
+
+// startTestClient starts a wssocks server, and the client connected to it with options.
+// The client and the server are stopped when the test finishes.
+func startTestClient(t *testing.T, options Options) *TaskHandles {
+	server := httptest.NewServer(wss.NewServeWS(wss.NewHubCollection(), wss.WebsocksServerConfig{EnableHttp: true}))
+	t.Cleanup(server.Close)
+	options.RemoteAddr = "ws://" + server.Listener.Addr().String()
+	if options.LocalSocks5Addr == "" {
+		options.LocalSocks5Addr = "127.0.0.1:0"
+	}
+	h := &TaskHandles{}
+	if err := h.StartWssocks(options); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.NotifyCloseWrapper()
+		h.Wait()
+	})
+	return h
+}
+
+// startEchoServer starts a tcp server writing back what it reads, and returns its address.
+func startEchoServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// echo writes message to c, and checks that it is written back.
+func echo(t *testing.T, c net.Conn, message string) {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write([]byte(message)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(message))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != message {
+		t.Errorf("got %q, want %q", buf, message)
+	}
+}
+
+func TestForward(t *testing.T) {
+	remote := startEchoServer(t)
+	h := startTestClient(t, Options{Forwards: []Forward{{Local: "127.0.0.1:0", Remote: remote}}})
+	if len(h.forwardListeners) != 1 {
+		t.Fatal("the forwarding is not listened")
+	}
+
+	c, err := net.Dial("tcp", h.forwardListeners[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// the reply of socks5 connecting is not written to the local connection.
+	echo(t, c, "ping")
+	echo(t, c, "pong")
+
+	if dest := h.Stats().Destinations[remote]; dest.TotalConnections != 1 || dest.ActiveConnections != 1 {
+		t.Errorf("the forwarded connection is not counted: %+v", dest)
+	}
+}
+
+func TestParseForwards(t *testing.T) {
+	forwards, err := ParseForwards("127.0.0.1:15432=db.internal:5432, :2222->lab.smu.edu.cn:22\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forwards) != 2 || forwards[0] != (Forward{Local: "127.0.0.1:15432", Remote: "db.internal:5432"}) ||
+		forwards[1] != (Forward{Local: ":2222", Remote: "lab.smu.edu.cn:22"}) {
+		t.Errorf("unexpected forwardings: %+v", forwards)
+	}
+	if addr := forwards[1].listenAddr(); addr != "127.0.0.1:2222" {
+		t.Error("the local address without host should be on loopback, got", addr)
+	}
+
+	for _, bad := range []string{"127.0.0.1:15432", "15432=db.internal:5432", ":2222=lab.smu.edu.cn", ":2222=:22"} {
+		if _, err := ParseForward(bad); err == nil {
+			t.Errorf("expected error of `%s`", bad)
+		}
+	}
+}
+
+func TestSkipWriteConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &skipWriteConn{Conn: server, skip: 4}
+	go func() {
+		c.Write([]byte("ab"))
+		c.Write([]byte("cdef"))
+		c.Write([]byte("gh"))
+		server.Close()
+	}()
+	got, _ := io.ReadAll(client)
+	if !bytes.Equal(got, []byte("efgh")) {
+		t.Errorf("got %q, want %q", got, "efgh")
+	}
+}
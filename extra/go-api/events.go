@@ -0,0 +1,246 @@
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef void (*wssocks_event_callback)(uintptr_t handle, const char *event);
+
+static inline void call_event_callback(wssocks_event_callback cb, uintptr_t handle, const char *event) {
+	cb(handle, event);
+}
+*/
+import "C"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/qrcode"
+	log "github.com/sirupsen/logrus"
+)
+
+// event types passed to the C callback
+const (
+	EventState   = "state"   // the client state is changed, the state is one of extra.Phase* values
+	EventCaptcha = "captcha" // a captcha is required, reply with the captcha text
+	EventQrCode  = "qrcode"  // a qr code should be shown, reply (with any text) after it is scanned
+	EventError   = "error"   // an error happened
+	EventStats   = "stats"   // traffic statistics of the client
+)
+
+// Event is passed to the C callback as json.
+type Event struct {
+	Type    string `json:"type"`
+	State   string `json:"state,omitempty"`
+	Image   string `json:"image,omitempty"`   // base64 image of captcha
+	Content string `json:"content,omitempty"` // content of qr code
+	Error   string `json:"error,omitempty"`   // error of EventError, or the reason of disconnected/stopped state
+	// ReplyId is set if the event waits for a reply, which is sent by SendReply.
+	ReplyId int64  `json:"reply_id,omitempty"`
+	Stats   *Stats `json:"stats,omitempty"`
+}
+
+// Stats is the traffic statistics in EventStats.
+type Stats struct {
+	BytesUp           uint64                      `json:"bytes_up"`
+	BytesDown         uint64                      `json:"bytes_down"`
+	ActiveConnections uint64                      `json:"active_connections"`
+	TotalConnections  uint64                      `json:"total_connections"`
+	Reconnects        uint64                      `json:"reconnects"`
+	Since             int64                       `json:"since"` // unix time when the client is started
+	Destinations      map[string]DestinationStats `json:"destinations,omitempty"`
+}
+
+type DestinationStats struct {
+	ActiveConnections uint64 `json:"active_connections"`
+	TotalConnections  uint64 `json:"total_connections"`
+	BytesUp           uint64 `json:"bytes_up"`
+	BytesDown         uint64 `json:"bytes_down"`
+}
+
+// statsInterval is the interval of EventStats when the client is connected.
+const statsInterval = 2 * time.Second
+
+func newStats(s extra.Stats, withDestinations bool) *Stats {
+	stats := &Stats{
+		BytesUp:           s.BytesUp,
+		BytesDown:         s.BytesDown,
+		ActiveConnections: s.ActiveConnections,
+		TotalConnections:  s.TotalConnections,
+		Reconnects:        s.Reconnects,
+		Since:             s.Since.Unix(),
+	}
+	if withDestinations {
+		stats.Destinations = make(map[string]DestinationStats, len(s.Destinations))
+		for host, d := range s.Destinations {
+			stats.Destinations[host] = DestinationStats(d)
+		}
+	}
+	return stats
+}
+
+// emitStats emits EventStats of handle periodically, until stop is closed.
+func emitStats(handle uintptr, hd *extra.TaskHandles, stop <-chan struct{}) {
+	t := time.NewTicker(statsInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			emitEvent(handle, Event{Type: EventStats, Stats: newStats(hd.Stats(), false)})
+		}
+	}
+}
+
+var (
+	ErrReplyCanceled   = errors.New("waiting reply is canceled")
+	ErrReplyTimeout    = errors.New("waiting reply is timed out")
+	ErrNoEventCallback = errors.New("event callback is not registered")
+)
+
+// replyTimeout is the max time of waiting for a reply, e.g. the captcha is never answered.
+const replyTimeout = 5 * time.Minute
+
+var eventCallback C.wssocks_event_callback
+var eventCallbackLock sync.RWMutex
+
+func setEventCallback(cb C.wssocks_event_callback) {
+	eventCallbackLock.Lock()
+	defer eventCallbackLock.Unlock()
+	eventCallback = cb
+}
+
+func getEventCallback() C.wssocks_event_callback {
+	eventCallbackLock.RLock()
+	defer eventCallbackLock.RUnlock()
+	return eventCallback
+}
+
+// emitEvent passes the event of handle to the C callback (if it is registered).
+// The callback is called synchronously, and the event string is freed after it returns.
+// The lock is not held while calling it, so the callback can call SetEventCallback.
+func emitEvent(handle uintptr, e Event) {
+	cb := getEventCallback()
+	if cb == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.WithError(err).Error("marshal event failed")
+		return
+	}
+	cs := C.CString(string(data))
+	defer C.free(unsafe.Pointer(cs))
+	C.call_event_callback(cb, C.uintptr_t(handle), cs)
+}
+
+func emitError(handle uintptr, err error) {
+	emitEvent(handle, Event{Type: EventError, Error: err.Error()})
+}
+
+// pendingReply is an event waiting for reply.
+type pendingReply struct {
+	handle uintptr
+	reply  chan string
+}
+
+var pendingReplies = make(map[int64]*pendingReply)
+var lastReplyId int64
+var repliesLock sync.Mutex
+
+// emitAndWait emits the event with a new reply id, and waits for the reply sent by sendReply.
+// It fails if no callback is registered (nobody can see the event to reply), or the reply is not sent in replyTimeout.
+// The waiting is canceled by cancelReplies if the client is stopped.
+func emitAndWait(handle uintptr, e Event) (string, error) {
+	if getEventCallback() == nil {
+		return "", ErrNoEventCallback
+	}
+	repliesLock.Lock()
+	lastReplyId++
+	e.ReplyId = lastReplyId
+	pending := &pendingReply{handle: handle, reply: make(chan string, 1)}
+	pendingReplies[e.ReplyId] = pending
+	repliesLock.Unlock()
+
+	emitEvent(handle, e)
+	timer := time.NewTimer(replyTimeout)
+	defer timer.Stop()
+	select {
+	case reply, ok := <-pending.reply:
+		if !ok {
+			return "", ErrReplyCanceled
+		}
+		return reply, nil
+	case <-timer.C:
+		repliesLock.Lock()
+		delete(pendingReplies, e.ReplyId)
+		repliesLock.Unlock()
+		return "", ErrReplyTimeout
+	}
+}
+
+// sendReply passes the reply to the event waiting for it.
+func sendReply(replyId int64, reply string) error {
+	repliesLock.Lock()
+	defer repliesLock.Unlock()
+	pending, ok := pendingReplies[replyId]
+	if !ok {
+		return errors.New("no event is waiting for this reply")
+	}
+	delete(pendingReplies, replyId)
+	pending.reply <- reply
+	close(pending.reply)
+	return nil
+}
+
+// cancelReplies cancels all events of handle waiting for reply.
+func cancelReplies(handle uintptr) {
+	repliesLock.Lock()
+	defer repliesLock.Unlock()
+	for id, pending := range pendingReplies {
+		if pending.handle == handle {
+			delete(pendingReplies, id)
+			close(pending.reply)
+		}
+	}
+}
+
+// captchaHandler asks the captcha of the image by EventCaptcha.
+func captchaHandler(handle uintptr) func(imgData []byte) (string, error) {
+	return func(imgData []byte) (string, error) {
+		reply, err := emitAndWait(handle, Event{Type: EventCaptcha, Image: base64.StdEncoding.EncodeToString(imgData)})
+		if err != nil {
+			return "", err
+		}
+		if reply == "" {
+			return "", errors.New("captcha is not provided")
+		}
+		return reply, nil
+	}
+}
+
+// eventQrCodeAuth passes the qr code content by EventQrCode, and waits for the reply after it is scanned.
+type eventQrCodeAuth struct {
+	handle uintptr
+}
+
+func (q *eventQrCodeAuth) ShowQrCodeAndWait(client *http.Client, cookies []*http.Cookie, qr qrcode.QrImg) ([]*http.Cookie, error) {
+	if _, err := emitAndWait(q.handle, Event{Type: EventQrCode, Content: qr.GenQrCodeContent()}); err != nil {
+		return nil, err
+	}
+	authCode, err := qrcode.WaitQrState(qr.Sid)
+	if err != nil {
+		return nil, err
+	}
+	if err := qrcode.RedirectToLogin(client, cookies, qr.Config.AppID, authCode, qr.Config.RandToken); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
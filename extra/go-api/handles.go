@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+)
+
+var ErrUnknownHandle = errors.New("unknown client handle")
+
+// handles registry: the C side only keeps opaque ids of handles,
+// and the handles are kept here so they would not be destroyed by garbage collection.
+var handleInstances = make(map[uintptr]*extra.TaskHandles)
+var lastHandleId uintptr
+var handlesLock sync.Mutex
+
+func newHandles() uintptr {
+	handlesLock.Lock()
+	defer handlesLock.Unlock()
+	lastHandleId++
+	id := lastHandleId
+	hd := new(extra.TaskHandles)
+	// pass lifecycle events to the C callback, and emit statistics when the client is connected.
+	// the events are emitted by the goroutines starting and waiting the client.
+	var statsStop chan struct{}
+	var statsLock sync.Mutex
+	hd.Subscribe(func(e extra.Event) {
+		event := Event{Type: EventState, State: string(e.Phase)}
+		if e.Err != nil {
+			event.Error = e.Err.Error()
+		}
+		emitEvent(id, event)
+		statsLock.Lock()
+		defer statsLock.Unlock()
+		switch e.Phase {
+		case extra.PhaseConnected:
+			if statsStop == nil {
+				statsStop = make(chan struct{})
+				go emitStats(id, hd, statsStop)
+			}
+		case extra.PhaseStopped:
+			if statsStop != nil {
+				close(statsStop)
+				statsStop = nil
+			}
+		}
+	})
+	handleInstances[id] = hd
+	return id
+}
+
+// getHandles returns the handles of id, or ErrUnknownHandle if the id is not created or already destroyed.
+func getHandles(id uintptr) (*extra.TaskHandles, error) {
+	handlesLock.Lock()
+	defer handlesLock.Unlock()
+	if hd, ok := handleInstances[id]; ok {
+		return hd, nil
+	}
+	return nil, ErrUnknownHandle
+}
+
+// removeHandles removes the handles of id from registry and returns it.
+func removeHandles(id uintptr) (*extra.TaskHandles, error) {
+	handlesLock.Lock()
+	defer handlesLock.Unlock()
+	hd, ok := handleInstances[id]
+	if !ok {
+		return nil, ErrUnknownHandle
+	}
+	delete(handleInstances, id)
+	return hd, nil
+}
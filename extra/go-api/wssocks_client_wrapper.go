@@ -1,30 +1,72 @@
 package main
 
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+// error codes returned by functions returning int
+#define WSSOCKS_OK 0
+#define WSSOCKS_ERR_UNKNOWN_HANDLE -1
+
+// callback receiving events (in json) of all clients, see SetEventCallback.
+// It is called on the goroutines of clients, and it may call SetEventCallback or SendReply.
+typedef void (*wssocks_event_callback)(uintptr_t handle, const char *event);
+
+// StartClientWrapper and StartClientJSON block until the client is connected or fails,
+// including waiting for the captcha or qr code reply sent by SendReply (after the event is received by the callback).
+// The waiting fails if no callback is registered, or the reply is not sent in 5 minutes.
+// They must not be called on the UI thread, or the thread sending the reply.
+*/
 import "C"
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/config"
 	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
 	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/passwd"
 	"github.com/genshen/wssocks/client"
 	"unsafe"
 )
 
-// so it would not be destroyed by garbage collection
-var handleInstances map[uintptr]*extra.TaskHandles
-
+// NewClientHandles creates a client handle, and returns its opaque id.
+// The handle must be destroyed by DestroyClientHandles after it is not used.
+// Strings returned by the functions below must be freed by FreeCString.
+//
 //export NewClientHandles
 func NewClientHandles() uintptr {
-	hd := new(extra.TaskHandles)
-	ptr := uintptr(unsafe.Pointer(hd))
-	if handleInstances == nil {
-		handleInstances = make(map[uintptr]*extra.TaskHandles)
+	return newHandles()
+}
+
+// DestroyClientHandles stops the client (if it is running) and removes the handle.
+// It returns WSSOCKS_ERR_UNKNOWN_HANDLE if the handle is not created or already destroyed.
+//
+//export DestroyClientHandles
+func DestroyClientHandles(handle uintptr) C.int {
+	hd, err := removeHandles(handle)
+	if err != nil {
+		return C.WSSOCKS_ERR_UNKNOWN_HANDLE
 	}
-	handleInstances[ptr] = hd
-	return ptr
+	cancelReplies(handle)
+	hd.NotifyCloseWrapper()
+	return C.WSSOCKS_OK
+}
+
+// FreeCString frees the string returned by other functions.
+//
+//export FreeCString
+func FreeCString(s *C.char) {
+	C.free(unsafe.Pointer(s))
 }
 
+// StartClientWrapper starts the client with password login of vpn.
+// If the callback is registered by SetEventCallback, the captcha is asked by EventCaptcha, and like StartClientJSON,
+// it blocks until the captcha reply is sent by SendReply, so it must not be called on the UI thread.
+// Otherwise, the captcha is asked in the default way of vpn login, as before the callback is supported.
+//
 //export StartClientWrapper
-func StartClientWrapper(handlesPtr uintptr, localAddr, remoteAddr, httpLocalAddr *C.char,
+func StartClientWrapper(handle uintptr, localAddr, remoteAddr, httpLocalAddr *C.char,
 	httpEnable, skipTSLVerify, vpnEnable, vpnForceLogout, vpnHostEncrypt C._Bool,
 	vpnHostInput, vpnUsername, vpnPassword *C.char) *C.char {
 	options := extra.Options{
@@ -47,26 +89,119 @@ func StartClientWrapper(handlesPtr uintptr, localAddr, remoteAddr, httpLocalAddr
 		},
 		RemoteAddr: C.GoString(remoteAddr),
 	}
-	var hp = (*extra.TaskHandles)(unsafe.Pointer(handlesPtr))
-	if err := hp.StartWssocks(options); err != nil {
+	if getEventCallback() != nil {
+		options.CaptchaHandler = captchaHandler(handle)
+	}
+	if err := startClient(handle, options); err != nil {
+		return C.CString(err.Error())
+	}
+	return C.CString("")
+}
+
+// StartClientJSON starts the client with options in json, which has the same keys as a profile in config file, e.g.
+// {"remote": "wss://proxy.example.com", "token": "xxx", "auto_reconnect": true, "vpn": {"enable": true, "auth_method": "qrcode"}}.
+// Captcha, qr code and state changes are passed to the callback registered by SetEventCallback.
+// It blocks until the client is connected or fails, including waiting for the captcha or qr code reply
+// sent by SendReply, so it must not be called on the UI thread (or any thread the reply is sent from).
+//
+//export StartClientJSON
+func StartClientJSON(handle uintptr, optionsJSON *C.char) *C.char {
+	var profile config.Profile
+	if err := json.Unmarshal([]byte(C.GoString(optionsJSON)), &profile); err != nil {
+		return C.CString(fmt.Sprintf("parse options: %s", err))
+	}
+	if err := profile.Validate(); err != nil {
+		return C.CString(err.Error())
+	}
+	// defaults, the same as cli client
+	options := extra.Options{
+		Options: client.Options{
+			LocalSocks5Addr: "127.0.0.1:1080",
+			LocalHttpAddr:   "127.0.0.1:1086",
+		},
+		UstbVpn: vpn.UstbVpn{
+			TargetVpn:   passwd.SMUVpnHost,
+			HostEncrypt: true,
+			AuthMethod:  vpn.VpnAuthMethodPasswd,
+		},
+	}
+	profile.Apply(&options)
+	options.CaptchaHandler = captchaHandler(handle)
+	options.QrCodeAuth = &eventQrCodeAuth{handle: handle}
+	if err := startClient(handle, options); err != nil {
+		return C.CString(err.Error())
+	}
+	return C.CString("")
+}
+
+// startClient starts the client, and emits EventError if it fails.
+// State changes are emitted by the event subscription of the handle.
+func startClient(handle uintptr, options extra.Options) error {
+	hd, err := getHandles(handle)
+	if err != nil {
+		return err
+	}
+	if err := hd.StartWssocks(options); err != nil {
+		emitError(handle, err)
+		return err
+	}
+	return nil
+}
+
+// SetEventCallback registers the callback receiving events (in json) of all clients.
+// The event string is only valid during the callback. Pass NULL to unregister it.
+//
+//export SetEventCallback
+func SetEventCallback(cb C.wssocks_event_callback) {
+	setEventCallback(cb)
+}
+
+// SendReply sends the reply (e.g. the captcha text) to the event of replyId.
+// An empty reply cancels the captcha input.
+//
+//export SendReply
+func SendReply(replyId C.longlong, reply *C.char) *C.char {
+	if err := sendReply(int64(replyId), C.GoString(reply)); err != nil {
 		return C.CString(err.Error())
 	}
 	return C.CString("")
 }
 
+// GetClientStats returns the statistics (in json, the same as stats in EventStats, with destinations) of the client.
+//
+//export GetClientStats
+func GetClientStats(handle uintptr) *C.char {
+	hd, err := getHandles(handle)
+	if err != nil {
+		return C.CString("")
+	}
+	data, err := json.Marshal(newStats(hd.Stats(), true))
+	if err != nil {
+		return C.CString("")
+	}
+	return C.CString(string(data))
+}
+
 //export WaitClientWrapper
-func WaitClientWrapper(handlesPtr uintptr) *C.char {
-	var hp = (*extra.TaskHandles)(unsafe.Pointer(handlesPtr))
-	if err := hp.Wait(); err != nil {
+func WaitClientWrapper(handle uintptr) *C.char {
+	hd, err := getHandles(handle)
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	if err := hd.Wait(); err != nil {
 		return C.CString(err.Error())
 	}
 	return C.CString("")
 }
 
 //export StopClientWrapper
-func StopClientWrapper(handlesPtr uintptr) *C.char {
-	var hp = (*extra.TaskHandles)(unsafe.Pointer(handlesPtr))
-	hp.NotifyCloseWrapper()
+func StopClientWrapper(handle uintptr) *C.char {
+	hd, err := getHandles(handle)
+	if err != nil {
+		return C.CString(err.Error())
+	}
+	cancelReplies(handle)
+	hd.NotifyCloseWrapper()
 	return C.CString("")
 }
 
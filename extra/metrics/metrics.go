@@ -0,0 +1,119 @@
+// Package metrics exposes the status and statistics of a client in Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/passwd"
+)
+
+// ContentType is the content type of Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+const namespace = "wssocks_ustb"
+
+// loginResults are always written, so that the series exist before the first login.
+var loginResults = []passwd.LoginResult{
+	passwd.LoginSuccess, passwd.LoginWrongPassword, passwd.LoginWrongCaptcha, passwd.LoginError,
+}
+
+// Handler serves the metrics of handles.
+func Handler(handles *extra.TaskHandles) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		Write(w, handles.Phase(), handles.Stats(), time.Now())
+	})
+}
+
+// Write writes the metrics of a client in phase with stats, now is the time for computing session age.
+func Write(w io.Writer, phase extra.Phase, stats extra.Stats, now time.Time) error {
+	m := writer{w: w}
+	up := 0.0
+	if phase == extra.PhaseConnected {
+		up = 1
+	}
+	m.metric("up", "gauge", "Whether the tunnel is connected (1) or not (0).", sample{value: up})
+
+	attempts := make([]sample, 0, len(loginResults))
+	for _, result := range loginResults {
+		attempts = append(attempts, sample{labels: `result="` + string(result) + `"`, value: float64(stats.LoginAttempts[result])})
+	}
+	m.metric("login_attempts_total", "counter", "VPN password login attempts by result.", attempts...)
+	m.metric("captcha_retries_total", "counter", "Login attempts with a new captcha after a wrong captcha.",
+		sample{value: float64(stats.CaptchaRetries)})
+
+	age := 0.0
+	if !stats.SessionSince.IsZero() {
+		age = now.Sub(stats.SessionSince).Seconds()
+	}
+	m.metric("session_age_seconds", "gauge", "Seconds since the current tunnel connection is established (0 if it is down).",
+		sample{value: age})
+	m.metric("reconnects_total", "counter", "Reconnecting times of the tunnel.", sample{value: float64(stats.Reconnects)})
+	m.metric("bytes_total", "counter", "Bytes transferred by local applications through the tunnel.",
+		sample{labels: `direction="up"`, value: float64(stats.BytesUp)},
+		sample{labels: `direction="down"`, value: float64(stats.BytesDown)})
+	m.metric("active_connections", "gauge", "Proxied connections which are active now.",
+		sample{value: float64(stats.ActiveConnections)})
+	m.metric("connections_total", "counter", "Proxied connections since the client is started.",
+		sample{value: float64(stats.TotalConnections)})
+	m.metric("destinations", "gauge", "Target hosts of proxied connections since the client is started.",
+		sample{value: float64(len(stats.Destinations))})
+
+	// the clients are only counted in sharing mode.
+	if len(stats.Clients) != 0 {
+		clients := make([]string, 0, len(stats.Clients))
+		for client := range stats.Clients {
+			clients = append(clients, client)
+		}
+		sort.Strings(clients)
+		var bytes, active, rejected []sample
+		for _, client := range clients {
+			c, label := stats.Clients[client], "client="+strconv.Quote(client)
+			bytes = append(bytes, sample{labels: label + `,direction="up"`, value: float64(c.BytesUp)},
+				sample{labels: label + `,direction="down"`, value: float64(c.BytesDown)})
+			active = append(active, sample{labels: label, value: float64(c.ActiveConnections)})
+			rejected = append(rejected, sample{labels: label, value: float64(c.Rejected)})
+		}
+		m.metric("client_bytes_total", "counter", "Bytes transferred by each client of local listeners in sharing mode.", bytes...)
+		m.metric("client_active_connections", "gauge", "Active connections of each client of local listeners in sharing mode.", active...)
+		m.metric("client_rejected_total", "counter", "Connections of each client rejected by access control in sharing mode.", rejected...)
+	}
+	return m.err
+}
+
+type sample struct {
+	labels string // labels without braces, e.g. `result="success"`
+	value  float64
+}
+
+// writer writes metrics and keeps the first error.
+type writer struct {
+	w   io.Writer
+	err error
+}
+
+func (m *writer) metric(name, typ, help string, samples ...sample) {
+	if m.err != nil {
+		return
+	}
+	name = namespace + "_" + name
+	if _, m.err = fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ); m.err != nil {
+		return
+	}
+	for _, s := range samples {
+		if s.labels == "" {
+			_, m.err = fmt.Fprintf(m.w, "%s %g\n", name, s.value)
+		} else {
+			_, m.err = fmt.Fprintf(m.w, "%s{%s} %g\n", name, s.labels, s.value)
+		}
+		if m.err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/passwd"
+)
+
+func TestWrite(t *testing.T) {
+	now := time.Now()
+	stats := extra.Stats{
+		BytesUp:           1024,
+		BytesDown:         4096,
+		ActiveConnections: 2,
+		TotalConnections:  10,
+		Reconnects:        1,
+		SessionSince:      now.Add(-90 * time.Second),
+		LoginAttempts:     map[passwd.LoginResult]uint64{passwd.LoginSuccess: 2, passwd.LoginWrongCaptcha: 1},
+		CaptchaRetries:    1,
+		Clients:           map[string]extra.ClientStats{"192.168.1.20": {ActiveConnections: 1, BytesUp: 100, Rejected: 3}},
+	}
+	var b strings.Builder
+	if err := Write(&b, extra.PhaseConnected, stats, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE wssocks_ustb_up gauge",
+		"wssocks_ustb_up 1",
+		`wssocks_ustb_login_attempts_total{result="success"} 2`,
+		`wssocks_ustb_login_attempts_total{result="wrong_password"} 0`,
+		`wssocks_ustb_login_attempts_total{result="wrong_captcha"} 1`,
+		"wssocks_ustb_captcha_retries_total 1",
+		"wssocks_ustb_session_age_seconds 90",
+		"wssocks_ustb_reconnects_total 1",
+		`wssocks_ustb_bytes_total{direction="down"} 4096`,
+		"wssocks_ustb_active_connections 2",
+		"wssocks_ustb_connections_total 10",
+		`wssocks_ustb_client_bytes_total{client="192.168.1.20",direction="up"} 100`,
+		`wssocks_ustb_client_active_connections{client="192.168.1.20"} 1`,
+		`wssocks_ustb_client_rejected_total{client="192.168.1.20"} 3`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, b.String())
+		}
+	}
+
+	b.Reset()
+	if err := Write(&b, extra.PhaseDisconnected, extra.Stats{}, now); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "wssocks_ustb_up 0\n") || !strings.Contains(b.String(), "wssocks_ustb_session_age_seconds 0\n") ||
+		strings.Contains(b.String(), "client_bytes_total") {
+		t.Errorf("unexpected metrics of disconnected client:\n%s", b.String())
+	}
+}
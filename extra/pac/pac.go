@@ -0,0 +1,128 @@
+// Package pac generates and serves a proxy auto-config (PAC) file for split routing:
+// hosts matching the rules (e.g. campus domains and networks) go through the local proxy,
+// and others are connected directly.
+package pac
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/genshen/wssocks/client"
+)
+
+// Path is the url path of the PAC file served by Server.
+const Path = "/proxy.pac"
+
+// ContentType is the content type of PAC file.
+const ContentType = "application/x-ns-proxy-autoconfig"
+
+// ParseRules splits rules separated by commas or whitespaces, e.g. "*.smu.edu.cn, 10.0.0.0/8".
+func ParseRules(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// rule is a parsed rule, either a domain pattern or an ipv4 network.
+type rule struct {
+	domain  string     // e.g. "smu.edu.cn" or "*.smu.edu.cn"
+	network *net.IPNet // e.g. 10.0.0.0/8
+}
+
+// parseRule parses a rule, which is one of:
+//   - a CIDR (ipv4 only, since isInNet in PAC only supports ipv4), e.g. 10.0.0.0/8;
+//   - an ipv4 address, e.g. 10.1.2.3, which is the same as 10.1.2.3/32;
+//   - a domain, e.g. smu.edu.cn, which matches the domain and its sub-domains;
+//   - a domain pattern with wildcards (`*` and `?`), e.g. *.smu.edu.cn.
+func parseRule(s string) (rule, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if strings.Contains(s, "/") {
+		ip, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return rule{}, fmt.Errorf("bad pac rule `%s`: %w", s, err)
+		}
+		if ip.To4() == nil {
+			return rule{}, fmt.Errorf("bad pac rule `%s`: only ipv4 network is supported", s)
+		}
+		return rule{network: network}, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() == nil {
+			return rule{}, fmt.Errorf("bad pac rule `%s`: only ipv4 address is supported", s)
+		}
+		return rule{network: &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}}, nil
+	}
+	if s == "" || strings.ContainsAny(s, `"'\:`) {
+		return rule{}, fmt.Errorf("bad pac rule `%s`", s)
+	}
+	return rule{domain: strings.TrimSuffix(s, ".")}, nil
+}
+
+// ValidateRules checks whether all rules can be parsed.
+func ValidateRules(rules []string) error {
+	for _, r := range rules {
+		if _, err := parseRule(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Proxy returns the proxy string in PAC for the local listeners in options,
+// the socks5 proxy is preferred, and the http proxy is the fallback if it is enabled.
+func Proxy(options client.Options) string {
+	proxy := "SOCKS5 " + loopbackAddr(options.LocalSocks5Addr) + "; SOCKS " + loopbackAddr(options.LocalSocks5Addr)
+	if options.HttpEnabled && options.LocalHttpAddr != "" {
+		proxy += "; PROXY " + loopbackAddr(options.LocalHttpAddr)
+	}
+	return proxy
+}
+
+// loopbackAddr replaces the unspecified host (e.g. ":1080" or "0.0.0.0:1080") with loopback address,
+// since the PAC is used on local machine.
+func loopbackAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Generate generates the PAC file, hosts matching rules go through the local listeners in options,
+// and other hosts are connected directly.
+func Generate(rules []string, options client.Options) (string, error) {
+	parsed := make([]rule, 0, len(rules))
+	for _, r := range rules {
+		pr, err := parseRule(r)
+		if err != nil {
+			return "", err
+		}
+		parsed = append(parsed, pr)
+	}
+
+	var b strings.Builder
+	b.WriteString("// generated by wssocks-ustb\n")
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	fmt.Fprintf(&b, "    var proxy = %q;\n", Proxy(options))
+	b.WriteString("    host = host.toLowerCase();\n")
+	// networks are only matched with ip literals, so that no dns lookup is made for domains.
+	b.WriteString("    var isIPv4 = /^\\d+\\.\\d+\\.\\d+\\.\\d+$/.test(host);\n")
+	for _, r := range parsed {
+		switch {
+		case r.network != nil:
+			fmt.Fprintf(&b, "    if (isIPv4 && isInNet(host, %q, %q)) return proxy;\n",
+				r.network.IP.String(), net.IP(r.network.Mask).String())
+		case strings.ContainsAny(r.domain, "*?"):
+			fmt.Fprintf(&b, "    if (shExpMatch(host, %q)) return proxy;\n", r.domain)
+		default:
+			fmt.Fprintf(&b, "    if (host == %q || dnsDomainIs(host, %q)) return proxy;\n", r.domain, "."+r.domain)
+		}
+	}
+	b.WriteString("    return \"DIRECT\";\n")
+	b.WriteString("}\n")
+	return b.String(), nil
+}
@@ -0,0 +1,78 @@
+package pac
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/genshen/wssocks/client"
+)
+
+func TestParseRules(t *testing.T) {
+	rules := ParseRules("*.smu.edu.cn, 10.0.0.0/8\nlib.example.com  202.204.48.66")
+	expected := []string{"*.smu.edu.cn", "10.0.0.0/8", "lib.example.com", "202.204.48.66"}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("ParseRules: got %v, want %v", rules, expected)
+	}
+	if err := ValidateRules(rules); err != nil {
+		t.Error(err)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "fd00::/8", "::1", `a"b`, "host:80"} {
+		if err := ValidateRules([]string{bad}); err == nil {
+			t.Errorf("rule %q should be rejected", bad)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	options := client.Options{LocalSocks5Addr: ":1080", HttpEnabled: true, LocalHttpAddr: "127.0.0.1:1086"}
+	script, err := Generate([]string{"*.smu.edu.cn", "10.0.0.0/8", "smu.edu.cn", "202.204.48.66"}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`var proxy = "SOCKS5 127.0.0.1:1080; SOCKS 127.0.0.1:1080; PROXY 127.0.0.1:1086";`,
+		`if (shExpMatch(host, "*.smu.edu.cn")) return proxy;`,
+		`if (isIPv4 && isInNet(host, "10.0.0.0", "255.0.0.0")) return proxy;`,
+		`if (host == "smu.edu.cn" || dnsDomainIs(host, ".smu.edu.cn")) return proxy;`,
+		`if (isIPv4 && isInNet(host, "202.204.48.66", "255.255.255.255")) return proxy;`,
+		`return "DIRECT";`,
+	} {
+		if !strings.Contains(script, line) {
+			t.Errorf("missing %q in:\n%s", line, script)
+		}
+	}
+
+	options.HttpEnabled = false
+	if proxy := Proxy(options); proxy != "SOCKS5 127.0.0.1:1080; SOCKS 127.0.0.1:1080" {
+		t.Errorf("unexpected proxy without http: %s", proxy)
+	}
+}
+
+func TestServer(t *testing.T) {
+	if _, err := Listen("0.0.0.0:0", ""); err != ErrNotLoopback {
+		t.Errorf("listening on non-loopback address: got %v, want %v", err, ErrNotLoopback)
+	}
+	s, err := Listen("127.0.0.1:0", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+	s.SetScript("new")
+
+	resp, err := http.Get(s.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "new" {
+		t.Errorf("unexpected response: %d %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("content type: got %s, want %s", ct, ContentType)
+	}
+}
@@ -0,0 +1,87 @@
+package pac
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrNotLoopback = errors.New("pac server can only listen on loopback address")
+
+// Server serves the PAC file on a loopback address, the content can be updated while serving.
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+	lock     sync.RWMutex
+	script   string
+}
+
+// Listen listens on addr (a loopback address, e.g. 127.0.0.1:1087) for serving script.
+func Listen(addr string, script string) (*Server, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, ErrNotLoopback
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: l, script: script}
+	mux := http.NewServeMux()
+	mux.HandleFunc(Path, s.serve)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return s, nil
+}
+
+// Addr returns the listening address.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// URL returns the url of PAC file.
+func (s *Server) URL() string {
+	return URL(s.Addr())
+}
+
+// URL returns the url of PAC file served on addr.
+func URL(addr string) string {
+	return "http://" + addr + Path
+}
+
+// SetScript replaces the content of PAC file.
+func (s *Server) SetScript(script string) {
+	s.lock.Lock()
+	s.script = script
+	s.lock.Unlock()
+}
+
+// Serve serves the PAC file until Close is called.
+func (s *Server) Serve() error {
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.lock.RLock()
+	script := s.script
+	s.lock.RUnlock()
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(script))
+}
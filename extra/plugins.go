@@ -0,0 +1,83 @@
+package extra
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/genshen/wssocks/client"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/ver"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
+)
+
+var errNoPlugin = errors.New("no vpn plugin for the connection")
+
+// pluginDispatcher is registered as the (global) option and request plugin of wssocks.
+// It dispatches the plugin calls to the vpn plugin of each connection,
+// keyed by the remote url of the connection (the same pointer is passed to both plugin calls),
+// so that multiple clients with different vpn settings can be started at the same time.
+type pluginDispatcher struct {
+	lock    sync.Mutex
+	plugins map[*url.URL]*vpn.UstbVpn
+}
+
+var dispatcher = pluginDispatcher{plugins: make(map[*url.URL]*vpn.UstbVpn)}
+
+var registerOnce sync.Once
+var registerErr error
+
+// registerPlugins adds the dispatcher and version plugin to wssocks, only once.
+func registerPlugins() error {
+	registerOnce.Do(func() {
+		if err := client.AddPluginOption(&dispatcher); err != nil {
+			registerErr = err
+			return
+		}
+		if err := client.AddPluginRequest(&dispatcher); err != nil {
+			registerErr = err
+			return
+		}
+		registerErr = client.AddPluginVersion(&ver.PluginVersionNeg{})
+	})
+	return registerErr
+}
+
+func (d *pluginDispatcher) add(remoteUrl *url.URL, v *vpn.UstbVpn) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.plugins[remoteUrl] = v
+}
+
+func (d *pluginDispatcher) remove(remoteUrl *url.URL) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.plugins, remoteUrl)
+}
+
+func (d *pluginDispatcher) get(remoteUrl *url.URL) (*vpn.UstbVpn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if v, ok := d.plugins[remoteUrl]; ok {
+		return v, nil
+	}
+	return nil, errNoPlugin
+}
+
+// OnOptionSet is implementation of interface OptionPlugin
+func (d *pluginDispatcher) OnOptionSet(options client.Options) error {
+	v, err := d.get(options.RemoteUrl)
+	if err != nil {
+		return err
+	}
+	return v.OnOptionSet(options)
+}
+
+// BeforeRequest is implementation of interface RequestPlugin
+func (d *pluginDispatcher) BeforeRequest(hc *http.Client, transport *http.Transport, url *url.URL, header *http.Header) error {
+	v, err := d.get(url)
+	if err != nil {
+		return err
+	}
+	return v.BeforeRequest(hc, transport, url, header)
+}
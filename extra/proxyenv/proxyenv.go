@@ -0,0 +1,256 @@
+// Package proxyenv generates the proxy settings of shells and tools (git, ssh, npm, docker, go ...)
+// for the local listeners of client.
+//
+// The https proxy (http CONNECT) of wssocks shares the socks5 listener, and the http listener only serves
+// plain http requests, so https_proxy points to the socks5 address with http scheme.
+// If the http proxy is disabled, socks5 is used for all, which is not supported by some tools (e.g. npm, pip).
+package proxyenv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// kinds of generated settings.
+const (
+	Bash         = "bash"
+	Zsh          = "zsh"
+	Fish         = "fish"
+	PowerShell   = "powershell"
+	Git          = "git"
+	Ssh          = "ssh"
+	Npm          = "npm"
+	Pip          = "pip"
+	Conda        = "conda"
+	DockerDaemon = "docker-daemon"
+	DockerClient = "docker-client"
+	Go           = "go"
+)
+
+// Kinds are all kinds of settings, in the order of showing.
+var Kinds = []string{Bash, Zsh, Fish, PowerShell, Git, Ssh, Npm, Pip, Conda, DockerDaemon, DockerClient, Go}
+
+var titles = map[string]string{
+	Bash:         "bash",
+	Zsh:          "zsh",
+	Fish:         "fish",
+	PowerShell:   "PowerShell",
+	Git:          "git config",
+	Ssh:          "~/.ssh/config",
+	Npm:          "npm",
+	Pip:          "pip",
+	Conda:        "conda",
+	DockerDaemon: "Docker daemon",
+	DockerClient: "Docker client",
+	Go:           "Go",
+}
+
+// NoProxy are the hosts not using the proxy.
+var NoProxy = []string{"localhost", "127.0.0.1", "::1"}
+
+// ErrHttpRequired is returned if the tool does not support socks5 proxy and the http proxy is disabled.
+var ErrHttpRequired = errors.New("http(s) proxy of client is required")
+
+// Options are the addresses of local listeners.
+type Options struct {
+	Socks5Addr string   // socks5 (and https) listening address, e.g. :1080
+	HttpAddr   string   // http listening address, empty if http proxy is disabled
+	NcCommand  string   // command relaying stdio through the socks5 proxy for ssh, e.g. wssocks-ustb nc --proxy 127.0.0.1:1080
+	Hosts      []string // internal hosts (wildcards are allowed) for ssh and go, e.g. *.smu.edu.cn
+}
+
+// Title returns the name of kind for showing.
+func Title(kind string) string {
+	if title, ok := titles[kind]; ok {
+		return title
+	}
+	return kind
+}
+
+// Generate returns the settings of kind.
+func Generate(kind string, o Options) (string, error) {
+	if o.Socks5Addr == "" {
+		return "", errors.New("socks5 address is required")
+	}
+	o.Socks5Addr = dialAddr(o.Socks5Addr)
+	if o.HttpAddr != "" {
+		o.HttpAddr = dialAddr(o.HttpAddr)
+	}
+	if o.NcCommand == "" {
+		o.NcCommand = "wssocks-ustb nc --proxy " + o.Socks5Addr
+	}
+
+	switch kind {
+	case Bash, Zsh:
+		return o.env(func(k, v string) string { return fmt.Sprintf("export %s=%q", k, v) }, false), nil
+	case Fish:
+		return o.env(func(k, v string) string { return fmt.Sprintf("set -gx %s %q", k, v) }, false), nil
+	case PowerShell:
+		// environment variables are case-insensitive on windows.
+		return o.env(func(k, v string) string { return fmt.Sprintf("$env:%s = %q", k, v) }, true), nil
+	case Git:
+		// libcurl in git supports socks5 for both http and https remotes, remotes over ssh use ssh config.
+		return fmt.Sprintf("git config --global http.proxy socks5h://%s", o.Socks5Addr), nil
+	case Ssh:
+		hosts := "*"
+		if len(o.Hosts) != 0 {
+			hosts = strings.Join(o.Hosts, " ")
+		}
+		return fmt.Sprintf("Host %s\n    ProxyCommand %s %%h %%p", hosts, o.NcCommand), nil
+	case Npm:
+		if o.HttpAddr == "" {
+			return "", fmt.Errorf("%w for npm", ErrHttpRequired)
+		}
+		return fmt.Sprintf("npm config set proxy %s\nnpm config set https-proxy %s", o.httpProxy(), o.httpsProxy()), nil
+	case Pip:
+		if o.HttpAddr == "" {
+			return "", fmt.Errorf("%w for pip", ErrHttpRequired)
+		}
+		// the package index is https, which uses http CONNECT.
+		return fmt.Sprintf("pip config set global.proxy %s", o.httpsProxy()), nil
+	case Conda:
+		if o.HttpAddr == "" {
+			return "", fmt.Errorf("%w for conda", ErrHttpRequired)
+		}
+		return fmt.Sprintf("conda config --set proxy_servers.http %s\nconda config --set proxy_servers.https %s",
+			o.httpProxy(), o.httpsProxy()), nil
+	case DockerDaemon:
+		// docker is a go program, which supports socks5 but not socks5h (the host is always resolved by proxy).
+		httpProxy, httpsProxy := o.proxies("socks5")
+		return fmt.Sprintf("# /etc/systemd/system/docker.service.d/http-proxy.conf\n"+
+			"# then run: sudo systemctl daemon-reload && sudo systemctl restart docker\n"+
+			"[Service]\nEnvironment=\"HTTP_PROXY=%s\" \"HTTPS_PROXY=%s\" \"NO_PROXY=%s\"",
+			httpProxy, httpsProxy, strings.Join(NoProxy, ",")), nil
+	case DockerClient:
+		// ~/.docker/config.json, the proxies are passed to containers and builds.
+		httpProxy, httpsProxy := o.proxies("socks5")
+		config := map[string]map[string]map[string]string{"proxies": {"default": {
+			"httpProxy":  httpProxy,
+			"httpsProxy": httpsProxy,
+			"noProxy":    strings.Join(NoProxy, ","),
+		}}}
+		data, err := json.MarshalIndent(config, "", "  ")
+		return string(data), err
+	case Go:
+		// the go command reads the proxy of network from environment (see bash and other shells),
+		// the private modules are fetched directly from their repositories and not checked by checksum database.
+		lines := []string{"go env -w GOPROXY=https://proxy.golang.org,direct"}
+		if len(o.Hosts) != 0 {
+			lines = append(lines, "go env -w GOPRIVATE="+strings.Join(o.Hosts, ","))
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	return "", fmt.Errorf("unknown kind `%s`, supported: %s", kind, strings.Join(Kinds, ", "))
+}
+
+// env returns the lines of environment variables formatted by set.
+// If upperOnly is true, the lower case variables are skipped.
+func (o Options) env(set func(k, v string) string, upperOnly bool) string {
+	var lines []string
+	for _, v := range o.vars() {
+		if !upperOnly {
+			lines = append(lines, set(v[0], v[1]))
+		}
+		lines = append(lines, set(strings.ToUpper(v[0]), v[1]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// vars returns the (lower case) proxy environment variables.
+func (o Options) vars() [][2]string {
+	// socks5h is used, so that the internal hosts are resolved by proxy (e.g. in curl).
+	httpProxy, httpsProxy := o.proxies("socks5h")
+	return [][2]string{
+		{"http_proxy", httpProxy},
+		{"https_proxy", httpsProxy},
+		{"all_proxy", "socks5h://" + o.Socks5Addr},
+		{"no_proxy", strings.Join(NoProxy, ",")},
+	}
+}
+
+// Environ returns the proxy environment variables (in the form of key=value, both lower and upper case)
+// and GIT_SSH_COMMAND using nc command, for running a command through the proxy.
+func Environ(o Options) ([]string, error) {
+	if o.Socks5Addr == "" {
+		return nil, errors.New("socks5 address is required")
+	}
+	o.Socks5Addr = dialAddr(o.Socks5Addr)
+	if o.HttpAddr != "" {
+		o.HttpAddr = dialAddr(o.HttpAddr)
+	}
+	if o.NcCommand == "" {
+		o.NcCommand = "wssocks-ustb nc --proxy " + o.Socks5Addr
+	}
+	var env []string
+	for _, v := range o.vars() {
+		env = append(env, v[0]+"="+v[1], strings.ToUpper(v[0])+"="+v[1])
+	}
+	// GIT_SSH_COMMAND is run by sh, the nc command is quoted in single quotes.
+	return append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -o ProxyCommand='%s %%h %%p'", o.NcCommand)), nil
+}
+
+// NcCommand returns the built-in nc command of executable exe using the socks5 proxy,
+// exe is quoted if it contains spaces.
+func NcCommand(exe, socksAddr string) string {
+	if strings.Contains(exe, " ") {
+		exe = `"` + exe + `"`
+	}
+	return fmt.Sprintf("%s nc --proxy %s", exe, dialAddr(socksAddr))
+}
+
+// httpProxy and httpsProxy are the proxy urls of http and https, the http proxy must be enabled.
+func (o Options) httpProxy() string {
+	return "http://" + o.HttpAddr
+}
+
+func (o Options) httpsProxy() string {
+	return "http://" + o.Socks5Addr
+}
+
+// proxies returns the proxy urls of http and https for the tools supporting socks5,
+// socks5 with socksScheme is used if the http proxy is disabled.
+func (o Options) proxies(socksScheme string) (string, string) {
+	if o.HttpAddr == "" {
+		return socksScheme + "://" + o.Socks5Addr, socksScheme + "://" + o.Socks5Addr
+	}
+	return o.httpProxy(), o.httpsProxy()
+}
+
+// Hosts returns the internal hosts of the domains in pac rules (networks are skipped) and dns zones.
+func Hosts(rules, zones []string) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	add := func(host string) {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	for _, rule := range rules {
+		if _, _, err := net.ParseCIDR(rule); err != nil && net.ParseIP(rule) == nil {
+			add(strings.ToLower(rule))
+		}
+	}
+	for _, zone := range zones {
+		if zone = strings.Trim(zone, "."); zone != "" {
+			add("*." + strings.ToLower(zone))
+		}
+	}
+	return hosts
+}
+
+// dialAddr returns the address for connecting to a listening address,
+// the empty or unspecified host (e.g. :1080, 0.0.0.0:1080) is replaced with loopback.
+func dialAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
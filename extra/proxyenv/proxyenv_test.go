@@ -0,0 +1,94 @@
+package proxyenv
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	o := Options{Socks5Addr: ":1080", HttpAddr: "0.0.0.0:1086", Hosts: []string{"*.smu.edu.cn"}}
+	for kind, lines := range map[string][]string{
+		Bash: {`export http_proxy="http://127.0.0.1:1086"`, `export HTTPS_PROXY="http://127.0.0.1:1080"`,
+			`export ALL_PROXY="socks5h://127.0.0.1:1080"`, `export no_proxy="localhost,127.0.0.1,::1"`},
+		Fish:         {`set -gx https_proxy "http://127.0.0.1:1080"`},
+		PowerShell:   {`$env:HTTP_PROXY = "http://127.0.0.1:1086"`},
+		Git:          {"git config --global http.proxy socks5h://127.0.0.1:1080"},
+		Ssh:          {"Host *.smu.edu.cn", "    ProxyCommand wssocks-ustb nc --proxy 127.0.0.1:1080 %h %p"},
+		Npm:          {"npm config set proxy http://127.0.0.1:1086", "npm config set https-proxy http://127.0.0.1:1080"},
+		Pip:          {"pip config set global.proxy http://127.0.0.1:1080"},
+		Conda:        {"conda config --set proxy_servers.https http://127.0.0.1:1080"},
+		DockerDaemon: {`Environment="HTTP_PROXY=http://127.0.0.1:1086" "HTTPS_PROXY=http://127.0.0.1:1080"`},
+		Go:           {"go env -w GOPRIVATE=*.smu.edu.cn"},
+	} {
+		text, err := Generate(kind, o)
+		if err != nil {
+			t.Fatal(kind, err)
+		}
+		for _, line := range lines {
+			if !strings.Contains(text, line) {
+				t.Errorf("%s: missing %q in:\n%s", kind, line, text)
+			}
+		}
+	}
+	if text, _ := Generate(PowerShell, o); strings.Contains(text, "http_proxy") {
+		t.Errorf("lower case variables in PowerShell:\n%s", text)
+	}
+	if _, err := Generate("vim", o); err == nil {
+		t.Error("expect error for unknown kind")
+	}
+}
+
+func TestWithoutHttp(t *testing.T) {
+	o := Options{Socks5Addr: "127.0.0.1:2080", NcCommand: `"C:/Program Files/wssocks/client-ui.exe" nc --proxy 127.0.0.1:2080`}
+	if _, err := Generate(Npm, o); !errors.Is(err, ErrHttpRequired) {
+		t.Errorf("npm without http: got %v, want %v", err, ErrHttpRequired)
+	}
+	if text, _ := Generate(Bash, o); !strings.Contains(text, `export https_proxy="socks5h://127.0.0.1:2080"`) {
+		t.Errorf("socks5 is not used without http:\n%s", text)
+	}
+	if text, _ := Generate(Ssh, o); text != "Host *\n    ProxyCommand \"C:/Program Files/wssocks/client-ui.exe\" nc --proxy 127.0.0.1:2080 %h %p" {
+		t.Errorf("unexpected ssh config:\n%s", text)
+	}
+
+	text, err := Generate(DockerClient, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Proxies map[string]map[string]string `json:"proxies"`
+	}
+	if err := json.Unmarshal([]byte(text), &config); err != nil {
+		t.Fatal(err)
+	}
+	if proxy := config.Proxies["default"]["httpsProxy"]; proxy != "socks5://127.0.0.1:2080" {
+		t.Errorf("unexpected https proxy of docker: %s", proxy)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	hosts := Hosts([]string{"*.SMU.edu.cn", "10.0.0.0/8", "10.1.2.3", "lib.example.com"}, []string{".campus.local.", "smu.edu.cn"})
+	want := []string{"*.smu.edu.cn", "lib.example.com", "*.campus.local"}
+	if strings.Join(hosts, ",") != strings.Join(want, ",") {
+		t.Errorf("Hosts: got %q, want %q", hosts, want)
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	env, err := Environ(Options{Socks5Addr: ":2080", NcCommand: NcCommand("/opt/my tools/wssocks-ustb", ":2080")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"https_proxy=socks5h://127.0.0.1:2080": true,
+		"ALL_PROXY=socks5h://127.0.0.1:2080":   true,
+		`GIT_SSH_COMMAND=ssh -o ProxyCommand='"/opt/my tools/wssocks-ustb" nc --proxy 127.0.0.1:2080 %h %p'`: true,
+	}
+	for _, v := range env {
+		delete(want, v)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing variables %v in %q", want, env)
+	}
+}
@@ -0,0 +1,234 @@
+package extra
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/genshen/wssocks/wss"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/passwd"
+	"github.com/segmentio/ksuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// AutoReconnectOptions are the options of reconnecting automatically when the connection is lost.
+// Zero values of durations and numbers are replaced by defaults.
+type AutoReconnectOptions struct {
+	Enable     bool
+	MinBackoff time.Duration // delay before the first retry, default 1s
+	MaxBackoff time.Duration // max delay between retries, default 2m
+	// the tunnel is probed periodically by connecting to ProbeAddr through it,
+	// and it is reconnected if ProbeFailures probes fail in a row.
+	ProbeInterval time.Duration // default 30s, negative to disable probing
+	ProbeTimeout  time.Duration // default 10s
+	ProbeFailures int           // default 3
+	ProbeAddr     string        // address (host:port) to connect to, default is the host of remote address
+}
+
+var ErrProbeFailed = errors.New("health probe of the tunnel failed")
+
+func (o AutoReconnectOptions) withDefaults(remote string) AutoReconnectOptions {
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 2 * time.Minute
+	}
+	if o.ProbeInterval == 0 {
+		o.ProbeInterval = 30 * time.Second
+	}
+	if o.ProbeTimeout <= 0 {
+		o.ProbeTimeout = 10 * time.Second
+	}
+	if o.ProbeFailures <= 0 {
+		o.ProbeFailures = 3
+	}
+	if o.ProbeAddr == "" {
+		o.ProbeAddr = hostPort(remote)
+	}
+	return o
+}
+
+// hostPort returns the host with port of remote url, the port is the default port of scheme if it is absent.
+func hostPort(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return ""
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "wss" || u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// backoff returns the delay before the retry (counting from 0), which grows exponentially with jitter,
+// it is chosen randomly from [d/2, d], where d is min(MinBackoff * 2^retry, MaxBackoff).
+func (o AutoReconnectOptions) backoff(retry int) time.Duration {
+	d := o.MaxBackoff
+	if retry < 32 && o.MinBackoff<<retry < o.MaxBackoff && o.MinBackoff<<retry > 0 {
+		d = o.MinBackoff << retry
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// reconnect restarts the connection, and retries with backoff if auto reconnecting is enabled.
+// The vpn session of last connection is reused if session is true and it is not expired.
+func (h *TaskHandles) reconnect(options Options, session bool) error {
+	ar := options.AutoReconnect.withDefaults(options.RemoteAddr)
+	for retry := 0; ; retry++ {
+		err := h.restartConn(options, session)
+		if err == nil || !ar.Enable || h.stopRequested.Load() {
+			return err
+		}
+		// do not retry with wrong password, the account may be locked.
+		if errors.Is(err, passwd.ErrWrongPassword) {
+			log.WithError(err).Error("vpn password is rejected, stop reconnecting.")
+			return err
+		}
+		delay := ar.backoff(retry)
+		log.WithError(err).WithField("retry in", delay.Truncate(time.Millisecond)).Warning("failed to reconnect.")
+		select {
+		case <-time.After(delay):
+		case <-h.stopCtx.Done():
+			return err
+		}
+	}
+}
+
+// startConn starts a connection, it is replaced in tests.
+var startConn = (*TaskHandles).startWssocks
+
+// restartConn starts a new connection, trying the vpn session of last connection first.
+func (h *TaskHandles) restartConn(options Options, session bool) error {
+	if session && h.vpn != nil && h.vpn.Session != nil {
+		options.UstbVpn.Session = h.vpn.Session
+		_, err := h.start(options)
+		if err == nil || h.stopRequested.Load() {
+			return err
+		}
+		log.WithError(err).Info("failed to connect with last vpn session, logging in again.")
+		options.UstbVpn.Session = nil
+		options.UstbVpn.StaleSession = h.vpn.Session
+	}
+	return h.connect(options)
+}
+
+// connect starts a new connection. If the vpn session got from the session broker fails to connect
+// (e.g. it is expired or logged out on other devices), the session is replaced by logging in again.
+func (h *TaskHandles) connect(options Options) error {
+	v, err := h.start(options)
+	if err != nil && !h.stopRequested.Load() && v != nil && v.SessionFromBroker() {
+		log.WithError(err).Info("failed to connect with vpn session of session broker, logging in again.")
+		options.UstbVpn.StaleSession = v.Session
+		_, err = h.start(options)
+	}
+	return err
+}
+
+// start starts a connection by startConn. The vpn plugin of last connection is replaced only if the new
+// connection succeeds, so that its session is still reused by the next retry if this one fails.
+// The replaced session is logged out if the new connection uses another session, and so is the session
+// logged in by a failed connection, which is not reused.
+func (h *TaskHandles) start(options Options) (*vpn.UstbVpn, error) {
+	v, err := startConn(h, options)
+	if err != nil {
+		if v != nil && v.Session != nil && !sameSession(v.Session, options.UstbVpn.Session) {
+			if err := v.Logout(); err != nil {
+				log.WithError(err).Warning("failed to logout vpn session of the failed connection.")
+			}
+		}
+		return v, err
+	}
+	last := h.vpn
+	h.vpn = v
+	if last != nil && last.Session != nil && !sameSession(last.Session, v.Session) {
+		if err := last.Logout(); err != nil {
+			log.WithError(err).Warning("failed to logout vpn session of last connection.")
+		}
+	}
+	return v, nil
+}
+
+// sameSession returns true if a and b have the same cookies.
+func sameSession(a, b *vpn.Session) bool {
+	if a == nil || b == nil || len(a.Cookies) != len(b.Cookies) {
+		return a == b
+	}
+	for i, c := range a.Cookies {
+		if c.Name != b.Cookies[i].Name || c.Value != b.Cookies[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+// startProbe probes the tunnel periodically, the connection is closed if the probes keep failing.
+func (h *TaskHandles) startProbe(wsc *wss.WebSocketClient, ar AutoReconnectOptions) {
+	if ar.ProbeInterval < 0 || ar.ProbeAddr == "" {
+		return
+	}
+	once, ctx := h.once, h.connCtx
+	h.eg.Go(func() error {
+		t := time.NewTicker(ar.ProbeInterval)
+		defer t.Stop()
+		failures := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-t.C:
+			}
+			if err := probeTunnel(wsc, ar.ProbeAddr, ar.ProbeTimeout); err != nil {
+				failures++
+				log.WithError(err).WithField("failures", failures).Warning("health probe of the tunnel failed.")
+				if failures >= ar.ProbeFailures {
+					once.Do(h.closeAll)
+					return ErrProbeFailed
+				}
+				continue
+			}
+			failures = 0
+		}
+	})
+}
+
+// probeTunnel asks the server to connect to addr through the tunnel, and waits for the reply.
+func probeTunnel(wsc *wss.WebSocketClient, addr string, timeout time.Duration) error {
+	result := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case result <- err:
+		default:
+		}
+	}
+	// the server replies the socks5 success message once it connects to addr, or closes the proxy on failure.
+	proxy := wsc.NewProxy(func(id ksuid.KSUID, data wss.ServerData) {
+		report(nil)
+	}, func(id ksuid.KSUID, tell bool) {
+		report(errors.New("connection to " + addr + " is closed by server"))
+	}, func(id ksuid.KSUID, err error) {
+		if err != nil {
+			report(err)
+		}
+	})
+	defer func() {
+		wsc.RemoveProxy(proxy.Id)
+		wsc.TellClose(proxy.Id)
+	}()
+	if err := proxy.Establish(wsc, nil, wss.ProxyTypeSocks5, addr); err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return errors.New("probe timeout")
+	}
+}
@@ -0,0 +1,50 @@
+package extra
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
+)
+
+func TestReconnectReuseSession(t *testing.T) {
+	last := &vpn.Session{Cookies: []*http.Cookie{{Name: "wengine_vpn_ticket", Value: "1"}}}
+	h := &TaskHandles{vpn: &vpn.UstbVpn{Enable: true, Session: last}}
+	h.stopCtx = context.Background()
+
+	var sessions []*vpn.Session // sessions passed to each connection
+	fail := true
+	startConn = func(h *TaskHandles, options Options) (*vpn.UstbVpn, error) {
+		sessions = append(sessions, options.UstbVpn.Session)
+		v := options.UstbVpn
+		if fail {
+			// the server is unreachable, or the vpn can not log in.
+			return &v, errors.New("connection refused")
+		}
+		return &v, nil
+	}
+	defer func() { startConn = (*TaskHandles).startWssocks }()
+
+	if err := h.restartConn(Options{}, true); err == nil {
+		t.Fatal("expected error of the failed reconnection")
+	}
+	if len(sessions) != 2 || sessions[0] != last || sessions[1] != nil {
+		t.Fatalf("the last session should be tried before logging in: %v", sessions)
+	}
+	if h.vpn.Session != last {
+		t.Fatal("the last session should be kept after the failed reconnection")
+	}
+
+	sessions, fail = nil, false
+	if err := h.restartConn(Options{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0] != last {
+		t.Errorf("the last session should be reused: %v", sessions)
+	}
+	if h.vpn.Session != last {
+		t.Error("the session of the new connection should be the last session")
+	}
+}
@@ -0,0 +1,186 @@
+// Package route decides how the connections accepted by local proxy listeners are routed:
+// through the tunnel, connected directly or rejected.
+//
+// Rules are read from a text file, one rule per line in the form of `<kind> <value> <action>`,
+// and the first matched rule wins, e.g.:
+//
+//	# campus hosts go through the tunnel
+//	suffix smu.edu.cn tunnel
+//	cidr   10.0.0.0/8 tunnel
+//	domain ads.example.com reject
+//	port   22 direct
+//	port   6000-7000 reject
+//	default direct
+//
+// Kinds of rules:
+//   - domain: the host equals the domain;
+//   - suffix: the host is the domain or its sub-domain;
+//   - cidr: the host is an ip address in the network (domains are not resolved for matching);
+//   - port: the port equals the value, or is in the range (e.g. 6000-7000).
+//
+// Actions are tunnel, direct and reject.
+// The `default <action>` line sets the action of unmatched connections, which is tunnel if it is absent.
+package route
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Action is the routing action of a connection.
+type Action string
+
+const (
+	Tunnel Action = "tunnel" // through the wssocks tunnel
+	Direct Action = "direct" // connect directly from local machine
+	Reject Action = "reject" // refuse the connection
+)
+
+// Decision is the result of routing a connection.
+type Decision struct {
+	Action Action
+	Rule   string // the matched rule, or empty if the default action is used
+}
+
+type rule struct {
+	kind    string // domain, suffix, cidr or port
+	domain  string
+	network *net.IPNet
+	portMin int
+	portMax int
+	action  Action
+	text    string
+}
+
+// Table is a parsed list of routing rules.
+type Table struct {
+	rules []rule
+	def   Action
+}
+
+// DefaultTable routes all connections through the tunnel.
+var DefaultTable = &Table{def: Tunnel}
+
+// ParseFile parses the rules in file.
+func ParseFile(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse parses rules, empty lines and lines starting with `#` are ignored.
+func Parse(r io.Reader) (*Table, error) {
+	t := &Table{def: Tunnel}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "default" {
+			action, err := parseAction(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			t.def = action
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: rule must be `<kind> <value> <action>`", n)
+		}
+		rl, err := parseRule(fields[0], fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		rl.text = strings.Join(fields, " ")
+		t.rules = append(t.rules, rl)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseAction(s string) (Action, error) {
+	switch action := Action(strings.ToLower(s)); action {
+	case Tunnel, Direct, Reject:
+		return action, nil
+	}
+	return "", fmt.Errorf("unknown action `%s`", s)
+}
+
+func parseRule(kind, value, action string) (rule, error) {
+	r := rule{kind: strings.ToLower(kind)}
+	var err error
+	if r.action, err = parseAction(action); err != nil {
+		return rule{}, err
+	}
+	switch r.kind {
+	case "domain", "suffix":
+		r.domain = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(value), "."), ".")
+		if r.domain == "" {
+			return rule{}, fmt.Errorf("empty domain in %s rule", r.kind)
+		}
+	case "cidr":
+		if _, r.network, err = net.ParseCIDR(value); err != nil {
+			return rule{}, err
+		}
+	case "port":
+		min, max, found := strings.Cut(value, "-")
+		if r.portMin, err = strconv.Atoi(min); err != nil {
+			return rule{}, fmt.Errorf("bad port `%s`", value)
+		}
+		r.portMax = r.portMin
+		if found {
+			if r.portMax, err = strconv.Atoi(max); err != nil {
+				return rule{}, fmt.Errorf("bad port range `%s`", value)
+			}
+		}
+		if r.portMin < 0 || r.portMax > 65535 || r.portMin > r.portMax {
+			return rule{}, fmt.Errorf("bad port range `%s`", value)
+		}
+	default:
+		return rule{}, fmt.Errorf("unknown rule kind `%s`", kind)
+	}
+	return r, nil
+}
+
+// Match routes the connection to addr (host:port).
+func (t *Table) Match(addr string) Decision {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	port, _ := strconv.Atoi(portStr)
+	ip := net.ParseIP(host)
+	for _, r := range t.rules {
+		if r.match(host, ip, port) {
+			return Decision{Action: r.action, Rule: r.text}
+		}
+	}
+	return Decision{Action: t.def}
+}
+
+func (r *rule) match(host string, ip net.IP, port int) bool {
+	switch r.kind {
+	case "domain":
+		return host == r.domain
+	case "suffix":
+		return host == r.domain || strings.HasSuffix(host, "."+r.domain)
+	case "cidr":
+		return ip != nil && r.network.Contains(ip)
+	case "port":
+		return port >= r.portMin && port <= r.portMax
+	}
+	return false
+}
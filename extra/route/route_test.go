@@ -0,0 +1,113 @@
+package route
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRules = `
+# campus hosts
+suffix smu.edu.cn tunnel
+domain ads.example.com reject
+cidr 10.0.0.0/8 tunnel
+cidr fd00::/8 tunnel
+port 22 direct
+port 6000-7000 reject
+default direct
+`
+
+func TestMatch(t *testing.T) {
+	table, err := Parse(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		addr   string
+		action Action
+		rule   string
+	}{
+		{"lib.smu.edu.cn:443", Tunnel, "suffix smu.edu.cn tunnel"},
+		{"SMU.EDU.CN.:80", Tunnel, "suffix smu.edu.cn tunnel"},
+		{"notsmu.edu.cn:80", Direct, ""},
+		{"ads.example.com:443", Reject, "domain ads.example.com reject"},
+		{"www.ads.example.com:443", Direct, ""},
+		{"10.1.2.3:80", Tunnel, "cidr 10.0.0.0/8 tunnel"},
+		{"[fd00::1]:80", Tunnel, "cidr fd00::/8 tunnel"},
+		{"github.com:22", Direct, "port 22 direct"},
+		{"lib.smu.edu.cn:22", Tunnel, "suffix smu.edu.cn tunnel"}, // the first matched rule wins
+		{"example.com:6500", Reject, "port 6000-7000 reject"},
+		{"example.com:443", Direct, ""},
+	} {
+		d := table.Match(c.addr)
+		if d.Action != c.action || d.Rule != c.rule {
+			t.Errorf("%s: got %v, want %s by `%s`", c.addr, d, c.action, c.rule)
+		}
+	}
+	if d := DefaultTable.Match("example.com:443"); d.Action != Tunnel {
+		t.Errorf("default table: got %v, want tunnel", d)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, rules := range []string{
+		"suffix smu.edu.cn",
+		"suffix smu.edu.cn proxy",
+		"host smu.edu.cn tunnel",
+		"cidr 10.0.0.0/40 tunnel",
+		"port 70000 direct",
+		"port 7000-6000 direct",
+		"default",
+	} {
+		if _, err := Parse(strings.NewReader(rules)); err == nil {
+			t.Errorf("rules %q should be rejected", rules)
+		}
+	}
+}
+
+func TestRouterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules")
+	if err := os.WriteFile(path, []byte("default direct\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r.Route("example.com:443"); d.Action != Direct {
+		t.Errorf("got %v, want direct", d)
+	}
+	if reloaded, err := r.Reload(); reloaded || err != nil {
+		t.Errorf("unchanged file is reloaded: %v %v", reloaded, err)
+	}
+
+	// bad rules are not loaded
+	if err := os.WriteFile(path, []byte("default proxy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	if _, err := r.Reload(); err == nil {
+		t.Error("expect error for bad rules")
+	}
+	if d := r.Route("example.com:443"); d.Action != Direct {
+		t.Errorf("old rules are not kept: got %v", d)
+	}
+
+	if err := os.WriteFile(path, []byte("default reject\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	if reloaded, err := r.Reload(); !reloaded || err != nil {
+		t.Fatalf("changed file is not reloaded: %v %v", reloaded, err)
+	}
+	if d := r.Route("example.com:443"); d.Action != Reject {
+		t.Errorf("got %v, want reject", d)
+	}
+
+	var nilRouter *Router
+	if d := nilRouter.Route("example.com:443"); d.Action != Tunnel {
+		t.Errorf("nil router: got %v, want tunnel", d)
+	}
+}
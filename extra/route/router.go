@@ -0,0 +1,95 @@
+package route
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchInterval is the default interval of checking the rules file for changes.
+const WatchInterval = 2 * time.Second
+
+// Router routes connections by the rules in a file, the rules can be reloaded while routing.
+// A nil Router routes all connections through the tunnel.
+type Router struct {
+	path  string
+	table atomic.Pointer[Table]
+	lock  sync.Mutex // lock for modTime and size
+	// modification time and size of the loaded file, for detecting changes.
+	modTime time.Time
+	size    int64
+}
+
+// Load creates a router with the rules in file.
+func Load(path string) (*Router, error) {
+	r := &Router{path: path}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Path returns the path of rules file.
+func (r *Router) Path() string {
+	return r.path
+}
+
+// Route routes the connection to addr (host:port).
+func (r *Router) Route(addr string) Decision {
+	if r == nil {
+		return DefaultTable.Match(addr)
+	}
+	return r.table.Load().Match(addr)
+}
+
+// Reload loads the rules file again if it is changed since last loading.
+// It returns true if the rules are reloaded. The old rules are kept if the new file is bad.
+func (r *Router) Reload() (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, err
+	}
+	if r.table.Load() != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return false, nil
+	}
+	t, err := ParseFile(r.path)
+	if err != nil {
+		return false, err
+	}
+	r.table.Store(t)
+	r.modTime, r.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+// Watch reloads the rules file every interval if it is changed, until ctx is canceled.
+func (r *Router) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		reloaded, err := r.Reload()
+		if err != nil {
+			// log the same error only once, e.g. while the file is being edited.
+			if err.Error() != lastErr {
+				log.WithError(err).WithField("file", r.path).Warning("failed to reload routing rules, the old rules are kept.")
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if reloaded {
+			log.WithField("file", r.path).Info("routing rules reloaded.")
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package extra
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/genshen/wssocks/wss"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/route"
+	log "github.com/sirupsen/logrus"
+)
+
+// directDialTimeout is the timeout of connecting to the target of a direct connection.
+const directDialTimeout = 10 * time.Second
+
+// replies to the local socks5/https clients, the socks5 replies have a zero ipv4 bound address.
+var (
+	socks5Succeeded   = []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+	socks5NotAllowed  = []byte{0x05, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0} // connection not allowed by ruleset
+	socks5Unreachable = []byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0} // host unreachable
+	httpsSucceeded    = []byte("HTTP/1.0 200 Connection Established\r\nProxy-agent: wssocks\r\n\r\n")
+	httpsNotAllowed   = []byte("HTTP/1.0 403 Forbidden\r\nProxy-agent: wssocks\r\n\r\n")
+	httpsUnreachable  = []byte("HTTP/1.0 502 Bad Gateway\r\nProxy-agent: wssocks\r\n\r\n")
+)
+
+// reply writes the socks5 or https reply to the local connection by proxyType.
+func reply(conn net.Conn, proxyType int, socks5, https []byte) error {
+	data := socks5
+	if proxyType == wss.ProxyTypeHttps {
+		data = https
+	}
+	_, err := conn.Write(data)
+	return err
+}
+
+// replyRejected tells the local client that the connection is rejected.
+func replyRejected(conn net.Conn, proxyType int) {
+	reply(conn, proxyType, socks5NotAllowed, httpsNotAllowed)
+}
+
+// transDirect connects to addr from local machine, and copies data between it and the local connection.
+func transDirect(conn net.Conn, proxyType int, addr string) error {
+	target, err := net.DialTimeout("tcp", addr, directDialTimeout)
+	if err != nil {
+		reply(conn, proxyType, socks5Unreachable, httpsUnreachable)
+		return err
+	}
+	defer target.Close()
+	if err := reply(conn, proxyType, socks5Succeeded, httpsSucceeded); err != nil {
+		return err
+	}
+
+	done := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(target, conn)
+		done <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, target)
+		done <- err
+	}()
+	// the other copying ends when both connections are closed by the caller.
+	return <-done
+}
+
+// directTransport sends http requests of direct connections, without any proxy.
+var directTransport = &http.Transport{
+	DialContext:           (&net.Dialer{Timeout: directDialTimeout}).DialContext,
+	MaxIdleConns:          16,
+	IdleConnTimeout:       time.Minute,
+	ResponseHeaderTimeout: time.Minute,
+}
+
+// hopHeaders are the hop-by-hop headers removed before sending direct http requests.
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// routedHttpProxy routes the requests of http proxy, requests through the tunnel are handled by tunnel.
+type routedHttpProxy struct {
+	tunnel http.Handler
+	router *route.Router
+}
+
+func (p *routedHttpProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !req.URL.IsAbs() { // not a proxy request, the tunnel handler refuses it.
+		p.tunnel.ServeHTTP(w, req)
+		return
+	}
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		addr = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	switch d := p.router.Route(addr); d.Action {
+	case route.Reject:
+		log.WithField("address", addr).WithField("rule", d.Rule).Debug("request is rejected by routing rule.")
+		http.Error(w, "rejected by routing rule", http.StatusForbidden)
+	case route.Direct:
+		p.direct(w, req)
+	default:
+		p.tunnel.ServeHTTP(w, req)
+	}
+}
+
+// direct sends the request from local machine, and copies the response back.
+// The body of request and the response are not counted in the statistics of tunnel.
+func (p *routedHttpProxy) direct(w http.ResponseWriter, req *http.Request) {
+	if conn, ok := req.Context().Value(countingConnKey{}).(*countingConn); ok {
+		conn.direct.Store(true)
+		defer func() {
+			// write the buffered response before counting again.
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			conn.direct.Store(false)
+		}()
+	}
+	out := req.Clone(req.Context())
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	resp, err := directTransport.RoundTrip(out)
+	if err != nil {
+		log.WithField("address", req.URL.Host).WithError(err).Debug("direct request error.")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -0,0 +1,63 @@
+package extra
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra/route"
+)
+
+func TestDirectHttpNotCounted(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "direct response")
+	}))
+	defer target.Close()
+
+	path := filepath.Join(t.TempDir(), "rules")
+	if err := os.WriteFile(path, []byte("domain tunnel.test tunnel\ndefault direct\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	router, err := route.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats statsCounter
+	stats.reset()
+	tunnel := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "tunnel response")
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: &routedHttpProxy{tunnel: tunnel, router: router}, ConnContext: withCountingConn}
+	go server.Serve(&countingListener{Listener: l, stats: &stats})
+	defer server.Close()
+
+	proxyUrl, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	get := func(u string) {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	get(target.URL)
+	if down := stats.snapshot().BytesDown; down != 0 {
+		t.Errorf("the response of direct request should not be counted, got %d bytes", down)
+	}
+	// the same local connection is reused by the tunnelled request.
+	get("http://tunnel.test/")
+	if down := stats.snapshot().BytesDown; down == 0 {
+		t.Error("the response of tunnelled request should be counted")
+	}
+}
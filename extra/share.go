@@ -0,0 +1,396 @@
+package extra
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/genshen/wssocks/wss"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sharing is the access control of local listeners, for sharing the tunnel with other machines in LAN
+// (e.g. the socks5 address is 0.0.0.0:1080). The connections from loopback are always allowed without authentication.
+type Sharing struct {
+	Allow          []*net.IPNet      // networks of clients allowed to connect, besides loopback
+	Users          map[string]string // username to password of socks5 and http(s) proxy, empty to disable authentication
+	MaxConnections int               // max active connections of each client (ip), 0 for unlimited
+}
+
+// ErrAuthFailed is returned if the username or password of a proxy request is wrong.
+var ErrAuthFailed = errors.New("proxy authentication failed")
+
+// ParseSharing parses the allowed networks (CIDRs or IPs) and users (username:password).
+// The username can not contain ":", while the password can contain any characters.
+func ParseSharing(allow, users []string, maxConnections int) (*Sharing, error) {
+	s := &Sharing{Users: make(map[string]string), MaxConnections: maxConnections}
+	for _, item := range allow {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("bad network `%s` of sharing", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			s.Allow = append(s.Allow, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("bad network `%s` of sharing", item)
+		}
+		s.Allow = append(s.Allow, network)
+	}
+	if len(s.Allow) == 0 {
+		return nil, errors.New("allowed networks of sharing are required")
+	}
+	for _, item := range users {
+		name, password, ok := strings.Cut(item, ":")
+		if !ok || name == "" || password == "" {
+			return nil, fmt.Errorf("bad user `%s` of sharing, username:password is required", name)
+		}
+		s.Users[name] = password
+	}
+	if maxConnections < 0 {
+		return nil, fmt.Errorf("bad max connections %d of sharing", maxConnections)
+	}
+	return s, nil
+}
+
+// SplitAllow splits the allowed networks by commas and new lines, the spaces and empty items are removed.
+func SplitAllow(s string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SplitUsers splits the users (username:password) joined by JoinUsers, e.g. the value of --share-users.
+// The users are separated by commas or new lines, and a backslash escapes the next character (e.g. `\,` in password).
+func SplitUsers(s string) []string {
+	var users []string
+	var item strings.Builder
+	add := func() {
+		if user := strings.TrimSpace(item.String()); user != "" {
+			users = append(users, user)
+		}
+		item.Reset()
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			item.WriteByte(s[i])
+		case c == ',' || c == '\n':
+			add()
+		default:
+			item.WriteByte(c)
+		}
+	}
+	add()
+	return users
+}
+
+// JoinUsers joins the users by commas, the commas and backslashes in them are escaped. It is reversed by SplitUsers.
+func JoinUsers(users []string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, ",", `\,`)
+	items := make([]string, 0, len(users))
+	for _, user := range users {
+		items = append(items, escaper.Replace(user))
+	}
+	return strings.Join(items, ",")
+}
+
+// Allowed returns true if the client of ip is allowed to connect.
+func (s *Sharing) Allowed(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, network := range s.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authRequired returns true if the client of ip must be authenticated.
+func (s *Sharing) authRequired(ip net.IP) bool {
+	return s != nil && len(s.Users) != 0 && !ip.IsLoopback()
+}
+
+// checkUser returns true if the username and password match one of the users.
+func (s *Sharing) checkUser(name, password string) bool {
+	expected, ok := s.Users[name]
+	return ok && subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+// checkProxyAuthorization checks the basic credentials in Proxy-Authorization header.
+func (s *Sharing) checkProxyAuthorization(header http.Header) bool {
+	scheme, credentials, _ := strings.Cut(header.Get("Proxy-Authorization"), " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return false
+	}
+	name, password, _ := strings.Cut(string(decoded), ":")
+	return s.checkUser(name, password)
+}
+
+// remoteIP returns the ip of the remote address of conn, nil if it is not a tcp connection.
+func remoteIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
+}
+
+// sharingListener accepts the connections from allowed clients, and limits the active connections of each client.
+type sharingListener struct {
+	net.Listener
+	sharing *Sharing
+	stats   *statsCounter
+	lock    sync.Mutex
+	active  map[string]int // active connections by client ip
+}
+
+func newSharingListener(l net.Listener, sharing *Sharing, stats *statsCounter) net.Listener {
+	if sharing == nil {
+		return l
+	}
+	return &sharingListener{Listener: l, sharing: sharing, stats: stats, active: make(map[string]int)}
+}
+
+func (l *sharingListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteIP(c.RemoteAddr())
+		if ip == nil {
+			return c, nil
+		}
+		client := ip.String()
+		if !l.sharing.Allowed(ip) {
+			log.WithField("client", client).Debug("connection is rejected, the client is not allowed.")
+			l.stats.onClientRejected(client)
+			c.Close()
+			continue
+		}
+		l.lock.Lock()
+		if l.sharing.MaxConnections > 0 && l.active[client] >= l.sharing.MaxConnections {
+			l.lock.Unlock()
+			log.WithField("client", client).Debug("connection is rejected, too many connections of the client.")
+			l.stats.onClientRejected(client)
+			c.Close()
+			continue
+		}
+		l.active[client]++
+		l.lock.Unlock()
+		l.stats.onClientConn(client, true)
+		return &sharedConn{Conn: c, client: client, listener: l}, nil
+	}
+}
+
+// release is called when a connection of client is closed.
+func (l *sharingListener) release(client string) {
+	l.lock.Lock()
+	if l.active[client]--; l.active[client] <= 0 {
+		delete(l.active, client)
+	}
+	l.lock.Unlock()
+	l.stats.onClientConn(client, false)
+}
+
+// sharedConn is a connection accepted by sharingListener.
+type sharedConn struct {
+	net.Conn
+	client   string // ip of client
+	listener *sharingListener
+	once     sync.Once
+}
+
+func (c *sharedConn) Close() error {
+	c.once.Do(func() { c.listener.release(c.client) })
+	return c.Conn.Close()
+}
+
+// acceptRequest parses the socks5 or https (if enableHttp) proxy request of conn with authentication,
+// and returns the proxy type and target address. The replies of success are sent by wssocks server.
+func (s *Sharing) acceptRequest(conn net.Conn, enableHttp bool) (int, string, error) {
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		return 0, "", err
+	}
+	var proxyType int
+	var addr string
+	if first[0] == 0x05 {
+		proxyType, addr, err = wss.ProxyTypeSocks5, "", s.socks5Auth(br, conn)
+		if err == nil {
+			addr, err = socks5Request(br, conn)
+		}
+	} else if enableHttp {
+		proxyType = wss.ProxyTypeHttps
+		addr, err = s.connectRequest(br, conn)
+	} else {
+		return 0, "", errors.New("only socks5 or http(s) proxy")
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	// the clients wait for the reply before sending data.
+	if br.Buffered() != 0 {
+		return 0, "", errors.New("unexpected data before the connection is established")
+	}
+	return proxyType, addr, nil
+}
+
+// socks5Auth negotiates username/password authentication (RFC 1929).
+func (s *Sharing) socks5Auth(r *bufio.Reader, w io.Writer) error {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return err
+	}
+	if !strings.ContainsRune(string(methods), 0x02) {
+		w.Write([]byte{0x05, 0xff}) // no acceptable methods
+		return errors.New("username/password authentication is not supported by socks5 client")
+	}
+	if _, err := w.Write([]byte{0x05, 0x02}); err != nil {
+		return err
+	}
+
+	readField := func() (string, error) {
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return string(b), err
+	}
+	if version, err := r.ReadByte(); err != nil {
+		return err
+	} else if version != 0x01 {
+		return errors.New("bad version of socks5 authentication")
+	}
+	name, err := readField()
+	if err != nil {
+		return err
+	}
+	password, err := readField()
+	if err != nil {
+		return err
+	}
+	if !s.checkUser(name, password) {
+		w.Write([]byte{0x01, 0x01})
+		return ErrAuthFailed
+	}
+	_, err = w.Write([]byte{0x01, 0x00})
+	return err
+}
+
+// socks5Request reads the connect request of socks5 and returns the target address.
+func socks5Request(r *bufio.Reader, w io.Writer) (string, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", err
+	}
+	if header[1] != 0x01 {
+		w.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // command not supported
+		return "", fmt.Errorf("unsupported socks5 command %d", header[1])
+	}
+	var host string
+	switch header[3] {
+	case 0x01, 0x04:
+		ip := make([]byte, net.IPv4len)
+		if header[3] == 0x04 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		domain := make([]byte, n)
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errors.New("bad address type of socks5 request")
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// httpsAuthRequired is the reply of https proxy requests without valid credentials.
+var httpsAuthRequired = []byte("HTTP/1.1 407 Proxy Authentication Required\r\n" +
+	"Proxy-Authenticate: Basic realm=\"wssocks\"\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+
+// connectRequest reads the CONNECT request of https proxy, checks the credentials and returns the target address.
+func (s *Sharing) connectRequest(r *bufio.Reader, w io.Writer) (string, error) {
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return "", err
+	}
+	if req.Method != http.MethodConnect {
+		return "", fmt.Errorf("unsupported method %s of https proxy", req.Method)
+	}
+	if !s.checkProxyAuthorization(req.Header) {
+		w.Write(httpsAuthRequired)
+		return "", ErrAuthFailed
+	}
+	if _, _, err := net.SplitHostPort(req.Host); err != nil {
+		return net.JoinHostPort(req.Host, "443"), nil
+	}
+	return req.Host, nil
+}
+
+// sharingHttpHandler checks the credentials of http proxy requests.
+type sharingHttpHandler struct {
+	sharing *Sharing
+	next    http.Handler
+}
+
+func (h *sharingHttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if h.sharing.authRequired(net.ParseIP(host)) {
+		if !h.sharing.checkProxyAuthorization(r.Header) {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="wssocks"`)
+			http.Error(w, ErrAuthFailed.Error(), http.StatusProxyAuthRequired)
+			return
+		}
+	}
+	// the credentials are not sent to the target.
+	r.Header.Del("Proxy-Authorization")
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,264 @@
+package extra
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// pipeListener accepts the server side of pipes, whose remote address is set by dial.
+type pipeListener struct {
+	conns chan net.Conn
+}
+
+// addrConn is a pipe with the remote address of a tcp client.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return c, nil
+}
+
+func (l *pipeListener) Close() error   { return nil }
+func (l *pipeListener) Addr() net.Addr { return &net.TCPAddr{IP: net.IPv4zero, Port: 1080} }
+
+// dial returns the client side of a connection from ip, the server side is accepted by l.
+func (l *pipeListener) dial(ip string) net.Conn {
+	client, server := net.Pipe()
+	l.conns <- &addrConn{Conn: server, remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+	return client
+}
+
+// accept accepts a connection from l, it fails if no connection is accepted in a second.
+func accept(t *testing.T, l net.Listener) net.Conn {
+	t.Helper()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	select {
+	case c := <-accepted:
+		return c
+	case <-time.After(time.Second):
+		t.Fatal("no connection is accepted")
+		return nil
+	}
+}
+
+// closedByServer returns true if c is closed by the other side.
+func closedByServer(c net.Conn) bool {
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := c.Read(make([]byte, 1))
+	return errors.Is(err, io.EOF)
+}
+
+func TestSharingListener(t *testing.T) {
+	sharing, err := ParseSharing([]string{"192.168.1.0/24"}, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats statsCounter
+	stats.reset()
+	pl := &pipeListener{conns: make(chan net.Conn, 8)}
+	l := newSharingListener(pl, sharing, &stats)
+
+	// the client outside of the allowed networks is refused.
+	refused := pl.dial("10.0.0.5")
+	first := pl.dial("192.168.1.2")
+	accepted := accept(t, l)
+	if accepted.RemoteAddr().String() != "192.168.1.2:40000" {
+		t.Error("unexpected client", accepted.RemoteAddr())
+	}
+	if !closedByServer(refused) {
+		t.Error("the client outside of allowed networks should be refused")
+	}
+
+	// the second connection of the same client exceeds the limit.
+	second := pl.dial("192.168.1.2")
+	local := pl.dial("127.0.0.1")
+	if c := accept(t, l); c.RemoteAddr().String() != "127.0.0.1:40000" {
+		t.Fatal("the loopback client should be accepted, got", c.RemoteAddr())
+	}
+	if !closedByServer(second) {
+		t.Error("the connection over the limit should be refused")
+	}
+	// the client can connect again after its connection is closed.
+	accepted.Close()
+	third := pl.dial("192.168.1.2")
+	if c := accept(t, l); c.RemoteAddr().String() != "192.168.1.2:40000" {
+		t.Fatal("the client should be accepted after closing, got", c.RemoteAddr())
+	}
+
+	clients := stats.snapshot().Clients
+	if c := clients["192.168.1.2"]; c.Rejected != 1 || c.TotalConnections != 2 || c.ActiveConnections != 1 {
+		t.Errorf("unexpected stats of allowed client: %+v", c)
+	}
+	if c := clients["10.0.0.5"]; c.Rejected != 1 || c.TotalConnections != 0 {
+		t.Errorf("unexpected stats of refused client: %+v", c)
+	}
+	for _, c := range []net.Conn{first, local, third} {
+		c.Close()
+	}
+}
+
+// testSharingUsers has a password with commas and colons.
+var testSharingUsers = []string{"bob:a,b:c"}
+
+func TestSharingSocks5Auth(t *testing.T) {
+	sharing, err := ParseSharing([]string{"192.168.1.0/24"}, testSharingUsers, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password string
+		reply    []byte // reply of authentication
+		err      error
+	}{
+		{"a,b:c", []byte{0x01, 0x00}, nil},
+		{"a,b", []byte{0x01, 0x01}, ErrAuthFailed},
+	}
+	for _, test := range tests {
+		client, server := net.Pipe()
+		server.SetDeadline(time.Now().Add(5 * time.Second))
+		type result struct {
+			addr string
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			_, addr, err := sharing.acceptRequest(server, false)
+			server.Close()
+			done <- result{addr, err}
+		}()
+
+		reply := make([]byte, 2)
+		client.Write([]byte{0x05, 0x01, 0x02}) // username/password authentication
+		if _, err := io.ReadFull(client, reply); err != nil || reply[1] != 0x02 {
+			t.Fatalf("username/password authentication should be selected: %v %v", reply, err)
+		}
+		auth := append([]byte{0x01, 3}, "bob"...)
+		auth = append(append(auth, byte(len(test.password))), test.password...)
+		client.Write(auth)
+		if _, err := io.ReadFull(client, reply); err != nil || reply[0] != test.reply[0] || reply[1] != test.reply[1] {
+			t.Errorf("password %q: got reply %v, want %v", test.password, reply, test.reply)
+		}
+		if test.err == nil {
+			// connect to lab.smu.edu.cn:22
+			request := append([]byte{0x05, 0x01, 0x00, 0x03, 14}, "lab.smu.edu.cn"...)
+			client.Write(append(request, 0, 22))
+		}
+		r := <-done
+		if !errors.Is(r.err, test.err) {
+			t.Errorf("password %q: got error %v, want %v", test.password, r.err, test.err)
+		}
+		if test.err == nil && r.addr != "lab.smu.edu.cn:22" {
+			t.Error("unexpected target address", r.addr)
+		}
+		client.Close()
+	}
+
+	// the client without username/password authentication is refused.
+	client, server := net.Pipe()
+	go sharing.acceptRequest(server, false)
+	client.Write([]byte{0x05, 0x01, 0x00})
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != 0xff {
+		t.Errorf("no acceptable methods should be replied: %v %v", reply, err)
+	}
+	client.Close()
+}
+
+func TestSharingHttpsAuth(t *testing.T) {
+	sharing, err := ParseSharing([]string{"192.168.1.0/24"}, testSharingUsers, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, credentials := range []string{"", "bob:a,b", "bob:a,b:c"} {
+		client, server := net.Pipe()
+		server.SetDeadline(time.Now().Add(5 * time.Second))
+		done := make(chan error, 1)
+		var addr string
+		go func() {
+			var err error
+			_, addr, err = sharing.acceptRequest(server, true)
+			server.Close()
+			done <- err
+		}()
+		request := "CONNECT lab.smu.edu.cn:443 HTTP/1.1\r\nHost: lab.smu.edu.cn:443\r\n"
+		if credentials != "" {
+			request += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)) + "\r\n"
+		}
+		go client.Write([]byte(request + "\r\n"))
+
+		if credentials == "bob:a,b:c" {
+			if err := <-done; err != nil || addr != "lab.smu.edu.cn:443" {
+				t.Errorf("the request with right password should be accepted: %v %s", err, addr)
+			}
+		} else {
+			resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+			if err != nil || resp.StatusCode != http.StatusProxyAuthRequired {
+				t.Errorf("credentials %q: expected 407 response, got %v %v", credentials, resp, err)
+			}
+			if err := <-done; !errors.Is(err, ErrAuthFailed) {
+				t.Errorf("credentials %q: got error %v, want %v", credentials, err, ErrAuthFailed)
+			}
+		}
+		client.Close()
+	}
+}
+
+func TestSharingHttpHandler(t *testing.T) {
+	sharing, err := ParseSharing([]string{"192.168.1.0/24"}, testSharingUsers, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var forwarded *http.Request
+	handler := &sharingHttpHandler{sharing: sharing, next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	})}
+	tests := []struct {
+		remote      string
+		credentials string
+		status      int
+	}{
+		{"192.168.1.2:40000", "", http.StatusProxyAuthRequired},
+		{"192.168.1.2:40000", "bob:wrong", http.StatusProxyAuthRequired},
+		{"192.168.1.2:40000", "bob:a,b:c", http.StatusOK},
+		{"127.0.0.1:40000", "", http.StatusOK}, // loopback clients are not authenticated
+	}
+	for _, test := range tests {
+		forwarded = nil
+		r := httptest.NewRequest(http.MethodGet, "http://lab.smu.edu.cn/", nil)
+		r.RemoteAddr = test.remote
+		if test.credentials != "" {
+			r.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(test.credentials)))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %q: got status %d, want %d", test.remote, test.credentials, w.Code, test.status)
+		}
+		if (forwarded != nil) != (test.status == http.StatusOK) {
+			t.Errorf("%s %q: the request should be forwarded only if it is authenticated", test.remote, test.credentials)
+		}
+		if forwarded != nil && forwarded.Header.Get("Proxy-Authorization") != "" {
+			t.Error("the credentials should not be sent to the target")
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package socks5 is a minimal socks5 client (connect command without authentication) for the built-in tools,
+// e.g. the nc sub-command used as ssh ProxyCommand.
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	version        = 0x05
+	methodNoAuth   = 0x00
+	cmdConnect     = 0x01
+	atypIPv4       = 0x01
+	atypDomain     = 0x03
+	atypIPv6       = 0x04
+	replySucceeded = 0x00
+)
+
+// replyErrors are the messages of socks5 reply codes.
+var replyErrors = map[byte]string{
+	0x01: "general socks server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "ttl expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// Dial connects to addr (host:port) through the socks5 proxy.
+func Dial(ctx context.Context, proxy, addr string) (net.Conn, error) {
+	req, err := connectRequest(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxy)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := handshake(conn, req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5 proxy %s: %w", proxy, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connectRequest builds the connect request of addr.
+func connectRequest(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port `%s`", portStr)
+	}
+	req := []byte{version, cmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("bad host `%s`", host)
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, atypIPv4), ip4...)
+	} else {
+		req = append(append(req, atypIPv6), ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(req, uint16(port)), nil
+}
+
+// handshake negotiates no authentication and sends the connect request, then reads the reply.
+func handshake(conn net.Conn, req []byte) error {
+	if _, err := conn.Write([]byte{version, 1, methodNoAuth}); err != nil {
+		return err
+	}
+	var buf [4]byte
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != version || buf[1] != methodNoAuth {
+		return errors.New("no acceptable authentication method")
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("connection is closed by proxy, the target may be unreachable")
+		}
+		return err
+	}
+	if buf[0] != version {
+		return errors.New("bad reply version")
+	}
+	if buf[1] != replySucceeded {
+		if msg, ok := replyErrors[buf[1]]; ok {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("unknown reply code %d", buf[1])
+	}
+	// skip the bound address and port.
+	var n int
+	switch buf[3] {
+	case atypIPv4:
+		n = net.IPv4len
+	case atypIPv6:
+		n = net.IPv6len
+	case atypDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		n = int(buf[0])
+	default:
+		return errors.New("bad address type in reply")
+	}
+	_, err := io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
+
+// Relay copies data between conn and in/out (e.g. stdin and stdout), until the remote side closes conn.
+// conn is not half-closed when in reaches EOF, because the tunnel closes the whole connection on it.
+// conn is closed when Relay returns.
+func Relay(conn net.Conn, in io.Reader, out io.Writer) error {
+	defer conn.Close()
+	go io.Copy(conn, in)
+	_, err := io.Copy(out, conn)
+	return err
+}
@@ -0,0 +1,96 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveEcho runs a socks5 server accepting one connection, which replies with code,
+// and echoes the first read data and closes if the connection succeeded. The requested address is sent to addrs.
+func serveEcho(t *testing.T, code byte, addrs chan<- []byte) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 262)
+		if _, err := io.ReadFull(conn, buf[:3]); err != nil {
+			return
+		}
+		conn.Write([]byte{version, methodNoAuth})
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		addrs <- append([]byte(nil), buf[3:n]...)
+		conn.Write([]byte{version, code, 0, atypIPv4, 0, 0, 0, 0, 0, 0})
+		if code == replySucceeded {
+			if n, err := conn.Read(buf); err == nil {
+				conn.Write(buf[:n])
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestConnectRequest(t *testing.T) {
+	for addr, expected := range map[string][]byte{
+		"lab.smu.edu.cn:22": append(append([]byte{version, cmdConnect, 0, atypDomain, 14}, "lab.smu.edu.cn"...), 0, 22),
+		"10.1.2.3:443":      {version, cmdConnect, 0, atypIPv4, 10, 1, 2, 3, 1, 0xbb},
+		"[::1]:80":          {version, cmdConnect, 0, atypIPv6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 80},
+	} {
+		req, err := connectRequest(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(req, expected) {
+			t.Errorf("request of %s: got %v, want %v", addr, req, expected)
+		}
+	}
+	for _, bad := range []string{"host", "host:port", "host:70000"} {
+		if _, err := connectRequest(bad); err == nil {
+			t.Errorf("address %q should be rejected", bad)
+		}
+	}
+}
+
+func TestRelay(t *testing.T) {
+	addrs := make(chan []byte, 1)
+	proxy := serveEcho(t, replySucceeded, addrs)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := Dial(ctx, proxy, "lab.smu.edu.cn:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Relay(conn, bytes.NewReader([]byte("SSH-2.0-test\r\n")), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "SSH-2.0-test\r\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if addr := <-addrs; !bytes.Equal(addr, append(append([]byte{atypDomain, 14}, "lab.smu.edu.cn"...), 0, 22)) {
+		t.Errorf("unexpected requested address %v", addr)
+	}
+}
+
+func TestDialRejected(t *testing.T) {
+	proxy := serveEcho(t, 0x02, make(chan []byte, 1))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Dial(ctx, proxy, "ads.example.com:443"); err == nil {
+		t.Error("expect error for rejected connection")
+	}
+}
@@ -0,0 +1,317 @@
+package extra
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/genshen/wssocks/wss"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/passwd"
+)
+
+// Stats is a snapshot of traffic and connection statistics of a client.
+type Stats struct {
+	BytesUp           uint64 // bytes sent by local applications (to the tunnel)
+	BytesDown         uint64 // bytes received by local applications (from the tunnel)
+	ActiveConnections uint64 // proxied connections which are active now
+	TotalConnections  uint64 // proxied connections since the client is started
+	Reconnects        uint64 // reconnecting times of the websocket connection
+	Since             time.Time
+	SessionSince      time.Time // time when current connection is connected, zero if it is not connected
+	// LoginAttempts are the numbers of vpn password login attempts by result.
+	LoginAttempts  map[passwd.LoginResult]uint64
+	CaptchaRetries uint64
+	// Destinations are the statistics of each target host (address with port) of proxied connections.
+	Destinations map[string]DestinationStats
+	// Clients are the statistics of each client (ip) of local listeners, they are only counted in sharing mode.
+	Clients map[string]ClientStats
+}
+
+// DestinationStats is the statistics of a target host.
+type DestinationStats struct {
+	ActiveConnections uint64
+	TotalConnections  uint64
+	BytesUp           uint64 // bytes of http(s) proxy requests are not counted for destinations
+	BytesDown         uint64
+}
+
+// ClientStats is the statistics of a client connecting to the local listeners.
+type ClientStats struct {
+	ActiveConnections uint64
+	TotalConnections  uint64
+	Rejected          uint64 // connections rejected by the allowed networks or connection limit
+	BytesUp           uint64
+	BytesDown         uint64
+}
+
+// statsCounter counts the traffic and connections of a client.
+type statsCounter struct {
+	bytesUp    atomic.Uint64
+	bytesDown  atomic.Uint64
+	reconnects atomic.Uint64
+	captchas   atomic.Uint64 // login attempts with a new captcha after a wrong captcha
+
+	lock          sync.Mutex
+	active        uint64
+	total         uint64
+	since         time.Time
+	sessionSince  time.Time
+	loginAttempts map[passwd.LoginResult]uint64
+	lastLogin     passwd.LoginResult // result of the last login attempt
+	destinations  map[string]*DestinationStats
+	clients       map[string]*ClientStats
+}
+
+func (s *statsCounter) reset() {
+	s.bytesUp.Store(0)
+	s.bytesDown.Store(0)
+	s.reconnects.Store(0)
+	s.captchas.Store(0)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// the active connections are not reset: the connections opened before
+	// are still counted when they are closed, which may be after the restart.
+	s.total = 0
+	s.since = time.Now()
+	s.sessionSince = time.Time{}
+	s.loginAttempts = make(map[passwd.LoginResult]uint64)
+	s.lastLogin = ""
+	destinations := make(map[string]*DestinationStats)
+	for address, dest := range s.destinations {
+		if dest.ActiveConnections != 0 {
+			destinations[address] = &DestinationStats{ActiveConnections: dest.ActiveConnections}
+		}
+	}
+	s.destinations = destinations
+	clients := make(map[string]*ClientStats)
+	for client, c := range s.clients {
+		if c.ActiveConnections != 0 {
+			clients[client] = &ClientStats{ActiveConnections: c.ActiveConnections}
+		}
+	}
+	s.clients = clients
+}
+
+// onConnChange is set as the OnChange of connection record, it is called if a proxied connection is added or removed.
+func (s *statsCounter) onConnChange(status wss.ConnStatus) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dest := s.destination(status.Address)
+	if status.IsNew {
+		s.active++
+		s.total++
+		dest.ActiveConnections++
+		dest.TotalConnections++
+	} else {
+		s.active--
+		dest.ActiveConnections--
+	}
+}
+
+// destination returns the statistics of address. The lock must be held.
+func (s *statsCounter) destination(address string) *DestinationStats {
+	if s.destinations == nil {
+		s.destinations = make(map[string]*DestinationStats)
+	}
+	dest, ok := s.destinations[address]
+	if !ok {
+		dest = &DestinationStats{}
+		s.destinations[address] = dest
+	}
+	return dest
+}
+
+// client returns the statistics of client. The lock must be held.
+func (s *statsCounter) client(client string) *ClientStats {
+	if s.clients == nil {
+		s.clients = make(map[string]*ClientStats)
+	}
+	c, ok := s.clients[client]
+	if !ok {
+		c = &ClientStats{}
+		s.clients[client] = c
+	}
+	return c
+}
+
+// addBytes counts the bytes of the connection to address from client, both of them can be empty if unknown.
+func (s *statsCounter) addBytes(address, client string, up, down uint64) {
+	s.bytesUp.Add(up)
+	s.bytesDown.Add(down)
+	if address == "" && client == "" {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if address != "" {
+		dest := s.destination(address)
+		dest.BytesUp += up
+		dest.BytesDown += down
+	}
+	if client != "" {
+		c := s.client(client)
+		c.BytesUp += up
+		c.BytesDown += down
+	}
+}
+
+// onClientConn counts the connections accepted from client in sharing mode.
+func (s *statsCounter) onClientConn(client string, isNew bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.client(client)
+	if isNew {
+		c.ActiveConnections++
+		c.TotalConnections++
+	} else {
+		c.ActiveConnections--
+	}
+}
+
+// onClientRejected counts the connections rejected in sharing mode.
+func (s *statsCounter) onClientRejected(client string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.client(client).Rejected++
+}
+
+// onPhase records the time when the connection is connected.
+func (s *statsCounter) onPhase(phase Phase) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if phase == PhaseConnected {
+		s.sessionSince = time.Now()
+	} else {
+		s.sessionSince = time.Time{}
+	}
+}
+
+// OnLoginAttempt implements passwd.LoginObserver.
+func (s *statsCounter) OnLoginAttempt(result passwd.LoginResult) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.loginAttempts == nil {
+		s.loginAttempts = make(map[passwd.LoginResult]uint64)
+	}
+	s.loginAttempts[result]++
+	// the captcha is answered again after it is rejected in the last login.
+	if s.lastLogin == passwd.LoginWrongCaptcha {
+		s.captchas.Add(1)
+	}
+	s.lastLogin = result
+}
+
+func (s *statsCounter) snapshot() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := Stats{
+		BytesUp:           s.bytesUp.Load(),
+		BytesDown:         s.bytesDown.Load(),
+		ActiveConnections: s.active,
+		TotalConnections:  s.total,
+		Reconnects:        s.reconnects.Load(),
+		Since:             s.since,
+		SessionSince:      s.sessionSince,
+		LoginAttempts:     make(map[passwd.LoginResult]uint64, len(s.loginAttempts)),
+		CaptchaRetries:    s.captchas.Load(),
+		Destinations:      make(map[string]DestinationStats, len(s.destinations)),
+		Clients:           make(map[string]ClientStats, len(s.clients)),
+	}
+	for result, n := range s.loginAttempts {
+		stats.LoginAttempts[result] = n
+	}
+	for address, dest := range s.destinations {
+		stats.Destinations[address] = *dest
+	}
+	for client, c := range s.clients {
+		stats.Clients[client] = *c
+	}
+	return stats
+}
+
+// Stats returns the statistics since the client is started.
+func (h *TaskHandles) Stats() Stats {
+	return h.stats.snapshot()
+}
+
+// countingConn counts bytes read from and written to the local connection.
+type countingConn struct {
+	net.Conn
+	stats   *statsCounter
+	address atomic.Value // target address, it is set after the proxy request is parsed.
+	client  string       // ip of client in sharing mode, empty if it is not counted
+	direct  atomic.Bool  // bytes are not counted while a direct http request is handled
+}
+
+// countingConnKey is the context key of the countingConn of a http proxy request.
+type countingConnKey struct{}
+
+// withCountingConn is the ConnContext of http server, it keeps the countingConn in the context of requests.
+func withCountingConn(ctx context.Context, c net.Conn) context.Context {
+	if conn, ok := c.(*countingConn); ok {
+		return context.WithValue(ctx, countingConnKey{}, conn)
+	}
+	return ctx
+}
+
+func newCountingConn(c net.Conn, stats *statsCounter) *countingConn {
+	conn := &countingConn{Conn: c, stats: stats}
+	if sc, ok := c.(*sharedConn); ok {
+		conn.client = sc.client
+	}
+	return conn
+}
+
+func (c *countingConn) target() string {
+	if address, ok := c.address.Load().(string); ok {
+		return address
+	}
+	return ""
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.direct.Load() {
+		c.stats.addBytes(c.target(), c.client, uint64(n), 0)
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if !c.direct.Load() {
+		c.stats.addBytes(c.target(), c.client, 0, uint64(n))
+	}
+	return n, err
+}
+
+// countingListener wraps the accepted connections by countingConn.
+type countingListener struct {
+	net.Listener
+	stats *statsCounter
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newCountingConn(conn, l.stats), nil
+}
+
+// FormatBytes formats the size in bytes with binary unit, e.g. 1.5 MiB.
+func FormatBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,34 @@
+package extra
+
+import (
+	"testing"
+
+	"github.com/genshen/wssocks/wss"
+)
+
+func TestStatsCloseAfterRestart(t *testing.T) {
+	var s statsCounter
+	s.reset()
+	s.onConnChange(wss.ConnStatus{Address: "lab.smu.edu.cn:22", IsNew: true})
+	s.onClientConn("192.168.1.2", true)
+	s.addBytes("lab.smu.edu.cn:22", "192.168.1.2", 10, 20)
+
+	// the client is restarted, then the connection opened before is closed.
+	s.reset()
+	if stats := s.snapshot(); stats.ActiveConnections != 1 || stats.TotalConnections != 0 || stats.BytesUp != 0 {
+		t.Errorf("only the active connections should be kept: %+v", stats)
+	}
+	s.onConnChange(wss.ConnStatus{Address: "lab.smu.edu.cn:22", IsNew: false})
+	s.onClientConn("192.168.1.2", false)
+
+	stats := s.snapshot()
+	if stats.ActiveConnections != 0 {
+		t.Errorf("active connections: got %d, want 0", stats.ActiveConnections)
+	}
+	if dest := stats.Destinations["lab.smu.edu.cn:22"]; dest.ActiveConnections != 0 || dest.BytesDown != 0 {
+		t.Errorf("unexpected destination stats: %+v", dest)
+	}
+	if c := stats.Clients["192.168.1.2"]; c.ActiveConnections != 0 || c.TotalConnections != 0 {
+		t.Errorf("unexpected client stats: %+v", c)
+	}
+}
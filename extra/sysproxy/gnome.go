@@ -0,0 +1,116 @@
+package sysproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gnome is the proxy settings of GNOME (and other desktops using gsettings, e.g. Cinnamon and Budgie).
+// The values are GVariant texts, which are printed by `gsettings get` and accepted by `gsettings set`.
+type gnome struct{}
+
+const (
+	gnomeSchema      = "org.gnome.system.proxy"
+	gnomeSchemaHttp  = gnomeSchema + ".http"
+	gnomeSchemaHttps = gnomeSchema + ".https"
+	gnomeSchemaSocks = gnomeSchema + ".socks"
+)
+
+// gnomeKeys are the keys (schema and key separated by a space) set by gnome.
+var gnomeKeys = []string{
+	gnomeSchema + " mode",
+	gnomeSchema + " autoconfig-url",
+	gnomeSchema + " ignore-hosts",
+	gnomeSchemaHttp + " host",
+	gnomeSchemaHttp + " port",
+	gnomeSchemaHttps + " host",
+	gnomeSchemaHttps + " port",
+	gnomeSchemaSocks + " host",
+	gnomeSchemaSocks + " port",
+}
+
+func (gnome) name() string {
+	return "gnome"
+}
+
+func (gnome) read(r Runner) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, key := range gnomeKeys {
+		schema, k, _ := strings.Cut(key, " ")
+		v, err := r.Output("gsettings", "get", schema, k)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = v
+	}
+	return values, nil
+}
+
+func (gnome) apply(r Runner, s Settings) error {
+	values := map[string]string{gnomeSchema + " ignore-hosts": gvariantStrings(s.Bypass)}
+	if s.PacURL != "" {
+		values[gnomeSchema+" mode"] = gvariantString("auto")
+		values[gnomeSchema+" autoconfig-url"] = gvariantString(s.PacURL)
+	} else {
+		host, port, err := hostPort(s.Socks5Addr)
+		if err != nil {
+			return err
+		}
+		values[gnomeSchema+" mode"] = gvariantString("manual")
+		values[gnomeSchemaSocks+" host"] = gvariantString(host)
+		values[gnomeSchemaSocks+" port"] = port
+		// without http proxy, the applications fall back to socks5 proxy.
+		httpHost, httpPort, httpsHost, httpsPort := "", "0", "", "0"
+		if s.HttpAddr != "" {
+			if httpHost, httpPort, err = hostPort(s.HttpAddr); err != nil {
+				return err
+			}
+			// the https proxy (http CONNECT) shares the socks5 listener.
+			httpsHost, httpsPort = host, port
+		}
+		values[gnomeSchemaHttp+" host"] = gvariantString(httpHost)
+		values[gnomeSchemaHttp+" port"] = httpPort
+		values[gnomeSchemaHttps+" host"] = gvariantString(httpsHost)
+		values[gnomeSchemaHttps+" port"] = httpsPort
+	}
+	// the mode is set at last, after the addresses are ready.
+	for _, key := range append(gnomeKeys[1:], gnomeKeys[0]) {
+		if v, ok := values[key]; ok {
+			if err := gsettingsSet(r, key, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (gnome) restore(r Runner, values map[string]string) error {
+	for _, key := range append(gnomeKeys[1:], gnomeKeys[0]) {
+		if v, ok := values[key]; ok {
+			if err := gsettingsSet(r, key, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func gsettingsSet(r Runner, key, value string) error {
+	schema, k, _ := strings.Cut(key, " ")
+	_, err := r.Output("gsettings", "set", schema, k, value)
+	return err
+}
+
+// gvariantString returns the GVariant text of string s.
+func gvariantString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// gvariantStrings returns the GVariant text of string array.
+func gvariantStrings(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = gvariantString(item)
+	}
+	return fmt.Sprintf("[%s]", strings.Join(quoted, ", "))
+}
@@ -0,0 +1,115 @@
+package sysproxy
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// kde is the proxy settings of KDE Plasma, which are in group "Proxy Settings" of kioslaverc.
+// The tools of Plasma 6 (kreadconfig6, kwriteconfig6) are preferred, then the ones of Plasma 5.
+type kde struct{}
+
+const (
+	kdeFile  = "kioslaverc"
+	kdeGroup = "Proxy Settings"
+
+	kdeProxyTypeManual = "1"
+	kdeProxyTypePac    = "2"
+)
+
+// kdeKeys are the keys set by kde.
+var kdeKeys = []string{"ProxyType", "Proxy Config Script", "NoProxyFor", "httpProxy", "httpsProxy", "socksProxy"}
+
+// kdeVersions are the suffixes of kreadconfig and kwriteconfig.
+var kdeVersions = []string{"6", "5"}
+
+func (kde) name() string {
+	return "kde"
+}
+
+// version returns the suffix of available kreadconfig and kwriteconfig.
+func (kde) version(r Runner) (string, error) {
+	for _, v := range kdeVersions {
+		_, err := r.Output("kreadconfig"+v, "--file", kdeFile, "--group", kdeGroup, "--key", kdeKeys[0])
+		if err == nil || !errors.Is(err, exec.ErrNotFound) {
+			return v, err
+		}
+	}
+	return "", exec.ErrNotFound
+}
+
+func (k kde) read(r Runner) (map[string]string, error) {
+	v, err := k.version(r)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for _, key := range kdeKeys {
+		value, err := r.Output("kreadconfig"+v, "--file", kdeFile, "--group", kdeGroup, "--key", key)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (k kde) apply(r Runner, s Settings) error {
+	values := map[string]string{"NoProxyFor": strings.Join(s.Bypass, ",")}
+	if s.PacURL != "" {
+		values["ProxyType"] = kdeProxyTypePac
+		values["Proxy Config Script"] = s.PacURL
+	} else {
+		host, port, err := hostPort(s.Socks5Addr)
+		if err != nil {
+			return err
+		}
+		values["ProxyType"] = kdeProxyTypeManual
+		values["socksProxy"] = fmt.Sprintf("socks://%s %s", host, port)
+		// without http proxy, the applications fall back to socks5 proxy.
+		values["httpProxy"], values["httpsProxy"] = "", ""
+		if s.HttpAddr != "" {
+			httpHost, httpPort, err := hostPort(s.HttpAddr)
+			if err != nil {
+				return err
+			}
+			values["httpProxy"] = fmt.Sprintf("http://%s %s", httpHost, httpPort)
+			// the https proxy (http CONNECT) shares the socks5 listener.
+			values["httpsProxy"] = fmt.Sprintf("http://%s %s", host, port)
+		}
+	}
+	return k.write(r, values)
+}
+
+func (k kde) restore(r Runner, values map[string]string) error {
+	return k.write(r, values)
+}
+
+// write writes values (the empty values are deleted), and notifies the applications to reload the settings.
+func (k kde) write(r Runner, values map[string]string) error {
+	v, err := k.version(r)
+	if err != nil {
+		return err
+	}
+	// the proxy type is set at last, after the addresses are ready.
+	for _, key := range append(kdeKeys[1:], kdeKeys[0]) {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		args := []string{"--file", kdeFile, "--group", kdeGroup, "--key", key}
+		if value == "" {
+			args = append(args, "--delete")
+		} else {
+			args = append(args, value)
+		}
+		if _, err := r.Output("kwriteconfig"+v, args...); err != nil {
+			return err
+		}
+	}
+	// the running applications (using KIO) reload the settings on this signal, it may fail without dbus session.
+	r.Output("dbus-send", "--type=signal", "/KIO/Scheduler", "org.kde.KIO.Scheduler.reparseSlaveConfiguration", "string:")
+	return nil
+}
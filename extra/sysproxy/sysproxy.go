@@ -0,0 +1,176 @@
+// Package sysproxy sets the proxy settings of Linux desktops (GNOME by gsettings and KDE by kwriteconfig)
+// to the local listeners of client, and restores the previous values.
+//
+// The previous values are saved to a backup file before they are changed, so that they can be restored
+// after a crash (see Proxy.Restore). The commands are run by a Runner, which can be replaced in tests.
+package sysproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoDesktop is returned if none of the supported desktop settings is available.
+var ErrNoDesktop = errors.New("no supported desktop proxy settings (gsettings or kwriteconfig) found")
+
+// Bypass are the hosts not using the proxy by default.
+var Bypass = []string{"localhost", "127.0.0.0/8", "::1"}
+
+// Runner runs a command and returns its output without the trailing new line.
+type Runner interface {
+	Output(name string, args ...string) (string, error)
+}
+
+// ExecRunner runs commands by os/exec.
+type ExecRunner struct{}
+
+func (ExecRunner) Output(name string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// Settings are the proxy settings of desktop.
+type Settings struct {
+	Socks5Addr string   // socks5 (and https) listening address of client, e.g. 127.0.0.1:1080
+	HttpAddr   string   // http listening address, empty if http proxy is disabled
+	PacURL     string   // url of PAC file, it is used instead of the addresses if it is not empty
+	Bypass     []string // hosts not using the proxy, Bypass is used if it is empty
+}
+
+// hostPort splits the listening address, the empty or unspecified host is replaced with loopback.
+func hostPort(addr string) (string, string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return host, port, nil
+}
+
+// desktop is the proxy settings of a desktop environment.
+type desktop interface {
+	// name is the key of values in backup file.
+	name() string
+	// read returns current values, it returns error if the settings are not available.
+	read(r Runner) (map[string]string, error)
+	apply(r Runner, s Settings) error
+	restore(r Runner, values map[string]string) error
+}
+
+var desktops = []desktop{gnome{}, kde{}}
+
+// Proxy sets and restores the desktop proxy settings.
+type Proxy struct {
+	runner Runner
+	path   string // path of backup file
+}
+
+// New returns a Proxy running commands by runner and saving previous values to the backup file of path.
+func New(runner Runner, path string) *Proxy {
+	return &Proxy{runner: runner, path: path}
+}
+
+// DefaultBackupPath returns the path of backup file, which is in the same directory as default configuration file.
+func DefaultBackupPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "wssocks-ustb", "sysproxy.json"), nil
+}
+
+// Set saves current values of available desktops to backup file, and sets the proxy settings to s.
+// If the backup file exists (e.g. Set is called again on reconnecting, or the client crashed),
+// the values in it are kept, so that the values before the first Set are restored.
+func (p *Proxy) Set(s Settings) error {
+	if len(s.Bypass) == 0 {
+		s.Bypass = Bypass
+	}
+	backup, err := p.readBackup()
+	if errors.Is(err, os.ErrNotExist) {
+		backup = make(map[string]map[string]string)
+		for _, d := range desktops {
+			if values, err := d.read(p.runner); err == nil {
+				backup[d.name()] = values
+			}
+		}
+		if len(backup) == 0 {
+			return ErrNoDesktop
+		}
+		err = p.writeBackup(backup)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, d := range desktops {
+		if _, ok := backup[d.name()]; ok {
+			if err := d.apply(p.runner, s); err != nil {
+				return fmt.Errorf("set %s proxy settings: %w", d.name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Restore restores the values in backup file and removes it. It does nothing if the backup file does not exist.
+// It should also be called on starting, to restore the values left by a crash.
+func (p *Proxy) Restore() error {
+	backup, err := p.readBackup()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, d := range desktops {
+		if values, ok := backup[d.name()]; ok {
+			if err := d.restore(p.runner, values); err != nil {
+				// the backup file is kept, so that it can be restored later.
+				return fmt.Errorf("restore %s proxy settings: %w", d.name(), err)
+			}
+		}
+	}
+	return os.Remove(p.path)
+}
+
+func (p *Proxy) readBackup() (map[string]map[string]string, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	var backup map[string]map[string]string
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("bad backup file of proxy settings %s: %w", p.path, err)
+	}
+	return backup, nil
+}
+
+func (p *Proxy) writeBackup(backup map[string]map[string]string) error {
+	data, err := json.Marshal(backup)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(p.path, data, 0600)
+}
@@ -0,0 +1,140 @@
+package sysproxy
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRunner emulates gsettings and kreadconfig/kwriteconfig by a map of values.
+// The tools not installed are not found.
+type fakeRunner struct {
+	installed map[string]bool
+	values    map[string]string // key (schema and key, or kde key) to value
+	failSet   bool
+}
+
+func (f *fakeRunner) Output(name string, args ...string) (string, error) {
+	if !f.installed[name] {
+		return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+	}
+	switch {
+	case name == "gsettings" && args[0] == "get":
+		return f.values[args[1]+" "+args[2]], nil
+	case name == "gsettings" && args[0] == "set":
+		if f.failSet {
+			return "", errors.New("set failed")
+		}
+		f.values[args[1]+" "+args[2]] = args[3]
+	case strings.HasPrefix(name, "kreadconfig"):
+		return f.values[args[5]], nil
+	case strings.HasPrefix(name, "kwriteconfig"):
+		if args[6] == "--delete" {
+			delete(f.values, args[5])
+		} else {
+			f.values[args[5]] = args[6]
+		}
+	}
+	return "", nil
+}
+
+func TestGnome(t *testing.T) {
+	runner := &fakeRunner{installed: map[string]bool{"gsettings": true}, values: map[string]string{
+		gnomeSchema + " mode":           "'none'",
+		gnomeSchema + " ignore-hosts":   "['localhost', '127.0.0.0/8', '::1']",
+		gnomeSchemaSocks + " host":      "'proxy.example.com'",
+		gnomeSchemaSocks + " port":      "1081",
+		gnomeSchema + " autoconfig-url": "''",
+	}}
+	path := filepath.Join(t.TempDir(), "sysproxy.json")
+	p := New(runner, path)
+
+	if err := p.Set(Settings{Socks5Addr: ":1080", HttpAddr: "127.0.0.1:1086"}); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		gnomeSchema + " mode":      "'manual'",
+		gnomeSchemaSocks + " host": "'127.0.0.1'",
+		gnomeSchemaSocks + " port": "1080",
+		gnomeSchemaHttp + " port":  "1086",
+		gnomeSchemaHttps + " port": "1080",
+	} {
+		if got := runner.values[key]; got != want {
+			t.Errorf("%s: got %s, want %s", key, got, want)
+		}
+	}
+
+	// setting again (e.g. reconnected) keeps the values before the first setting.
+	if err := p.Set(Settings{PacURL: "http://127.0.0.1:1087/proxy.pac"}); err != nil {
+		t.Fatal(err)
+	}
+	if runner.values[gnomeSchema+" mode"] != "'auto'" ||
+		runner.values[gnomeSchema+" autoconfig-url"] != "'http://127.0.0.1:1087/proxy.pac'" {
+		t.Errorf("PAC url is not set: %v", runner.values)
+	}
+	if err := p.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if runner.values[gnomeSchema+" mode"] != "'none'" || runner.values[gnomeSchemaSocks+" host"] != "'proxy.example.com'" {
+		t.Errorf("values are not restored: %v", runner.values)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Error("backup file should be removed after restoring")
+	}
+	if err := p.Restore(); err != nil {
+		t.Errorf("restoring without backup file: %v", err)
+	}
+}
+
+func TestKde(t *testing.T) {
+	runner := &fakeRunner{installed: map[string]bool{"kreadconfig5": true, "kwriteconfig5": true},
+		values: map[string]string{"ProxyType": "0"}}
+	path := filepath.Join(t.TempDir(), "sysproxy.json")
+	if err := New(runner, path).Set(Settings{Socks5Addr: "127.0.0.1:1080"}); err != nil {
+		t.Fatal(err)
+	}
+	if runner.values["ProxyType"] != kdeProxyTypeManual || runner.values["socksProxy"] != "socks://127.0.0.1 1080" {
+		t.Errorf("unexpected values: %v", runner.values)
+	}
+	if _, ok := runner.values["httpProxy"]; ok {
+		t.Error("http proxy should not be set without http listener")
+	}
+
+	// the values left by a crash are restored by a new Proxy.
+	if err := New(runner, path).Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.values) != 1 || runner.values["ProxyType"] != "0" {
+		t.Errorf("values are not restored: %v", runner.values)
+	}
+}
+
+func TestNoDesktop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sysproxy.json")
+	p := New(&fakeRunner{}, path)
+	if err := p.Set(Settings{Socks5Addr: "127.0.0.1:1080"}); err != ErrNoDesktop {
+		t.Errorf("got %v, want %v", err, ErrNoDesktop)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Error("backup file should not be written without desktop")
+	}
+}
+
+func TestRestoreFailure(t *testing.T) {
+	runner := &fakeRunner{installed: map[string]bool{"gsettings": true}, values: map[string]string{}}
+	path := filepath.Join(t.TempDir(), "sysproxy.json")
+	p := New(runner, path)
+	if err := p.Set(Settings{Socks5Addr: "127.0.0.1:1080"}); err != nil {
+		t.Fatal(err)
+	}
+	runner.failSet = true
+	if err := p.Restore(); err == nil {
+		t.Error("restoring should fail")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("backup file should be kept for retrying: %v", err)
+	}
+}
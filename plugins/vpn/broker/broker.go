@@ -0,0 +1,207 @@
+// Package broker shares the webvpn sessions of a user between local clients (cli, client-ui, scripts using the C api),
+// so that they do not kick each other out by logging in separately.
+//
+// The broker owns the cookies of sessions, keyed by vpn host, auth method and username. A client asks the broker for a session
+// by Acquire: it gets the current session, or a lease to log in by itself (the captcha can only be answered by
+// the client) and passes the new session back by Release. Only one client holds the lease of a key at a time,
+// the others wait for its result, so that the logins are serialised.
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LeaseTimeout is the max time of logging in by a lease holder (including answering the captcha).
+// The lease is revoked after the timeout, so that a crashed client does not block the others.
+const LeaseTimeout = 3 * time.Minute
+
+// ErrLeaseExpired is returned by Release if the lease is revoked or released before.
+var ErrLeaseExpired = errors.New("lease of vpn login is expired")
+
+// The auth methods of Key.
+const (
+	MethodPassword = "password"
+	MethodQRCode   = "qrcode"
+)
+
+// Key identifies a vpn account.
+type Key struct {
+	Host     string `json:"host"`
+	Method   string `json:"method"`   // MethodPassword or MethodQRCode
+	Username string `json:"username"` // empty for QR code login
+}
+
+// Session is a logged in vpn session.
+type Session struct {
+	Cookies    []*http.Cookie `json:"cookies"`
+	SSLEnabled bool           `json:"ssl_enabled"`
+	Since      time.Time      `json:"since"` // time of logging in
+}
+
+// SessionInfo is the summary of a session, without the cookies.
+type SessionInfo struct {
+	Key
+	Since   time.Time `json:"since"`
+	Leased  bool      `json:"leased"` // a client is logging in
+	Waiting int       `json:"waiting"`
+}
+
+type entry struct {
+	session  *Session
+	lease    string        // id of current lease, empty if not leased
+	expire   time.Time     // expire time of current lease
+	released chan struct{} // closed when current lease is released or revoked
+	waiting  int
+}
+
+// Broker stores the sessions, and serialises the logins of each account.
+type Broker struct {
+	lock    sync.Mutex
+	entries map[Key]*entry
+}
+
+func New() *Broker {
+	return &Broker{entries: make(map[Key]*entry)}
+}
+
+// Acquire returns the session of key, or a lease for logging in if there is no session,
+// or the session has the same cookies as stale (e.g. the session failed to connect).
+// If another client holds the lease, Acquire waits until it is released or ctx is done.
+func (b *Broker) Acquire(ctx context.Context, key Key, stale []*http.Cookie) (*Session, string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	e := b.entries[key]
+	if e == nil {
+		e = &entry{}
+		b.entries[key] = e
+	}
+	for e.lease != "" {
+		wait := time.Until(e.expire)
+		if wait <= 0 {
+			b.revoke(e)
+			break
+		}
+		released := e.released
+		e.waiting++
+		b.lock.Unlock()
+		t := time.NewTimer(wait)
+		var err error
+		select {
+		case <-released:
+		case <-t.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		t.Stop()
+		b.lock.Lock()
+		e.waiting--
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if e.session != nil && (len(stale) == 0 || !sameCookies(e.session.Cookies, stale)) {
+		return e.session, "", nil
+	}
+	e.session = nil
+	e.lease = newLeaseID()
+	e.expire = time.Now().Add(LeaseTimeout)
+	e.released = make(chan struct{})
+	return nil, e.lease, nil
+}
+
+// Release releases the lease returned by Acquire, and stores the new session if it is not nil
+// (it is nil if the login failed, then the next waiting client gets the lease).
+func (b *Broker) Release(key Key, lease string, session *Session) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	e := b.entries[key]
+	if e == nil || e.lease == "" || e.lease != lease {
+		return ErrLeaseExpired
+	}
+	if session != nil {
+		if session.Since.IsZero() {
+			session.Since = time.Now()
+		}
+		e.session = session
+	}
+	b.revoke(e)
+	return nil
+}
+
+// revoke clears the lease of e and wakes up the waiting clients. The lock must be held.
+func (b *Broker) revoke(e *entry) {
+	e.lease = ""
+	close(e.released)
+}
+
+// Drain removes all sessions and returns them, e.g. for logging out when the broker exits.
+func (b *Broker) Drain() map[Key]*Session {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	sessions := make(map[Key]*Session)
+	for key, e := range b.entries {
+		if e.session != nil {
+			sessions[key] = e.session
+			e.session = nil
+		}
+	}
+	return sessions
+}
+
+// Sessions returns the summaries of sessions (and the accounts logging in), sorted by key.
+func (b *Broker) Sessions() []SessionInfo {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	infos := make([]SessionInfo, 0, len(b.entries))
+	for key, e := range b.entries {
+		if e.session == nil && e.lease == "" {
+			continue
+		}
+		info := SessionInfo{Key: key, Leased: e.lease != "", Waiting: e.waiting}
+		if e.session != nil {
+			info.Since = e.session.Since
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Host != infos[j].Host {
+			return infos[i].Host < infos[j].Host
+		}
+		if infos[i].Method != infos[j].Method {
+			return infos[i].Method < infos[j].Method
+		}
+		return infos[i].Username < infos[j].Username
+	})
+	return infos
+}
+
+// sameCookies returns true if a and b have the same names and values.
+func sameCookies(a, b []*http.Cookie) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	values := make(map[string]string, len(a))
+	for _, c := range a {
+		values[c.Name] = c.Value
+	}
+	for _, c := range b {
+		if v, ok := values[c.Name]; !ok || v != c.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func newLeaseID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
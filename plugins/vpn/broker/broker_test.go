@@ -0,0 +1,159 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var testKey = Key{Host: "webvpn.example.com", Method: MethodPassword, Username: "alice"}
+
+func testSession(value string) *Session {
+	return &Session{Cookies: []*http.Cookie{{Name: "wengine_vpn_ticket", Value: value}}, SSLEnabled: true}
+}
+
+func TestAcquire(t *testing.T) {
+	b := New()
+	ctx := context.Background()
+	session, lease, err := b.Acquire(ctx, testKey, nil)
+	if err != nil || session != nil || lease == "" {
+		t.Fatalf("the first client should get the lease: %v %v %q", session, err, lease)
+	}
+
+	// the other client waits for the login of the lease holder.
+	type result struct {
+		session *Session
+		lease   string
+		err     error
+	}
+	waiting := make(chan result)
+	go func() {
+		s, l, err := b.Acquire(ctx, testKey, nil)
+		waiting <- result{s, l, err}
+	}()
+	select {
+	case r := <-waiting:
+		t.Fatalf("acquiring should wait for the lease holder: %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := b.Release(testKey, lease, testSession("1")); err != nil {
+		t.Fatal(err)
+	}
+	r := <-waiting
+	if r.err != nil || r.lease != "" || r.session == nil || r.session.Cookies[0].Value != "1" {
+		t.Fatalf("the waiting client should get the session: %+v", r)
+	}
+	if r.session.Since.IsZero() {
+		t.Error("the time of logging in should be set")
+	}
+	if err := b.Release(testKey, lease, testSession("2")); err != ErrLeaseExpired {
+		t.Errorf("releasing twice: got %v, want %v", err, ErrLeaseExpired)
+	}
+
+	// the stale session is replaced by logging in again.
+	session, lease, err = b.Acquire(ctx, testKey, testSession("1").Cookies)
+	if err != nil || session != nil || lease == "" {
+		t.Fatalf("the stale session should not be returned: %v %v", session, err)
+	}
+	// the login fails, the next client gets the lease.
+	if err := b.Release(testKey, lease, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, lease, _ = b.Acquire(ctx, testKey, nil); lease == "" {
+		t.Error("the lease should be granted after the failed login")
+	}
+
+	// the session of QR code login is not shared with the password login of the same host.
+	qrKey := Key{Host: testKey.Host, Method: MethodQRCode}
+	if session, qrLease, _ := b.Acquire(ctx, qrKey, nil); session != nil || qrLease == "" {
+		t.Errorf("QR code login should get its own lease: %v %q", session, qrLease)
+	}
+
+	infos := b.Sessions()
+	if len(infos) != 2 || infos[0].Key != testKey || !infos[0].Leased {
+		t.Errorf("unexpected sessions: %+v", infos)
+	}
+}
+
+func TestAcquireCanceled(t *testing.T) {
+	b := New()
+	if _, _, err := b.Acquire(context.Background(), testKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := b.Acquire(ctx, testKey, nil); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if infos := b.Sessions(); len(infos) != 1 || infos[0].Waiting != 0 {
+		t.Errorf("unexpected sessions: %+v", infos)
+	}
+}
+
+func TestServer(t *testing.T) {
+	b := New()
+	server, err := Listen("127.0.0.1:0", "secret", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	defer server.Close()
+
+	c := NewClient(Info{Addr: server.Addr(), Token: "secret"})
+	session, lease, err := c.Acquire(context.Background(), testKey, nil)
+	if err != nil || session != nil || lease == "" {
+		t.Fatalf("the first client should get the lease: %v %v", session, err)
+	}
+	if err := c.Release(testKey, lease, testSession("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Release(testKey, lease, nil); err != ErrLeaseExpired {
+		t.Errorf("got %v, want %v", err, ErrLeaseExpired)
+	}
+	session, _, err = c.Acquire(context.Background(), testKey, nil)
+	if err != nil || session == nil || session.Cookies[0].Value != "1" || !session.SSLEnabled {
+		t.Fatalf("the session should be returned: %+v %v", session, err)
+	}
+	infos, err := c.Sessions()
+	if err != nil || len(infos) != 1 || infos[0].Username != "alice" {
+		t.Errorf("unexpected sessions: %+v %v", infos, err)
+	}
+
+	if _, err := NewClient(Info{Addr: server.Addr(), Token: "wrong"}).Sessions(); err != ErrUnauthorized {
+		t.Errorf("got %v, want %v", err, ErrUnauthorized)
+	}
+	if _, err := Listen("0.0.0.0:0", "secret", b); err == nil {
+		t.Error("listening on non-loopback address should fail")
+	}
+}
+
+func TestServerUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file mode of unix socket on windows")
+	}
+	path := filepath.Join(t.TempDir(), "broker.sock")
+	server, err := Listen(UnixPrefix+path, "", New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	defer server.Close()
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm()&0077 != 0 {
+		t.Errorf("socket file should be only accessible by current user: %v %v", fi.Mode(), err)
+	}
+	if server.Addr() != UnixPrefix+path {
+		t.Error("unexpected address", server.Addr())
+	}
+	if _, err := NewClient(Info{Addr: server.Addr()}).Sessions(); err != nil {
+		t.Error(err)
+	}
+	server.Close()
+	// only the socket file is in the directory, and it is removed after closing.
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 0 {
+		t.Errorf("the socket file should be removed: %v %v", entries, err)
+	}
+}
@@ -0,0 +1,165 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// requestTimeout is the timeout of requests, except the acquiring requests waiting for the lease.
+const requestTimeout = 10 * time.Second
+
+// Info is the address and token of a running broker.
+// The broker writes it to a file (see DefaultInfoPath), so that the clients can find the broker.
+type Info struct {
+	Addr  string `json:"addr"`
+	Token string `json:"token,omitempty"`
+}
+
+// DefaultInfoPath returns the path of the info file, which is in the same directory as default configuration file.
+func DefaultInfoPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "wssocks-ustb", "broker.json"), nil
+}
+
+// WriteInfo writes info to path. The file is only readable by current user, because it contains the token.
+func WriteInfo(path string, info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ReadInfo reads info written by WriteInfo.
+func ReadInfo(path string) (Info, error) {
+	var info Info
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// Discover returns the client of the broker in the default info file, or nil if the broker is not running.
+// The info file may be left by a crashed broker, so the broker is checked by a request.
+func Discover() *Client {
+	path, err := DefaultInfoPath()
+	if err != nil {
+		return nil
+	}
+	info, err := ReadInfo(path)
+	if err != nil {
+		return nil
+	}
+	c := NewClient(info)
+	if _, err := c.Sessions(); err != nil {
+		return nil
+	}
+	return c
+}
+
+// Client calls the api of broker.
+type Client struct {
+	info   Info
+	base   string
+	client *http.Client
+}
+
+// NewClient creates a client of the broker listening on info.Addr (see Listen).
+func NewClient(info Info) *Client {
+	c := &Client{info: info, base: "http://" + info.Addr, client: &http.Client{}}
+	if strings.HasPrefix(info.Addr, UnixPrefix) {
+		path := strings.TrimPrefix(info.Addr, UnixPrefix)
+		c.base = "http://unix"
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+// Sessions returns the summaries of sessions in the broker.
+func (c *Client) Sessions() ([]SessionInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	var infos []SessionInfo
+	err := c.call(ctx, http.MethodGet, "/sessions", nil, &infos)
+	return infos, err
+}
+
+// Acquire calls Broker.Acquire, it waits until the lease is available or ctx is done.
+func (c *Client) Acquire(ctx context.Context, key Key, stale []*http.Cookie) (*Session, string, error) {
+	var resp acquireResponse
+	if err := c.call(ctx, http.MethodPost, "/acquire", acquireRequest{Key: key, Stale: stale}, &resp); err != nil {
+		return nil, "", err
+	}
+	if resp.Session == nil && resp.Lease == "" {
+		return nil, "", errors.New("session broker: empty response")
+	}
+	return resp.Session, resp.Lease, nil
+}
+
+// Release calls Broker.Release.
+func (c *Client) Release(key Key, lease string, session *Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return c.call(ctx, http.MethodPost, "/release", releaseRequest{Key: key, Lease: lease, Session: session}, nil)
+}
+
+func (c *Client) call(ctx context.Context, method, path string, body, result interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	if c.info.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.info.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("session broker: %s", resp.Status)
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			return ErrUnauthorized
+		}
+		if resp.StatusCode == http.StatusConflict {
+			return ErrLeaseExpired
+		}
+		return errors.New(e.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,187 @@
+package broker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// UnixPrefix is the prefix of address for listening on a unix socket.
+const UnixPrefix = "unix:"
+
+var (
+	ErrNotLoopback  = errors.New("session broker can only listen on loopback address")
+	ErrEmptyToken   = errors.New("token of session broker is required for tcp address (and unix socket on windows)")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// acquireRequest is the body of /acquire.
+type acquireRequest struct {
+	Key   Key            `json:"key"`
+	Stale []*http.Cookie `json:"stale,omitempty"`
+}
+
+// acquireResponse is the response of /acquire, either the session or the lease is set.
+type acquireResponse struct {
+	Session *Session `json:"session,omitempty"`
+	Lease   string   `json:"lease,omitempty"`
+}
+
+// releaseRequest is the body of /release.
+type releaseRequest struct {
+	Key     Key      `json:"key"`
+	Lease   string   `json:"lease"`
+	Session *Session `json:"session,omitempty"`
+}
+
+// errorResponse is the response body if a request fails.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the api of a Broker.
+type Server struct {
+	broker   *Broker
+	token    string
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen listens on addr for the api of broker.
+// The token can only be empty if addr is a unix socket (the socket file is only accessible by current user),
+// except on windows.
+func Listen(addr, token string, broker *Broker) (*Server, error) {
+	l, err := listen(addr, token)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{broker: broker, token: token, listener: l}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", s.sessions)
+	mux.HandleFunc("/acquire", s.acquire)
+	mux.HandleFunc("/release", s.release)
+	// the acquiring requests may wait for the login of another client, so there is no write timeout.
+	s.server = &http.Server{Handler: s.auth(mux), ReadHeaderTimeout: 10 * time.Second}
+	return s, nil
+}
+
+func listen(addr, token string) (net.Listener, error) {
+	if strings.HasPrefix(addr, UnixPrefix) {
+		if token == "" && !privateUnixSocket {
+			return nil, ErrEmptyToken
+		}
+		path := strings.TrimPrefix(addr, UnixPrefix)
+		// remove the socket file left by last run.
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return listenUnix(path)
+	}
+
+	if token == "" {
+		return nil, ErrEmptyToken
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("%w: %s", ErrNotLoopback, addr)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Addr returns the address of the listener, in the form accepted by NewClient.
+func (s *Server) Addr() string {
+	if s.listener.Addr().Network() == "unix" {
+		return UnixPrefix + s.listener.Addr().String()
+	}
+	return s.listener.Addr().String()
+}
+
+// Serve serves the api until Close is called.
+func (s *Server) Serve() error {
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the server, the waiting acquiring requests are canceled.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, errorResponse{Error: ErrUnauthorized.Error()})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) sessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.broker.Sessions())
+}
+
+func (s *Server) acquire(w http.ResponseWriter, r *http.Request) {
+	var req acquireRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	// the request context is canceled if the client gives up waiting.
+	session, lease, err := s.broker.Acquire(r.Context(), req.Key, req.Stale)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, acquireResponse{Session: session, Lease: lease})
+}
+
+func (s *Server) release(w http.ResponseWriter, r *http.Request) {
+	var req releaseRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if err := s.broker.Release(req.Key, req.Lease, req.Session); err != nil {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// readJSON decodes the body of a POST request, it writes the error response and returns false on failure.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,56 @@
+//go:build !windows
+
+package broker
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// privateUnixSocket is true if the unix socket file can be made only accessible by current user,
+// so the token is not required for it.
+const privateUnixSocket = true
+
+// listenUnix listens on the unix socket path, which is only accessible by current user.
+// The socket is created in a private (0700) temporary directory, and moved to path after its mode is set,
+// so other users can never connect to it. The umask is not changed, as it is shared by the whole process.
+func listenUnix(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".s")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// the socket file is moved, it is removed by unixListener instead.
+	l.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: l, path: path}, nil
+}
+
+// unixListener is a unix listener whose socket file is moved to path.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
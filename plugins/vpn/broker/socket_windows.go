@@ -0,0 +1,12 @@
+package broker
+
+import "net"
+
+// privateUnixSocket is false on windows, the mode of socket file does not restrict other users,
+// so the token is required as for tcp address.
+const privateUnixSocket = false
+
+// listenUnix listens on the unix socket path.
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,134 @@
+package credential
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	ActionGet   = "get"
+	ActionStore = "store"
+	ActionErase = "erase"
+)
+
+// Credential is the set of attributes exchanged with a credential helper.
+// It follows the input/output format of git credential helpers,
+// see https://git-scm.com/docs/git-credential#IOFMT for more details.
+type Credential struct {
+	Protocol string
+	Host     string
+	Username string
+	Password string
+}
+
+// Helper runs an external credential helper (e.g. a small script on top of `pass` or `gopass`)
+// and exchanges key=value lines with it on stdin/stdout.
+// The helper is invoked as `<Command> get|store|erase` in a shell,
+// so existing git credential helpers such as `git credential-store` can also be used.
+type Helper struct {
+	Command string
+}
+
+func NewHelper(command string) *Helper {
+	return &Helper{Command: command}
+}
+
+// Get asks the helper for the username and password matching c.
+// Attributes that the helper does not return keep their values in c.
+func (h *Helper) Get(c Credential) (Credential, error) {
+	out, err := h.run(ActionGet, c)
+	if err != nil {
+		return c, err
+	}
+	if err := c.decode(bytes.NewReader(out)); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// Store tells the helper to save c, it is called after a successful login.
+func (h *Helper) Store(c Credential) error {
+	_, err := h.run(ActionStore, c)
+	return err
+}
+
+// Erase tells the helper to remove the saved credential of c, it is called if the password is rejected.
+func (h *Helper) Erase(c Credential) error {
+	_, err := h.run(ActionErase, c)
+	return err
+}
+
+func (h *Helper) run(action string, c Credential) ([]byte, error) {
+	if strings.TrimSpace(h.Command) == "" {
+		return nil, errors.New("empty credential helper command")
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", h.Command+" "+action)
+	} else {
+		// the same as git: pass action as the first argument of the helper command.
+		cmd = exec.Command("sh", "-c", h.Command+` "$@"`, h.Command, action)
+	}
+
+	var stdin, stdout, stderr bytes.Buffer
+	c.encode(&stdin)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("credential helper %s: %w: %s", action, err, msg)
+		}
+		return nil, fmt.Errorf("credential helper %s: %w", action, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// encode writes non-empty attributes as key=value lines, terminated by a blank line.
+func (c *Credential) encode(w io.Writer) {
+	attrs := [][2]string{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"username", c.Username},
+		{"password", c.Password},
+	}
+	for _, attr := range attrs {
+		if attr[1] != "" {
+			fmt.Fprintf(w, "%s=%s\n", attr[0], attr[1])
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// decode reads key=value lines until EOF or a blank line.
+// Unknown keys are ignored, as git does.
+func (c *Credential) decode(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("bad line from credential helper: %s", line)
+		}
+		switch key {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,74 @@
+package credential
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// a fake helper which keeps one credential in a file, like `git credential-store`.
+const fakeHelper = `#!/bin/sh
+case "$1" in
+get) cat "$STORE" 2>/dev/null || true ;;
+store) grep -v '^$' > "$STORE" ;;
+erase) rm -f "$STORE" ;;
+esac
+`
+
+func TestHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helper is a shell script")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "helper.sh")
+	if err := os.WriteFile(script, []byte(fakeHelper), 0700); err != nil {
+		t.Fatal(err)
+	}
+	store := filepath.Join(dir, "store")
+	t.Setenv("STORE", store)
+
+	h := NewHelper(script)
+	query := Credential{Protocol: "https", Host: "webvpn.smu.edu.cn", Username: "user1"}
+
+	// nothing stored yet
+	if c, err := h.Get(query); err != nil {
+		t.Fatal(err)
+	} else if c.Password != "" || c.Username != "user1" {
+		t.Error("unexpected credential before store:", c)
+	}
+
+	c := query
+	c.Password = "p@ss=word"
+	if err := h.Store(c); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(store); !strings.Contains(string(data), "password=p@ss=word\n") {
+		t.Error("helper input is not as expected:", string(data))
+	}
+	if got, err := h.Get(Credential{Protocol: "https", Host: "webvpn.smu.edu.cn"}); err != nil {
+		t.Fatal(err)
+	} else if got != c {
+		t.Error("credential is not as expected:", got)
+	}
+
+	if err := h.Erase(query); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Error("credential is not erased")
+	}
+}
+
+func TestHelperFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helper is a shell script")
+	}
+	h := NewHelper("echo 'vault is locked' >&2; exit 1;")
+	if _, err := h.Get(Credential{Host: "webvpn.smu.edu.cn"}); err == nil {
+		t.Error("expect an error from failed helper")
+	} else if !strings.Contains(err.Error(), "vault is locked") {
+		t.Error("helper stderr is not in the error:", err)
+	}
+}
@@ -15,7 +15,9 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strings"
+	"time"
 )
 
 const SMUVpnHost = "webvpn.smu.edu.cn"
@@ -39,12 +41,40 @@ type AutoLoginInterface interface {
 
 type CaptchaHandler func(imgData []byte) (string, error)
 
+// ErrWrongPassword is returned (wrapped) by VpnLogin if the vpn server rejects the username or password.
+var ErrWrongPassword = errors.New("wrong username or password")
+
+// ErrWrongCaptcha is returned (wrapped) by VpnLogin if the vpn server rejects the captcha.
+var ErrWrongCaptcha = errors.New("wrong captcha")
+
+// the messages of login portal if the password or the captcha is rejected.
+var (
+	wrongPasswordMarkers = []string{"密码错误", "密码不正确"}
+	wrongCaptchaMarkers  = []string{"验证码错误", "验证码不正确", "验证码已失效"}
+)
+
+// LoginResult is the result of a login attempt.
+type LoginResult string
+
+const (
+	LoginSuccess       LoginResult = "success"
+	LoginWrongPassword LoginResult = "wrong_password"
+	LoginWrongCaptcha  LoginResult = "wrong_captcha"
+	LoginError         LoginResult = "error" // other errors, e.g. network errors
+)
+
+// LoginObserver observes the login attempts of VpnLogin, e.g. for metrics.
+type LoginObserver interface {
+	OnLoginAttempt(result LoginResult)
+}
+
 type AutoLogin struct {
 	Host           string
 	ForceLogout    bool
 	SSLEnabled     bool // the vpn server supports https
 	SkipTLSVerify  bool // skip tsl verify when setting https connectioon
 	CaptchaHandler CaptchaHandler
+	Observer       LoginObserver // optional
 }
 
 // Helper to open file
@@ -90,22 +120,72 @@ func (al *AutoLogin) VpnLogin(uname, passwd string) ([]*http.Cookie, error) {
 
 	captcha, err := al.getCaptcha(hc)
 	if err != nil {
+		al.observe(err)
 		return nil, err
 	}
 
 	ticket, err := al.sendLogin(uname, passwd, captcha, hc)
 	if err != nil {
+		al.observe(err)
 		return nil, err
 	}
 
 	if err := al.redirectLogin(hc, ticket); err != nil {
+		al.observe(err)
 		return nil, err
 	}
+	al.observe(nil)
 
 	u, _ := url.Parse("https://" + SMUVpnHost)
 	return hc.Jar.Cookies(u), nil
 }
 
+// VpnLogout logs out the vpn session of cookies returned by VpnLogin.
+func (al *AutoLogin) VpnLogout(cookies []*http.Cookie) error {
+	host := al.Host
+	if host == "" {
+		host = SMUVpnHost
+	}
+	hc := al.NewHttpClient(func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse // the login page after logout is not needed.
+	})
+	hc.Timeout = 10 * time.Second
+
+	req, err := http.NewRequest("GET", USTBVpnHttpsScheme+"://"+host+"/logout", nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("vpn logout: %s", resp.Status)
+	}
+	return nil
+}
+
+// observe passes the result of a login attempt to the observer.
+func (al *AutoLogin) observe(err error) {
+	if al.Observer == nil {
+		return
+	}
+	switch {
+	case err == nil:
+		al.Observer.OnLoginAttempt(LoginSuccess)
+	case errors.Is(err, ErrWrongPassword):
+		al.Observer.OnLoginAttempt(LoginWrongPassword)
+	case errors.Is(err, ErrWrongCaptcha):
+		al.Observer.OnLoginAttempt(LoginWrongCaptcha)
+	default:
+		al.Observer.OnLoginAttempt(LoginError)
+	}
+}
+
 func (al *AutoLogin) getCaptcha(client *http.Client) (string, error) {
 	headers := http.Header{
 		"Accept":             {"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"},
@@ -241,7 +321,45 @@ func (al *AutoLogin) sendLogin(account, password, captcha string, client *http.C
 		}
 		return "", errors.New("ticket not found in response")
 	}
-	return "", fmt.Errorf("登录失败，原因：%s", bodyString)
+	return "", loginError(bodyBytes)
+}
+
+// loginError returns the error of a failed login by the response body of login portal.
+// The message of wrong password is checked first, because it may also mention the captcha
+// (e.g. the captcha is required after several failures), while a prompt like "请输入验证码" is not a wrong captcha.
+func loginError(body []byte) error {
+	message := string(body)
+	// the message in json may be escaped (e.g. \u9a8c), so the string values are matched instead.
+	var respJson map[string]interface{}
+	if err := json.Unmarshal(body, &respJson); err == nil {
+		keys := make([]string, 0, len(respJson))
+		for k := range respJson {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var values []string
+		for _, k := range keys {
+			if s, ok := respJson[k].(string); ok {
+				values = append(values, s)
+			}
+		}
+		message = strings.Join(values, " ")
+	}
+	containsAny := func(markers []string) bool {
+		for _, m := range markers {
+			if strings.Contains(message, m) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case containsAny(wrongPasswordMarkers):
+		return fmt.Errorf("登录失败，原因：%s: %w", message, ErrWrongPassword)
+	case containsAny(wrongCaptchaMarkers):
+		return fmt.Errorf("登录失败，原因：%s: %w", message, ErrWrongCaptcha)
+	}
+	return fmt.Errorf("登录失败，原因：%s", message)
 }
 
 func (al *AutoLogin) redirectLogin(client *http.Client, ticket string) error {
@@ -1,8 +1,12 @@
 package passwd
 
 import (
+	"errors"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -34,3 +38,52 @@ func TestAutoLogin(t *testing.T) {
 		log.Println(cookies)
 	}
 }
+
+func TestVpnLogout(t *testing.T) {
+	var cookie string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/logout" {
+			http.NotFound(w, r)
+			return
+		}
+		if c, err := r.Cookie("session"); err == nil {
+			cookie = c.Value
+		}
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}))
+	defer server.Close()
+
+	al := AutoLogin{Host: strings.TrimPrefix(server.URL, "https://"), SkipTLSVerify: true}
+	if err := al.VpnLogout([]*http.Cookie{{Name: "session", Value: "abc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if cookie != "abc" {
+		t.Errorf("session cookie is not sent, got %q", cookie)
+	}
+}
+
+func TestLoginError(t *testing.T) {
+	tests := []struct {
+		body string
+		want error
+	}{
+		{`{"code":"1","msg":"验证码错误"}`, ErrWrongCaptcha},
+		{`{"code":"1","msg":"验证码不正确"}`, ErrWrongCaptcha},
+		{`{"code":"1","msg":"用户名或密码错误, 连续错误5次后需输入验证码"}`, ErrWrongPassword},
+		{`{"code":"1","msg":"密码不正确"}`, ErrWrongPassword},
+		{`{"code":"1","msg":"请输入验证码"}`, nil},
+		{`验证码错误`, ErrWrongCaptcha},
+		{`<html>service unavailable</html>`, nil},
+	}
+	for _, test := range tests {
+		err := loginError([]byte(test.body))
+		if err == nil {
+			t.Fatalf("%s: expected an error", test.body)
+		}
+		for _, e := range []error{ErrWrongPassword, ErrWrongCaptcha} {
+			if errors.Is(err, e) != (e == test.want) {
+				t.Errorf("%s: got %v, want %v", test.body, err, test.want)
+			}
+		}
+	}
+}
@@ -2,8 +2,10 @@ package vpn
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -11,10 +13,13 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/genshen/cmds"
 	plugin "github.com/genshen/wssocks/client"
 	"github.com/genshen/wssocks/cmd/client"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/broker"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/credential"
 	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/passwd"
 	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/qrcode"
 	log "github.com/sirupsen/logrus"
@@ -26,6 +31,9 @@ const (
 	VpnAuthMethodQRCode
 )
 
+// directProbeTimeout is the timeout of checking whether the server is directly reachable in auto mode.
+const directProbeTimeout = 3 * time.Second
+
 type UstbVpn struct {
 	Enable      bool
 	AuthMethod  int // value of VpnAuthMethodPasswd or VpnAuthMethodQRCode
@@ -36,6 +44,34 @@ type UstbVpn struct {
 	ForceLogout    bool
 	ConnOptions    plugin.Options // normal connection options
 	CaptchaHandler passwd.CaptchaHandler
+	// CredentialHelper is the command of an external credential helper (in git credential helper style).
+	// If it is not empty, missing username/password are asked from the helper.
+	CredentialHelper string
+	// Auto is the auto mode: if the server (or DirectProbe) is directly reachable (e.g. on campus),
+	// the vpn is skipped even if it is enabled.
+	Auto bool
+	// DirectProbe is the address (host:port) for checking direct reachability in auto mode,
+	// default is the address of wssocks server.
+	DirectProbe string
+	// LoginObserver observes the password login attempts (optional).
+	LoginObserver passwd.LoginObserver
+	// Session is the vpn session of the last login, it is used for logout.
+	// If it is set before connecting, the session is reused instead of logging in again.
+	Session *Session
+	// Broker is the session broker shared by local clients (optional). If it is set, the session is got from
+	// the broker, and this client logs in only if the broker has no valid session.
+	Broker *broker.Client
+	// StaleSession is the session failed to connect, the broker returns it no more.
+	StaleSession *Session
+	brokered     bool // Session is got from the broker, rather than logged in by this client
+	// the password is typed in the terminal, it is asked again if the vpn server rejects it.
+	passwordFromTty bool
+}
+
+// Session is a logged in vpn session.
+type Session struct {
+	Cookies    []*http.Cookie
+	SSLEnabled bool
 }
 
 // create a UstbVpn instance, and add necessary command options to client sub-command.
@@ -47,43 +83,164 @@ func NewUstbVpnCli() *UstbVpn {
 		clientCmd.FlagSet.StringVar(&vpn.PasswdAuth.Username, "vpn-username", "", `username to login vpn.`)
 		clientCmd.FlagSet.StringVar(&vpn.PasswdAuth.Password, "vpn-password", "", `password to login vpn.`)
 		clientCmd.FlagSet.StringVar(&vpn.TargetVpn, "vpn-host", passwd.SMUVpnHost, `hostname of vpn server.`)
+		clientCmd.FlagSet.BoolVar(&vpn.Auto, "vpn-auto", false,
+			`skip vpn if the server (or --vpn-direct-probe) is directly reachable, e.g. on campus.`)
+		clientCmd.FlagSet.StringVar(&vpn.DirectProbe, "vpn-direct-probe", "",
+			`address (host:port) for checking direct reachability in vpn auto mode (default is the server address).`)
 		clientCmd.FlagSet.BoolVar(&vpn.ForceLogout, "vpn-force-logout", false,
 			`force logout account on other devices.`)
 		clientCmd.FlagSet.BoolVar(&vpn.HostEncrypt, "vpn-host-encrypt", true,
 			`encrypt proxy host using aes algorithm.`)
+		clientCmd.FlagSet.StringVar(&vpn.CredentialHelper, "vpn-credential-helper", "",
+			`command of credential helper to get/store/erase vpn username and password (e.g. "git credential-store").`)
 		vpn.AuthMethod = VpnAuthMethodPasswd // todo: for cli, only support password auth.
+		clientCmd.FlagSet.Var(authMethodFlag{&vpn.AuthMethod}, "vpn-auth-method",
+			`auth method of vpn, only "password" is supported in cli.`)
 	}
 	return &vpn
 }
 
+// authMethodFlag is the flag value of vpn auth method, in the names of config file.
+type authMethodFlag struct {
+	method *int
+}
+
+func (f authMethodFlag) String() string {
+	if f.method != nil && *f.method == VpnAuthMethodQRCode {
+		return "qrcode"
+	}
+	return "password"
+}
+
+func (f authMethodFlag) Set(value string) error {
+	switch value {
+	case "password":
+		*f.method = VpnAuthMethodPasswd
+		return nil
+	case "qrcode":
+		// the cli can not show the qr code, the profile should not fall back to password auth silently.
+		return errors.New("qr code login is not supported in cli, use client-ui instead")
+	}
+	return fmt.Errorf("unknown vpn auth method `%s`", value)
+}
+
 // BeforeRequest is implementation of interface RequestPlugin
 // In the UstbVpn plugin, we use it for vpn auth (password auth and QR code auth).
 func (v *UstbVpn) BeforeRequest(hc *http.Client, transport *http.Transport, url *url.URL, header *http.Header) error {
 	if !v.Enable {
 		return nil
 	}
+	if v.Auto {
+		addr := directProbeAddr(v.DirectProbe, url)
+		if directReachable(addr, directProbeTimeout) {
+			log.WithField("probe", addr).Info("directly reachable, connecting to server without vpn.")
+			return nil
+		}
+		log.WithField("probe", addr).Info("not directly reachable, connecting to server through vpn.")
+	}
+	if v.Session != nil {
+		log.Info("reuse vpn session of last login.")
+		return v.SetWebSocketCookies(v.Session.SSLEnabled, hc, transport, url, v.Session.Cookies)
+	}
+	if v.Broker != nil {
+		return v.BrokerAuthForCookie(hc, transport, url)
+	}
+	var helper *credential.Helper
+	if v.AuthMethod == VpnAuthMethodPasswd {
+		helper = v.credentialHelper()
+	}
+	return v.login(helper, hc, transport, url)
+}
 
+// login logs in vpn by the auth method, and keeps cookie for websocket request.
+// The helper is the credential helper of password auth, which can be nil.
+func (v *UstbVpn) login(helper *credential.Helper, hc *http.Client, transport *http.Transport, url *url.URL) error {
 	if v.AuthMethod == VpnAuthMethodPasswd {
-		return v.PasswordAuthForCookie(hc, transport, url)
+		return v.passwordAuth(helper, hc, transport, url)
 	} else if v.AuthMethod == VpnAuthMethodQRCode {
 		return v.QrCodeAuthForCookie(hc, transport, url)
 	}
 	return fmt.Errorf("unknown auth method")
 }
 
+// BrokerAuthForCookie gets the session from the session broker, so that the local clients share one vpn session.
+// If the broker has no valid session, this client logs in while the others wait for the result.
+func (v *UstbVpn) BrokerAuthForCookie(hc *http.Client, transport *http.Transport, url *url.URL) error {
+	key := broker.Key{Host: v.TargetVpn, Method: broker.MethodQRCode}
+	var helper *credential.Helper
+	if v.AuthMethod == VpnAuthMethodPasswd {
+		helper = v.credentialHelper() // the username may be provided by credential helper.
+		// the session is shared by account, so the username must be known before acquiring.
+		if err := v.readUsername(); err != nil {
+			return err
+		}
+		key.Method = broker.MethodPassword
+		key.Username = v.PasswdAuth.Username
+	}
+	var stale []*http.Cookie
+	if v.StaleSession != nil {
+		stale = v.StaleSession.Cookies
+	}
+	// the client holding the lease may be waiting for the captcha.
+	ctx, cancel := context.WithTimeout(context.Background(), broker.LeaseTimeout+time.Minute)
+	defer cancel()
+	session, lease, err := v.Broker.Acquire(ctx, key, stale)
+	if err != nil {
+		return fmt.Errorf("vpn session broker: %w", err)
+	}
+	if session != nil {
+		log.WithField("since", session.Since.Format(time.RFC3339)).Info("use vpn session of session broker.")
+		if err := v.SetWebSocketCookies(session.SSLEnabled, hc, transport, url, session.Cookies); err != nil {
+			return err
+		}
+		v.brokered = true
+		return nil
+	}
+
+	log.Info("no valid vpn session in session broker, logging in.")
+	err = v.login(helper, hc, transport, url)
+	var loggedIn *broker.Session
+	if err == nil {
+		loggedIn = &broker.Session{Cookies: v.Session.Cookies, SSLEnabled: v.Session.SSLEnabled}
+	}
+	if err := v.Broker.Release(key, lease, loggedIn); err != nil {
+		log.WithError(err).Warning("failed to pass vpn session to session broker.")
+	}
+	return err
+}
+
+// SessionFromBroker returns true if Session is got from the session broker rather than logged in by this client.
+func (v *UstbVpn) SessionFromBroker() bool {
+	return v.brokered
+}
+
+// readUsername reads the username from stdin if it is empty.
+func (v *UstbVpn) readUsername() error {
+	if v.PasswdAuth.Username != "" {
+		return nil
+	}
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Enter username: ")
+	text, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error while reading username, %w", err)
+	}
+	v.PasswdAuth.Username = strings.TrimSuffix(text, "\n")
+	return nil
+}
+
 // PasswordAuthForCookie send password to vpn server for auth,
 // and keep cookie for websocket request.
 // It can support cli and gui client.
 func (v *UstbVpn) PasswordAuthForCookie(hc *http.Client, transport *http.Transport, url *url.URL) error {
+	return v.passwordAuth(v.credentialHelper(), hc, transport, url)
+}
+
+// passwordAuth is PasswordAuthForCookie with the credential helper already resolved by credentialHelper.
+func (v *UstbVpn) passwordAuth(helper *credential.Helper, hc *http.Client, transport *http.Transport, url *url.URL) error {
 	// read username and password if they are empty.
-	if v.PasswdAuth.Username == "" {
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print("Enter username: ")
-		if text, err := reader.ReadString('\n'); err != nil {
-			return fmt.Errorf("error while reading username, %w", err)
-		} else {
-			v.PasswdAuth.Username = strings.TrimSuffix(text, "\n")
-		}
+	if err := v.readUsername(); err != nil {
+		return err
 	}
 	if v.PasswdAuth.Password == "" {
 		fmt.Print("Enter Password: ")
@@ -91,18 +248,66 @@ func (v *UstbVpn) PasswordAuthForCookie(hc *http.Client, transport *http.Transpo
 			return fmt.Errorf("error while parsing password, %w", err)
 		} else {
 			v.PasswdAuth.Password = string(bytePassword)
+			v.passwordFromTty = true
 		}
 	}
 
 	// add cookie
-	al := passwd.AutoLogin{Host: v.TargetVpn, ForceLogout: v.ForceLogout, SkipTLSVerify: v.ConnOptions.SkipTLSVerify, CaptchaHandler: v.CaptchaHandler}
+	al := passwd.AutoLogin{Host: v.TargetVpn, ForceLogout: v.ForceLogout, SkipTLSVerify: v.ConnOptions.SkipTLSVerify, CaptchaHandler: v.CaptchaHandler, Observer: v.LoginObserver}
 	if cookies, err := al.VpnLogin(v.PasswdAuth.Username, v.PasswdAuth.Password); err != nil {
+		if errors.Is(err, passwd.ErrWrongPassword) {
+			if helper != nil {
+				if err := helper.Erase(v.credential()); err != nil {
+					log.WithField("error", err).Warning("failed to erase vpn credential from credential helper")
+				}
+			}
+			// ask for the password again on next login, if it is typed in the terminal.
+			// Otherwise (set by options, gui or credential helper) there is no terminal to ask,
+			// and the reconnection stops on the error, so the rejected password is not sent again.
+			if v.passwordFromTty {
+				v.PasswdAuth.Password = ""
+				v.passwordFromTty = false
+			}
+		}
 		return fmt.Errorf("error vpn login: %w", err)
 	} else {
+		if helper != nil {
+			if err := helper.Store(v.credential()); err != nil {
+				log.WithField("error", err).Warning("failed to store vpn credential to credential helper")
+			}
+		}
 		return v.SetWebSocketCookies(al.SSLEnabled, hc, transport, url, cookies)
 	}
 }
 
+// credentialHelper asks credential helper for username and password if they are empty.
+// It returns nil if credential helper is not set.
+func (v *UstbVpn) credentialHelper() *credential.Helper {
+	if v.CredentialHelper == "" {
+		return nil
+	}
+	helper := credential.NewHelper(v.CredentialHelper)
+	if v.PasswdAuth.Username == "" || v.PasswdAuth.Password == "" {
+		if c, err := helper.Get(v.credential()); err != nil {
+			log.WithField("error", err).Warning("failed to get vpn credential from credential helper")
+		} else {
+			v.PasswdAuth.Username = c.Username
+			v.PasswdAuth.Password = c.Password
+		}
+	}
+	return helper
+}
+
+// credential returns the vpn account in the format of credential helper.
+func (v *UstbVpn) credential() credential.Credential {
+	return credential.Credential{
+		Protocol: passwd.USTBVpnHttpsScheme,
+		Host:     v.TargetVpn,
+		Username: v.PasswdAuth.Username,
+		Password: v.PasswdAuth.Password,
+	}
+}
+
 func (v *UstbVpn) SetWebSocketCookies(SSLEnabled bool, hc *http.Client, transport *http.Transport, url *url.URL, cookies []*http.Cookie) error {
 	// In vpnLogin, we can test https support.
 	// If the vpn support https, we can set transport.SkipTLSVerify if necessary.
@@ -110,6 +315,8 @@ func (v *UstbVpn) SetWebSocketCookies(SSLEnabled bool, hc *http.Client, transpor
 		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 
+	v.Session = &Session{Cookies: cookies, SSLEnabled: SSLEnabled}
+
 	// change target url.
 	vpnUrl(v.HostEncrypt, v.TargetVpn, SSLEnabled, url)
 	log.Infof("real url: %s, ssl enabled:%t", url.String(), SSLEnabled)
@@ -126,6 +333,22 @@ func (v *UstbVpn) SetWebSocketCookies(SSLEnabled bool, hc *http.Client, transpor
 	}
 }
 
+// Logout logs out current vpn session. It does nothing if the vpn is not logged in.
+func (v *UstbVpn) Logout() error {
+	if v.Session == nil {
+		return nil
+	}
+	// the session in broker is shared by other clients, it is logged out when the broker exits.
+	if v.Broker != nil {
+		v.Session = nil
+		return nil
+	}
+	al := passwd.AutoLogin{Host: v.TargetVpn, SkipTLSVerify: v.ConnOptions.SkipTLSVerify}
+	err := al.VpnLogout(v.Session.Cookies)
+	v.Session = nil
+	return err
+}
+
 func (v *UstbVpn) QrCodeAuthForCookie(hc *http.Client, transport *http.Transport, url *url.URL) error {
 	if v.QrCodeAuth == nil {
 		return fmt.Errorf("QrCodeAuth is not configed")
@@ -150,6 +373,31 @@ func (v *UstbVpn) QrCodeAuthForCookie(hc *http.Client, transport *http.Transport
 	}
 }
 
+// directProbeAddr returns probe, or the host with port of server url if probe is empty.
+func directProbeAddr(probe string, u *url.URL) string {
+	if probe != "" {
+		return probe
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "wss" || u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// directReachable returns true if a tcp connection to addr can be established without vpn.
+func directReachable(addr string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		log.WithField("probe", addr).WithError(err).Debug("direct connection failed.")
+		return false
+	}
+	conn.Close()
+	return true
+}
+
 // ssl specific the protocol(whether to use ssl) used in the real connection
 func vpnUrl(hostEncrypt bool, vpnHost string, ssl bool, u *url.URL) {
 	// replace https://abc.com to "http://n.ustb.edu.cn/https/abc.com"
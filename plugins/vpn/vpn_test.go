@@ -1,57 +1,119 @@
 package vpn
 
 import (
+	"net"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
+// USTBVpnHost is the vpn host in the expected urls.
+const USTBVpnHost = "n.ustb.edu.cn"
+
 func TestVpnUrl(t *testing.T) {
 	// case 1
 	u, _ := url.Parse("https://abc.com")
 	vpnUrl(false, USTBVpnHost, false, u)
-	if u.String() != "http://n.ustb.edu.cn/https/abc.com" {
+	if u.String() != "http://n.ustb.edu.cn/https/abc.com/" {
 		t.Error("error parsing, result is", u)
 	}
 
 	// case 2
 	u, _ = u.Parse("https://abc.com/path1")
 	vpnUrl(false, USTBVpnHost, false, u)
-	if u.String() != "http://n.ustb.edu.cn/https/abc.com/path1" {
+	if u.String() != "http://n.ustb.edu.cn/https/abc.com/path1/" {
 		t.Error("error parsing, result is", u)
 	}
 
 	// case 3
 	u, _ = u.Parse("https://abc.com/path1?ab=1")
 	vpnUrl(false, USTBVpnHost, false, u)
-	if u.String() != "http://n.ustb.edu.cn/https/abc.com/path1?ab=1" {
+	if u.String() != "http://n.ustb.edu.cn/https/abc.com/path1/?ab=1" {
 		t.Error("error parsing, result is", u)
 	}
 
 	// case 4
 	u, _ = u.Parse("wss://abc.com/path1?ab=1")
 	vpnUrl(false, USTBVpnHost, false, u)
-	if u.String() != "ws://n.ustb.edu.cn/wss/abc.com/path1?ab=1" {
+	if u.String() != "ws://n.ustb.edu.cn/wss/abc.com/path1/?ab=1" {
 		t.Error("error parsing, result is", u)
 	}
 
 	// case 5 with port
 	u, _ = u.Parse("wss://abc.com:8080/path1?ab=1")
 	vpnUrl(false, USTBVpnHost, false, u)
-	if u.String() != "ws://n.ustb.edu.cn/wss-8080/abc.com/path1?ab=1" {
+	if u.String() != "ws://n.ustb.edu.cn/wss-8080/abc.com/path1/?ab=1" {
 		t.Error("error parsing, result is", u)
 	}
 
 	// case 6 with port
 	u, _ = u.Parse("ws://abc.com:8080/path1?ab=1")
 	vpnUrl(false, USTBVpnHost, false, u)
-	if u.String() != "ws://n.ustb.edu.cn/ws-8080/abc.com/path1?ab=1" {
+	if u.String() != "ws://n.ustb.edu.cn/ws-8080/abc.com/path1/?ab=1" {
 		t.Error("error parsing, result is", u)
 	}
 
 	// case7 6 with port
 	u, _ = u.Parse("http://abc.com:8080/path1?ab=1")
 	vpnUrl(false, USTBVpnHost, false, u)
-	if u.String() != "http://n.ustb.edu.cn/http-8080/abc.com/path1?ab=1" {
+	if u.String() != "http://n.ustb.edu.cn/http-8080/abc.com/path1/?ab=1" {
+		t.Error("error parsing, result is", u)
+	}
+}
+
+func TestVpnUrlOptions(t *testing.T) {
+	// the trailing slash is not doubled.
+	u, _ := url.Parse("https://abc.com/path1/?ab=1")
+	vpnUrl(false, USTBVpnHost, false, u)
+	if u.String() != "http://n.ustb.edu.cn/https/abc.com/path1/?ab=1" {
 		t.Error("error parsing, result is", u)
 	}
+
+	// the vpn supports https.
+	u, _ = u.Parse("wss://abc.com/path1")
+	vpnUrl(false, USTBVpnHost, true, u)
+	if u.String() != "wss://n.ustb.edu.cn/wss/abc.com/path1/" {
+		t.Error("error parsing, result is", u)
+	}
+	u, _ = u.Parse("http://abc.com:80/path1")
+	vpnUrl(false, USTBVpnHost, true, u)
+	if u.String() != "https://n.ustb.edu.cn/http/abc.com/path1/" {
+		t.Error("error parsing, result is", u)
+	}
+
+	// the host is encrypted, after the hex of key.
+	u, _ = u.Parse("wss://abc.com:8080/path1")
+	vpnUrl(true, USTBVpnHost, false, u)
+	if prefix := "ws://n.ustb.edu.cn/wss-8080/536d756973666f726d616c46696d6d75"; !strings.HasPrefix(u.String(), prefix) ||
+		!strings.HasSuffix(u.Path, "/path1/") || strings.Contains(u.Path, "abc.com") {
+		t.Error("error parsing, result is", u)
+	}
+}
+
+func TestDirectProbe(t *testing.T) {
+	u, _ := url.Parse("wss://proxy.example.com/ws")
+	if addr := directProbeAddr("", u); addr != "proxy.example.com:443" {
+		t.Error("default probe address is", addr)
+	}
+	u, _ = url.Parse("ws://10.0.0.1:1088")
+	if addr := directProbeAddr("", u); addr != "10.0.0.1:1088" {
+		t.Error("default probe address is", addr)
+	}
+	if addr := directProbeAddr("lib.smu.edu.cn:80", u); addr != "lib.smu.edu.cn:80" {
+		t.Error("probe address is", addr)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	if !directReachable(addr, time.Second) {
+		t.Error("listening address should be reachable")
+	}
+	l.Close()
+	if directReachable(addr, time.Second) {
+		t.Error("closed address should not be reachable")
+	}
 }
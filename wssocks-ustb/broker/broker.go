@@ -0,0 +1,150 @@
+package broker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/genshen/cmds"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/broker"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn/passwd"
+	log "github.com/sirupsen/logrus"
+)
+
+var brokerCommand = &cmds.Command{
+	Name:    "broker",
+	Summary: "share one vpn session between local clients",
+	Description: "run the vpn session broker, or show the sessions in the running broker.\n" +
+		"the clients (cli, client-ui and C api) started later get the vpn session from the broker,\n" +
+		"and only one of them logs in when there is no valid session.\n" +
+		"usage: broker [options] [run|status]",
+	CustomFlags: false,
+	HasOptions:  true,
+}
+
+func init() {
+	runner := &brokerRunner{}
+	fs := flag.NewFlagSet("broker", flag.ContinueOnError)
+	brokerCommand.FlagSet = fs
+	fs.StringVar(&runner.addr, "addr", "127.0.0.1:0",
+		`address of broker, a loopback address (port 0 for a random port) or "unix:" + socket path.`)
+	fs.StringVar(&runner.token, "token", "", `token of broker (a random token is generated if it is empty).`)
+	fs.BoolVar(&runner.logout, "logout", true, `logout the vpn sessions when the broker exits.`)
+	fs.BoolVar(&runner.skipTLSVerify, "skip-tls-verify", false, `skip tls verify when logging out vpn.`)
+	brokerCommand.FlagSet.Usage = brokerCommand.Usage // use default usage provided by cmds.Command.
+	brokerCommand.Runner = runner
+	cmds.AllCommands = append(cmds.AllCommands, brokerCommand)
+}
+
+type brokerRunner struct {
+	addr          string
+	token         string
+	logout        bool
+	skipTLSVerify bool
+	action        string
+}
+
+func (r *brokerRunner) PreRun() error {
+	args := brokerCommand.FlagSet.Args()
+	switch len(args) {
+	case 0:
+		r.action = "run"
+	case 1:
+		r.action = args[0]
+	default:
+		return errors.New("usage: broker [options] [run|status]")
+	}
+	if r.action != "run" && r.action != "status" {
+		return fmt.Errorf("unknown action `%s`, usage: broker [options] [run|status]", r.action)
+	}
+	return nil
+}
+
+func (r *brokerRunner) Run() error {
+	if r.action == "status" {
+		return status()
+	}
+
+	token := r.token
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		token = hex.EncodeToString(b)
+	}
+	b := broker.New()
+	server, err := broker.Listen(r.addr, token, b)
+	if err != nil {
+		return err
+	}
+	infoPath, err := broker.DefaultInfoPath()
+	if err == nil {
+		err = broker.WriteInfo(infoPath, broker.Info{Addr: server.Addr(), Token: token})
+	}
+	if err != nil {
+		server.Close()
+		return fmt.Errorf("write info of session broker: %w", err)
+	}
+	defer os.Remove(infoPath)
+
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		<-c
+		server.Close()
+	}()
+	log.WithField("address", server.Addr()).Info("vpn session broker is running.")
+	if err := server.Serve(); err != nil {
+		return err
+	}
+
+	log.Info("vpn session broker is stopped.")
+	if r.logout {
+		for key, session := range b.Drain() {
+			al := passwd.AutoLogin{Host: key.Host, SkipTLSVerify: r.skipTLSVerify}
+			if err := al.VpnLogout(session.Cookies); err != nil {
+				log.WithError(err).WithField("username", key.Username).Warning("failed to logout vpn.")
+			}
+		}
+	}
+	return nil
+}
+
+// status prints the sessions in the running broker.
+func status() error {
+	c := broker.Discover()
+	if c == nil {
+		return errors.New("vpn session broker is not running")
+	}
+	sessions, err := c.Sessions()
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Println("no vpn sessions")
+	}
+	for _, s := range sessions {
+		name := s.Username
+		if s.Method == broker.MethodQRCode {
+			name = "(qr code login)"
+		} else if name == "" {
+			name = "(no username)"
+		}
+		line := fmt.Sprintf("%s %s", s.Host, name)
+		if !s.Since.IsZero() {
+			line += fmt.Sprintf(", logged in %s ago", time.Since(s.Since).Truncate(time.Second))
+		}
+		if s.Leased {
+			line += fmt.Sprintf(", logging in (%d waiting)", s.Waiting)
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
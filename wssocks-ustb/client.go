@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/genshen/cmds"
+	"github.com/genshen/wssocks/client"
+	cmdclient "github.com/genshen/wssocks/cmd/client"
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/control"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/dns"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/metrics"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/pac"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/route"
+	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// clientRunner runs the client sub-command by extra.TaskHandles, instead of the runner in wssocks.
+// The runner in wssocks starts the listeners by client.Handles, which neither counts the traffic nor
+// reconnects, so the cli shares extra.TaskHandles with client-ui and the C api.
+// The flags of wssocks client sub-command are kept and still checked by its PreRun, their values are read
+// from the flag set. The table of connections is shown if stdout is a tty, as the runner in wssocks does.
+type clientRunner struct {
+	cmds.CommandRunner // runner in wssocks, only used for checking flags in PreRun.
+	fs                 *flag.FlagSet
+	vpn                *vpn.UstbVpn
+	statsInterval      time.Duration
+	controlAddr        string
+	controlToken       string
+	metricsAddr        string
+	pacAddr            string
+	pacRules           string
+	pac                *pac.Server // the PAC server, its content is updated on reloading.
+	dns                dns.Options
+	dnsZones           string
+	routeFile          string
+	forwards           string
+	shareAllow         string
+	shareUsers         string
+	shareMaxConns      int
+	router             *route.Router // router of routeFile, the file is watched by stopWatch.
+	stopWatch          context.CancelFunc
+	daemon             bool
+	autoReconnect      extra.AutoReconnectOptions
+	reload             func() error // reloads the flags from configuration file, it is set by addConfigFlags.
+}
+
+// useExtraClient replaces the runner of client sub-command.
+// v is the vpn plugin whose options are added to client sub-command.
+func useExtraClient(v *vpn.UstbVpn) {
+	ok, clientCmd := cmds.Find(cmdclient.CommandNameClient)
+	if !ok {
+		return
+	}
+	runner := &clientRunner{CommandRunner: clientCmd.Runner, fs: clientCmd.FlagSet, vpn: v}
+	clientCmd.FlagSet.DurationVar(&runner.statsInterval, "stats-interval", time.Minute,
+		`interval of logging traffic statistics (0 to disable).`)
+	clientCmd.FlagSet.StringVar(&runner.controlAddr, "control-addr", "",
+		`address of local control api, a loopback address (e.g. 127.0.0.1:7080) or "unix:" + socket path (empty to disable).`)
+	clientCmd.FlagSet.StringVar(&runner.controlToken, "control-token", "",
+		`token of local control api (a random token is generated if it is empty).`)
+	clientCmd.FlagSet.BoolVar(&runner.autoReconnect.Enable, "auto-reconnect", false,
+		`reconnect automatically with backoff if the connection is lost, and probe the tunnel periodically.`)
+	clientCmd.FlagSet.DurationVar(&runner.autoReconnect.ProbeInterval, "probe-interval", 30*time.Second,
+		`interval of health probe through the tunnel when --auto-reconnect is enabled (negative to disable).`)
+	clientCmd.FlagSet.StringVar(&runner.autoReconnect.ProbeAddr, "probe-addr", "",
+		`address (host:port) connected to through the tunnel by health probe (default is the host of remote address).`)
+	clientCmd.FlagSet.BoolVar(&runner.daemon, "daemon", false,
+		`run as a daemon (e.g. systemd service): notify readiness and watchdog by NOTIFY_SOCKET, reload profile on SIGHUP and log to stdout.`)
+	clientCmd.FlagSet.StringVar(&runner.metricsAddr, "metrics-addr", "",
+		`address of Prometheus metrics http server, metrics are served at /metrics (empty to disable).`)
+	clientCmd.FlagSet.StringVar(&runner.pacAddr, "pac-addr", "",
+		`loopback address for serving PAC file at /proxy.pac, e.g. 127.0.0.1:1087 (empty to disable).`)
+	clientCmd.FlagSet.StringVar(&runner.pacRules, "pac-rules", "",
+		`domains and ipv4 networks using the proxy in PAC file, separated by commas, e.g. "*.smu.edu.cn,10.0.0.0/8".`)
+	clientCmd.FlagSet.StringVar(&runner.forwards, "forward", "",
+		`local port forwardings through the tunnel (like ssh -L), separated by commas, e.g. "127.0.0.1:15432=db.internal:5432" (empty local host is 127.0.0.1).`)
+	clientCmd.FlagSet.StringVar(&runner.dns.Addr, "dns-addr", "",
+		`loopback address of local dns server (udp and tcp), e.g. 127.0.0.1:5353 (empty to disable).`)
+	clientCmd.FlagSet.StringVar(&runner.dnsZones, "dns-zones", "",
+		`internal zones resolved over the tunnel by local dns server, separated by commas, e.g. "smu.edu.cn".`)
+	clientCmd.FlagSet.StringVar(&runner.dns.Upstream, "dns-upstream", "",
+		`campus resolver (host[:port]) of internal zones, queried by dns over tcp through the tunnel.`)
+	clientCmd.FlagSet.StringVar(&runner.dns.Fallback, "dns-fallback", "",
+		`resolver (host[:port]) of other names, queried by udp (default is the system resolver).`)
+	clientCmd.FlagSet.StringVar(&runner.routeFile, "route-file", "",
+		`file of routing rules (tunnel, direct or reject) for local connections, it is reloaded on changes (empty to route all through the tunnel).`)
+	clientCmd.FlagSet.StringVar(&runner.shareAllow, "share-allow", "",
+		`enable sharing mode: networks (CIDRs or IPs) of other machines allowed to connect to local listeners, separated by commas, e.g. "192.168.1.0/24" (loopback is always allowed).`)
+	clientCmd.FlagSet.StringVar(&runner.shareUsers, "share-users", "",
+		`users (username:password) of socks5 and http(s) proxy in sharing mode, separated by commas, a comma or backslash in password is escaped by a backslash (empty to disable authentication, loopback is not authenticated).`)
+	clientCmd.FlagSet.IntVar(&runner.shareMaxConns, "share-max-conns", 0,
+		`max active connections of each client in sharing mode (0 for unlimited).`)
+	clientCmd.Runner = runner
+}
+
+func (r *clientRunner) Run() error {
+	if r.daemon {
+		useDaemonLogging()
+	}
+	options, err := r.options()
+	if err != nil {
+		return err
+	}
+	defer r.stopRouter()
+	// check the rules before connecting.
+	if err := pac.ValidateRules(pac.ParseRules(r.pacRules)); err != nil {
+		return err
+	}
+	r.dns.Zones = dns.ParseZones(r.dnsZones)
+	if r.dns.Addr != "" {
+		if err := r.dns.Validate(); err != nil {
+			return err
+		}
+	}
+
+	var handles extra.TaskHandles
+	log.WithField("remote", options.RemoteAddr).Info("connecting to wssocks server.")
+	if err := handles.StartWssocks(options); err != nil {
+		return err
+	}
+	log.WithField("remote", options.RemoteAddr).Info("connected to wssocks server.")
+
+	done := make(chan struct{})
+	defer close(done)
+	if r.controlAddr != "" {
+		stop, err := r.startControl(&handles)
+		if err != nil {
+			handles.NotifyCloseWrapper()
+			handles.Wait()
+			return err
+		}
+		defer stop()
+	}
+	if r.metricsAddr != "" {
+		stop, err := startMetrics(r.metricsAddr, &handles)
+		if err != nil {
+			handles.NotifyCloseWrapper()
+			handles.Wait()
+			return err
+		}
+		defer stop()
+	}
+	if r.pacAddr != "" {
+		stop, err := r.startPac(options)
+		if err != nil {
+			handles.NotifyCloseWrapper()
+			handles.Wait()
+			return err
+		}
+		defer stop()
+	}
+	if r.dns.Addr != "" {
+		stop, err := r.startDns(&handles)
+		if err != nil {
+			handles.NotifyCloseWrapper()
+			handles.Wait()
+			return err
+		}
+		defer stop()
+	}
+	if r.statsInterval > 0 {
+		go logStats(&handles, r.statsInterval, done)
+	}
+	if r.daemon {
+		go r.runDaemon(&handles, done)
+	}
+	// stop the client on the first interrupt (or SIGTERM), and force exit on the second one.
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		<-c
+		if r.daemon {
+			sdNotify("STOPPING=1")
+			log.Info("stopping the client.")
+		} else {
+			log.Println("press CTRL+C to force exit")
+		}
+		handles.NotifyCloseWrapper()
+		<-c
+		os.Exit(0)
+	}()
+
+	waitErr := handles.Wait()
+	// release the vpn session, so that it does not occupy the online devices of the account.
+	if err := handles.Logout(); err != nil {
+		log.WithError(err).Warning("failed to logout vpn.")
+	}
+	logStatsLine(handles.Stats())
+	// the error makes the exit code non-zero, e.g. for restarting by systemd.
+	return waitErr
+}
+
+// options reads the values of client sub-command flags.
+func (r *clientRunner) options() (extra.Options, error) {
+	value := func(name string) string {
+		if f := r.fs.Lookup(name); f != nil {
+			return f.Value.String()
+		}
+		return ""
+	}
+	httpEnabled, _ := strconv.ParseBool(value("http"))
+	skipTLSVerify, _ := strconv.ParseBool(value("skip-tls-verify"))
+	headers, err := r.headers()
+	if err != nil {
+		return extra.Options{}, err
+	}
+	forwards, err := extra.ParseForwards(r.forwards)
+	if err != nil {
+		return extra.Options{}, err
+	}
+	var sharing *extra.Sharing
+	if r.shareAllow != "" {
+		if sharing, err = extra.ParseSharing(extra.SplitAllow(r.shareAllow), extra.SplitUsers(r.shareUsers), r.shareMaxConns); err != nil {
+			return extra.Options{}, err
+		}
+	}
+	if err := r.loadRouter(); err != nil {
+		return extra.Options{}, err
+	}
+	return extra.Options{
+		Options: client.Options{
+			LocalSocks5Addr: value("addr"),
+			HttpEnabled:     httpEnabled,
+			LocalHttpAddr:   value("http-addr"),
+			RemoteHeaders:   headers,
+			SkipTLSVerify:   skipTLSVerify,
+		},
+		UstbVpn:       *r.vpn,
+		RemoteAddr:    value("remote"),
+		AuthToken:     value("key"),
+		Profile:       value("profile"),
+		AutoReconnect: r.autoReconnect,
+		Router:        r.router,
+		Forwards:      forwards,
+		Sharing:       sharing,
+		TermView:      !r.daemon && terminal.IsTerminal(int(os.Stdout.Fd())),
+	}, nil
+}
+
+// loadRouter loads the routing rules of --route-file and watches the file for changes.
+// If the path is not changed (e.g. on reloading profile), the current router is kept.
+func (r *clientRunner) loadRouter() error {
+	if r.router != nil && r.router.Path() == r.routeFile {
+		return nil
+	}
+	var router *route.Router
+	if r.routeFile != "" {
+		var err error
+		if router, err = route.Load(r.routeFile); err != nil {
+			return fmt.Errorf("load routing rules: %w", err)
+		}
+		log.WithField("file", r.routeFile).Info("loaded routing rules.")
+	}
+	r.stopRouter()
+	r.router = router
+	if router != nil {
+		var ctx context.Context
+		ctx, r.stopWatch = context.WithCancel(context.Background())
+		go router.Watch(ctx, route.WatchInterval)
+	}
+	return nil
+}
+
+// stopRouter stops watching the rules file.
+func (r *clientRunner) stopRouter() {
+	if r.stopWatch != nil {
+		r.stopWatch()
+		r.stopWatch = nil
+	}
+}
+
+// startControl starts the local control api, and writes its address and token to the info file
+// for the ctl sub-command. The returned function stops the api and removes the info file.
+func (r *clientRunner) startControl(handles *extra.TaskHandles) (func(), error) {
+	token := r.controlToken
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		token = hex.EncodeToString(b)
+	}
+	server, err := control.Listen(r.controlAddr, token, handles)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.WithError(err).Error("control api stopped.")
+		}
+	}()
+	log.WithField("address", server.Addr()).Info("listening on local address for control api.")
+
+	infoPath, err := control.InfoPath(os.Getpid())
+	if err == nil {
+		err = control.WriteInfo(infoPath, control.Info{Addr: server.Addr(), Token: token, PID: os.Getpid()})
+	}
+	if err != nil {
+		log.WithError(err).Warning("failed to write control api info, ctl sub-command needs --addr and --token.")
+		infoPath = ""
+	}
+	return func() {
+		server.Close()
+		if infoPath != "" {
+			os.Remove(infoPath)
+		}
+	}, nil
+}
+
+// headers parses the values of --ws-header flags.
+func (r *clientRunner) headers() (http.Header, error) {
+	headers := make(http.Header)
+	f := r.fs.Lookup("ws-header")
+	if f == nil {
+		return headers, nil
+	}
+	// the flag value is a string slice in wssocks, but its String() does not return the values.
+	v := reflect.ValueOf(f.Value)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return headers, nil
+	}
+	for i := 0; i < v.Elem().Len(); i++ {
+		header := v.Elem().Index(i).String()
+		index := strings.IndexByte(header, '=')
+		if index == -1 || index+1 == len(header) {
+			return nil, fmt.Errorf("bad http header in websocket request: %s", header)
+		}
+		headers.Add(header[:index], header[index+1:])
+	}
+	return headers, nil
+}
+
+// startMetrics serves the metrics of handles on addr. The returned function stops the server.
+func startMetrics(addr string, handles *extra.TaskHandles) (func(), error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(handles))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("metrics server stopped.")
+		}
+	}()
+	log.WithField("address", l.Addr().String()).Info("listening on local address for metrics.")
+	return func() {
+		server.Close()
+	}, nil
+}
+
+// startPac serves the PAC file generated from the rules and local listeners in options.
+// The returned function stops the server.
+func (r *clientRunner) startPac(options extra.Options) (func(), error) {
+	script, err := pac.Generate(pac.ParseRules(r.pacRules), options.Options)
+	if err != nil {
+		return nil, err
+	}
+	server, err := pac.Listen(r.pacAddr, script)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.WithError(err).Error("pac server stopped.")
+		}
+	}()
+	r.pac = server
+	log.WithField("url", server.URL()).Info("serving PAC file.")
+	return func() {
+		server.Close()
+	}, nil
+}
+
+// updatePac regenerates the PAC file with the reloaded rules and options.
+// The address of PAC server is not changed by reloading.
+func (r *clientRunner) updatePac(options extra.Options) error {
+	if r.pac == nil {
+		return nil
+	}
+	script, err := pac.Generate(pac.ParseRules(r.pacRules), options.Options)
+	if err != nil {
+		return err
+	}
+	r.pac.SetScript(script)
+	return nil
+}
+
+// startDns starts the local dns server, the queries of internal zones are sent through the tunnel of handles.
+// The returned function stops the server.
+func (r *clientRunner) startDns(handles *extra.TaskHandles) (func(), error) {
+	server, err := dns.Listen(r.dns, handles.DialTunnel)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.WithError(err).Error("dns server stopped.")
+		}
+	}()
+	log.WithField("address", server.Addr()).WithField("zones", r.dns.Zones).
+		Info("listening on local address for dns queries.")
+	return func() {
+		server.Close()
+	}, nil
+}
+
+func logStats(handles *extra.TaskHandles, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			logStatsLine(handles.Stats())
+		}
+	}
+}
+
+func logStatsLine(stats extra.Stats) {
+	log.WithFields(log.Fields{
+		"up":         extra.FormatBytes(stats.BytesUp),
+		"down":       extra.FormatBytes(stats.BytesDown),
+		"active":     stats.ActiveConnections,
+		"total":      stats.TotalConnections,
+		"hosts":      len(stats.Destinations),
+		"reconnects": stats.Reconnects,
+	}).Info("traffic statistics")
+	for client, c := range stats.Clients {
+		log.WithFields(log.Fields{
+			"client":   client,
+			"up":       extra.FormatBytes(c.BytesUp),
+			"down":     extra.FormatBytes(c.BytesDown),
+			"active":   c.ActiveConnections,
+			"total":    c.TotalConnections,
+			"rejected": c.Rejected,
+		}).Info("client traffic statistics")
+	}
+}
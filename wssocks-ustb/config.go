@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/genshen/cmds"
+	"github.com/genshen/wssocks/cmd/client"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// EnvConfigPassphrase is the environment variable of the passphrase
+// for decrypting secrets in configuration file.
+const EnvConfigPassphrase = "WSSOCKS_USTB_CONFIG_PASSPHRASE"
+
+// configRunner wraps the runner of client sub-command.
+// Before running the client, it loads the profile from configuration file,
+// and sets the values of flags which are not specified in command line.
+type configRunner struct {
+	cmds.CommandRunner
+	fs          *flag.FlagSet
+	configPath  string
+	profileName string
+	// flags and profile name specified in command line, they are recorded on the first loading.
+	cmdlineFlags   map[string]bool
+	cmdlineProfile string
+	// flags set by the loaded profile, they are reset before reloading.
+	profileFlags map[string]bool
+}
+
+// addConfigFlags adds --config and --profile options to client sub-command.
+func addConfigFlags() {
+	ok, clientCmd := cmds.Find(client.CommandNameClient)
+	if !ok {
+		return
+	}
+	runner := &configRunner{CommandRunner: clientCmd.Runner, fs: clientCmd.FlagSet}
+	defaultPath, _ := config.DefaultPath()
+	clientCmd.FlagSet.StringVar(&runner.configPath, "config", defaultPath, `path of configuration file.`)
+	clientCmd.FlagSet.StringVar(&runner.profileName, "profile", "",
+		`name of profile in configuration file (default profile is used if it is empty).`)
+	// the client can reload the profile (e.g. on SIGHUP in daemon mode).
+	if c, ok := clientCmd.Runner.(*clientRunner); ok {
+		c.reload = runner.loadProfile
+	}
+	clientCmd.Runner = runner
+}
+
+func (r *configRunner) PreRun() error {
+	if err := r.loadProfile(); err != nil {
+		return err
+	}
+	return r.CommandRunner.PreRun()
+}
+
+// loadProfile loads the profile and sets the flags. It can be called again to reload the profile.
+func (r *configRunner) loadProfile() error {
+	if r.cmdlineFlags == nil {
+		r.cmdlineFlags = make(map[string]bool)
+		r.fs.Visit(func(f *flag.Flag) {
+			r.cmdlineFlags[f.Name] = true
+		})
+		r.cmdlineProfile = r.profileName
+	}
+	setFlags := r.cmdlineFlags
+
+	if r.configPath == "" {
+		return nil
+	}
+	cfg, err := config.Load(r.configPath)
+	if err != nil {
+		// the default configuration file is optional.
+		if errors.Is(err, os.ErrNotExist) && !setFlags["config"] && !setFlags["profile"] {
+			return nil
+		}
+		return err
+	}
+	profile, err := cfg.Profile(r.cmdlineProfile)
+	if err != nil {
+		return err
+	}
+	// secrets exported with passphrase (e.g. by client-ui)
+	if profile.HasEncryptedSecrets() {
+		if err := profile.DecryptSecrets(os.Getenv(EnvConfigPassphrase)); err != nil {
+			return fmt.Errorf("%w (set it by environment variable %s)", err, EnvConfigPassphrase)
+		}
+	}
+	profileName := r.cmdlineProfile
+	if profileName == "" {
+		profileName = cfg.DefaultProfile
+	}
+	log.WithField("config", r.configPath).WithField("profile", profileName).Info("loaded configuration file.")
+	// the profile name is shown in the status of control api.
+	if err := r.fs.Set("profile", profileName); err != nil {
+		return err
+	}
+
+	// values removed from the profile are reset to defaults on reloading.
+	for name := range r.profileFlags {
+		if f := r.fs.Lookup(name); f != nil {
+			f.Value.Set(f.DefValue)
+		}
+	}
+	r.profileFlags = make(map[string]bool)
+	// flags in command line override values in file.
+	for name, value := range profile.Flags() {
+		if setFlags[name] {
+			continue
+		}
+		if err := r.fs.Set(name, value); err != nil {
+			return fmt.Errorf("bad value of `%s` in profile: %w", name, err)
+		}
+		r.profileFlags[name] = true
+	}
+	return nil
+}
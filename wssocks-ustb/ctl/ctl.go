@@ -0,0 +1,186 @@
+package ctl
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/genshen/cmds"
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/control"
+)
+
+// exitNotConnected is the exit code of status action if the client is not connected (e.g. reconnecting),
+// so that scripts can check the tunnel. Other errors exit with 1.
+const exitNotConnected = 3
+
+var ctlCommand = &cmds.Command{
+	Name:        "ctl",
+	Summary:     "control a running client",
+	Description: "control a running client by its control api (client started with --control-addr).\nusage: ctl [options] status|stats|reconnect|relogin|stop\n(status exits with code 3 if the client is not connected)",
+	CustomFlags: false,
+	HasOptions:  true,
+}
+
+func init() {
+	runner := &ctl{}
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	ctlCommand.FlagSet = fs
+	ctlCommand.FlagSet.StringVar(&runner.addr, "addr", "", `address of control api (default is read from the file written by client).`)
+	ctlCommand.FlagSet.StringVar(&runner.token, "token", "", `token of control api (default is read from the file written by client).`)
+	ctlCommand.FlagSet.StringVar(&runner.profile, "profile", "", `profile of the client to control, if there are multiple running clients.`)
+	ctlCommand.FlagSet.BoolVar(&runner.json, "json", false, `print the result in json.`)
+	ctlCommand.FlagSet.Usage = ctlCommand.Usage // use default usage provided by cmds.Command.
+	ctlCommand.Runner = runner
+	cmds.AllCommands = append(cmds.AllCommands, ctlCommand)
+}
+
+type ctl struct {
+	addr    string
+	token   string
+	profile string
+	json    bool
+	action  string
+}
+
+func (c *ctl) PreRun() error {
+	args := ctlCommand.FlagSet.Args()
+	if len(args) != 1 {
+		return errors.New("one action is required: status, stats, reconnect, relogin or stop")
+	}
+	c.action = args[0]
+	switch c.action {
+	case "status", "stats", "reconnect", "relogin", "stop":
+	default:
+		return fmt.Errorf("unknown action `%s`", c.action)
+	}
+
+	if c.addr == "" {
+		return c.discover()
+	}
+	if c.token == "" {
+		// find the token of the address in info files.
+		dir, err := control.InfoDir()
+		if err != nil {
+			return err
+		}
+		infos, err := control.ReadInfos(dir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if info.Addr == c.addr {
+				c.token = info.Token
+			}
+		}
+	}
+	return nil
+}
+
+// discover finds the running client of c.profile, by the info files written by clients.
+func (c *ctl) discover() error {
+	clients, err := control.Discover(c.profile)
+	if err != nil {
+		return err
+	}
+	switch len(clients) {
+	case 0:
+		if c.profile != "" {
+			return fmt.Errorf("no running client of profile `%s` with control api found (or specify --addr)", c.profile)
+		}
+		return errors.New("no running client with control api found (or specify --addr)")
+	case 1:
+		c.addr, c.token = clients[0].Addr, clients[0].Token
+		return nil
+	}
+	found := make([]string, 0, len(clients))
+	for _, client := range clients {
+		found = append(found, fmt.Sprintf("%s (pid %d, profile `%s`)", client.Addr, client.PID, client.Status.Profile))
+	}
+	return fmt.Errorf("multiple running clients found, specify --profile or --addr: %s", strings.Join(found, ", "))
+}
+
+func (c *ctl) Run() error {
+	client := control.NewClient(control.Info{Addr: c.addr, Token: c.token})
+	var result interface{}
+	var err error
+	switch c.action {
+	case "status":
+		result, err = client.Status()
+	case "stats":
+		result, err = client.Stats()
+	case "reconnect":
+		result, err = client.Reconnect()
+	case "relogin":
+		result, err = client.Relogin()
+	case "stop":
+		err = client.Stop()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.print(result); err != nil {
+		return err
+	}
+	if status, ok := result.(control.Status); ok && status.Phase != extra.PhaseConnected {
+		os.Exit(exitNotConnected)
+	}
+	return nil
+}
+
+func (c *ctl) print(result interface{}) error {
+	if c.json && result != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	switch r := result.(type) {
+	case control.Status:
+		printStatus(r)
+	case control.Stats:
+		printStats(r)
+	default:
+		fmt.Println("ok")
+	}
+	return nil
+}
+
+func printStatus(s control.Status) {
+	fmt.Printf("phase\t%s\n", s.Phase)
+	fmt.Printf("remote\t%s\n", s.Remote)
+	if s.Profile != "" {
+		fmt.Printf("profile\t%s\n", s.Profile)
+	}
+	fmt.Printf("vpn\t%t\n", s.Vpn)
+	fmt.Printf("socks5\t%s\n", s.Socks5Addr)
+	if s.HttpAddr != "" {
+		fmt.Printf("http\t%s\n", s.HttpAddr)
+	}
+	if !s.Since.IsZero() {
+		fmt.Printf("since\t%s\n", s.Since.Format("2006-01-02 15:04:05"))
+	}
+}
+
+func printStats(s control.Stats) {
+	fmt.Printf("up\t%s\n", extra.FormatBytes(s.BytesUp))
+	fmt.Printf("down\t%s\n", extra.FormatBytes(s.BytesDown))
+	fmt.Printf("connections\t%d active, %d total\n", s.ActiveConnections, s.TotalConnections)
+	fmt.Printf("hosts\t%d\n", len(s.Destinations))
+	fmt.Printf("reconnects\t%d\n", s.Reconnects)
+	// the clients are only counted in sharing mode.
+	clients := make([]string, 0, len(s.Clients))
+	for client := range s.Clients {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	for _, client := range clients {
+		c := s.Clients[client]
+		fmt.Printf("client %s	up %s, down %s, %d active, %d total, %d rejected\n", client,
+			extra.FormatBytes(c.BytesUp), extra.FormatBytes(c.BytesDown), c.ActiveConnections, c.TotalConnections, c.Rejected)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	log "github.com/sirupsen/logrus"
+)
+
+// useDaemonLogging writes logs to stdout without colors.
+// If the stdout is connected to journal, timestamps are also omitted since journal adds them.
+func useDaemonLogging() {
+	log.SetOutput(os.Stdout)
+	log.SetFormatter(&log.TextFormatter{
+		DisableColors:    true,
+		DisableTimestamp: os.Getenv("JOURNAL_STREAM") != "",
+	})
+}
+
+// runDaemon notifies systemd of readiness and status, sends watchdog keep-alive messages
+// while the client is connected, and reloads the profile on SIGHUP. It returns when done is closed.
+func (r *clientRunner) runDaemon(handles *extra.TaskHandles, done <-chan struct{}) {
+	unsubscribe := handles.Subscribe(func(e extra.Event) {
+		status := "STATUS=" + string(e.Phase)
+		if e.Err != nil {
+			status += ": " + e.Err.Error()
+		}
+		sdNotify(status)
+	})
+	defer unsubscribe()
+	sdNotify("READY=1\nSTATUS=" + string(handles.Phase()))
+
+	var watchdog <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		watchdog = t.C
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-watchdog:
+			// systemd restarts the service if the tunnel is not connected for a long time.
+			if handles.Phase() == extra.PhaseConnected {
+				sdNotify("WATCHDOG=1")
+			}
+		case <-hup:
+			sdNotify("RELOADING=1")
+			if err := r.reloadAndRestart(handles); err != nil {
+				log.WithError(err).Error("failed to reload configuration.")
+			}
+			sdNotify("READY=1")
+		}
+	}
+}
+
+// reloadAndRestart reloads the profile from configuration file and restarts the connection with it.
+func (r *clientRunner) reloadAndRestart(handles *extra.TaskHandles) error {
+	if r.reload != nil {
+		if err := r.reload(); err != nil {
+			return err
+		}
+	}
+	options, err := r.options()
+	if err != nil {
+		return err
+	}
+	if err := r.updatePac(options); err != nil {
+		return err
+	}
+	log.Info("configuration reloaded, restarting the connection.")
+	return handles.Restart(options)
+}
+
+// sdNotify sends state to the service manager by NOTIFY_SOCKET, see sd_notify(3).
+// It does nothing if the process is not started by systemd with notify support.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' { // abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.WithError(err).Warning("failed to connect to NOTIFY_SOCKET.")
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.WithError(err).Warning("failed to send notification to NOTIFY_SOCKET.")
+	}
+}
+
+// watchdogInterval returns the interval of sending WATCHDOG=1 (half of WATCHDOG_USEC),
+// or 0 if the watchdog is not enabled for this process.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,106 @@
+package env
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/genshen/cmds"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/config"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/proxyenv"
+)
+
+// default listening addresses of client sub-command.
+const (
+	defaultSocks5Addr = ":1080"
+	defaultHttpAddr   = ":1086"
+)
+
+var envCommand = &cmds.Command{
+	Name:        "env",
+	Summary:     "print proxy settings of shells and tools",
+	Description: "print proxy settings of shells and tools for the local listeners of client, e.g. eval \"$(wssocks-ustb env bash)\".\nusage: env [options] <" + strings.Join(proxyenv.Kinds, "|") + ">",
+	CustomFlags: false,
+	HasOptions:  true,
+}
+
+func init() {
+	runner := &envRunner{}
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	envCommand.FlagSet = fs
+	defaultPath, _ := config.DefaultPath()
+	fs.StringVar(&runner.configPath, "config", defaultPath, `path of configuration file, the addresses of profile are used.`)
+	fs.StringVar(&runner.profile, "profile", "", `name of profile in configuration file (default profile is used if it is empty).`)
+	fs.StringVar(&runner.options.Socks5Addr, "socks", "", `socks5 address of client (default is the local_addr of profile or `+defaultSocks5Addr+`).`)
+	fs.StringVar(&runner.options.HttpAddr, "http", "", `http address of client (default is the http_addr of profile if http is enabled).`)
+	fs.StringVar(&runner.hosts, "hosts", "", `internal hosts for ssh and go, separated by commas (default are the domains in pac rules and dns zones of profile).`)
+	envCommand.FlagSet.Usage = envCommand.Usage // use default usage provided by cmds.Command.
+	envCommand.Runner = runner
+	cmds.AllCommands = append(cmds.AllCommands, envCommand)
+}
+
+type envRunner struct {
+	configPath string
+	profile    string
+	hosts      string
+	options    proxyenv.Options
+	kind       string
+}
+
+func (r *envRunner) PreRun() error {
+	args := envCommand.FlagSet.Args()
+	if len(args) > 1 {
+		return errors.New("usage: env [options] <kind>")
+	}
+	if len(args) == 1 {
+		r.kind = args[0]
+	}
+
+	profile := &config.Profile{}
+	if cfg, err := config.Load(r.configPath); err == nil {
+		if profile, err = cfg.Profile(r.profile); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) || r.profile != "" {
+		return err
+	}
+	if r.options.Socks5Addr == "" {
+		r.options.Socks5Addr = profile.LocalAddr
+		if r.options.Socks5Addr == "" {
+			r.options.Socks5Addr = defaultSocks5Addr
+		}
+	}
+	if r.options.HttpAddr == "" && profile.Http != nil && *profile.Http {
+		r.options.HttpAddr = profile.HttpAddr
+		if r.options.HttpAddr == "" {
+			r.options.HttpAddr = defaultHttpAddr
+		}
+	}
+	if r.hosts != "" {
+		r.options.Hosts = strings.Split(r.hosts, ",")
+	} else {
+		r.options.Hosts = proxyenv.Hosts(profile.Pac.Rules, profile.Dns.Zones)
+	}
+	if exe, err := os.Executable(); err == nil {
+		r.options.NcCommand = proxyenv.NcCommand(exe, r.options.Socks5Addr)
+	}
+	return nil
+}
+
+func (r *envRunner) Run() error {
+	if r.kind == "" {
+		for _, kind := range proxyenv.Kinds {
+			fmt.Printf("%s\t%s\n", kind, proxyenv.Title(kind))
+		}
+		return nil
+	}
+	text, err := proxyenv.Generate(r.kind, r.options)
+	if err != nil {
+		return err
+	}
+	fmt.Println(text)
+	return nil
+}
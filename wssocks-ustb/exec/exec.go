@@ -0,0 +1,238 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	osexec "os/exec"
+	"os/signal"
+	"runtime"
+	"time"
+
+	"github.com/genshen/cmds"
+	"github.com/rep1ace/wssocks-plugin-smu/extra"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/config"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/control"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/proxyenv"
+)
+
+// default listening addresses of client sub-command.
+const (
+	defaultSocks5Addr = ":1080"
+	defaultHttpAddr   = ":1086"
+)
+
+var execCommand = &cmds.Command{
+	Name:    "exec",
+	Summary: "run a command through the tunnel",
+	Description: "run a command with the proxy environment variables and GIT_SSH_COMMAND of client.\n" +
+		"A running client is used if it is found, otherwise a client is started for the lifetime of the command.\n" +
+		"usage: exec [options] -- <command> [args...]",
+	CustomFlags: false,
+	HasOptions:  true,
+}
+
+func init() {
+	runner := &execRunner{}
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	execCommand.FlagSet = fs
+	defaultPath, _ := config.DefaultPath()
+	fs.StringVar(&runner.configPath, "config", defaultPath, `path of configuration file, used by the started client.`)
+	fs.StringVar(&runner.profile, "profile", "", `name of profile in configuration file (default profile is used if it is empty).`)
+	fs.StringVar(&runner.options.Socks5Addr, "socks", "", `socks5 address of client (default is the local_addr of profile or `+defaultSocks5Addr+`).`)
+	fs.StringVar(&runner.options.HttpAddr, "http", "", `http address of client (default is the http_addr of profile if http is enabled).`)
+	fs.DurationVar(&runner.timeout, "timeout", 30*time.Second, `timeout of starting a client.`)
+	execCommand.FlagSet.Usage = execCommand.Usage // use default usage provided by cmds.Command.
+	execCommand.Runner = runner
+	cmds.AllCommands = append(cmds.AllCommands, execCommand)
+}
+
+type execRunner struct {
+	configPath string
+	profile    string
+	options    proxyenv.Options
+	timeout    time.Duration
+	args       []string
+	// flags specified in command line, which are passed to the started client.
+	cmdlineFlags map[string]bool
+}
+
+func (r *execRunner) PreRun() error {
+	r.args = execCommand.FlagSet.Args()
+	if len(r.args) == 0 {
+		return errors.New("usage: exec [options] -- <command> [args...]")
+	}
+	r.cmdlineFlags = make(map[string]bool)
+	execCommand.FlagSet.Visit(func(f *flag.Flag) {
+		r.cmdlineFlags[f.Name] = true
+	})
+
+	profile := &config.Profile{}
+	if cfg, err := config.Load(r.configPath); err == nil {
+		if profile, err = cfg.Profile(r.profile); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) || r.cmdlineFlags["config"] || r.profile != "" {
+		return err
+	}
+	if r.options.Socks5Addr == "" {
+		r.options.Socks5Addr = profile.LocalAddr
+		if r.options.Socks5Addr == "" {
+			r.options.Socks5Addr = defaultSocks5Addr
+		}
+	}
+	if r.options.HttpAddr == "" && profile.Http != nil && *profile.Http {
+		r.options.HttpAddr = profile.HttpAddr
+		if r.options.HttpAddr == "" {
+			r.options.HttpAddr = defaultHttpAddr
+		}
+	}
+	return nil
+}
+
+func (r *execRunner) Run() error {
+	code, err := r.run()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+	return nil
+}
+
+// run runs the command through a running or started client, and returns the exit code of the command.
+func (r *execRunner) run() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	if !r.findClient() {
+		stop, err := r.startClient(exe)
+		if err != nil {
+			return 0, err
+		}
+		defer stop()
+	}
+
+	r.options.NcCommand = proxyenv.NcCommand(exe, r.options.Socks5Addr)
+	env, err := proxyenv.Environ(r.options)
+	if err != nil {
+		return 0, err
+	}
+	cmd := osexec.Command(r.args[0], r.args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// the interrupt is also received by the command (in the same process group),
+	// it is ignored here, so that the started client is stopped after the command exits.
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+	if err := cmd.Run(); err != nil {
+		var exitErr *osexec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+// findClient returns true if a running client is found, by its control api or the listening socks5 address.
+// The addresses of client are updated from the control api.
+func (r *execRunner) findClient() bool {
+	clients, _ := control.Discover(r.profile)
+	for _, c := range clients {
+		if c.Status.Phase != extra.PhaseConnected {
+			continue
+		}
+		if !r.cmdlineFlags["socks"] {
+			r.options.Socks5Addr = c.Status.Socks5Addr
+		}
+		if !r.cmdlineFlags["http"] {
+			r.options.HttpAddr = c.Status.HttpAddr
+		}
+		return true
+	}
+	return listening(r.options.Socks5Addr)
+}
+
+// startClient starts the client sub-command of exe listening on the addresses of options,
+// and waits until it is connected (the socks5 address is listened).
+// The output of client is only shown if it fails to start. The returned function stops the client.
+func (r *execRunner) startClient(exe string) (func(), error) {
+	args := []string{"client", "--addr", r.options.Socks5Addr, "--stats-interval", "0"}
+	if r.options.HttpAddr != "" {
+		args = append(args, "--http", "--http-addr", r.options.HttpAddr)
+	}
+	// the default configuration file is optional, it is only passed if it is specified.
+	if r.cmdlineFlags["config"] {
+		args = append(args, "--config", r.configPath)
+	}
+	if r.profile != "" {
+		args = append(args, "--profile", r.profile)
+	}
+	var output bytes.Buffer
+	cmd := osexec.Command(exe, args...)
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	stop := func() {
+		// the client is stopped gracefully (e.g. vpn logout) on interrupt, which is not supported on windows.
+		if runtime.GOOS == "windows" || cmd.Process.Signal(os.Interrupt) != nil {
+			cmd.Process.Kill()
+		}
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+			<-exited
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for !listening(r.options.Socks5Addr) {
+		select {
+		case <-exited:
+			os.Stderr.Write(output.Bytes())
+			return nil, errors.New("failed to start the client")
+		case <-ctx.Done():
+			stop()
+			os.Stderr.Write(output.Bytes())
+			return nil, fmt.Errorf("the client is not connected in %s", r.timeout)
+		case <-ticker.C:
+		}
+	}
+	return stop, nil
+}
+
+// listening returns true if addr (a listening address) can be connected.
+func listening(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
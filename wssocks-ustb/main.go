@@ -4,23 +4,29 @@ import (
 	"errors"
 	"flag"
 	"github.com/genshen/cmds"
-	"github.com/rep1ace/wssocks-plugin-smu/plugins/ver"
 	"github.com/rep1ace/wssocks-plugin-smu/plugins/vpn"
-	"github.com/genshen/wssocks/client"
 	_ "github.com/genshen/wssocks/cmd/client"
 	_ "github.com/genshen/wssocks/cmd/server"
 	log "github.com/sirupsen/logrus"
 	//_ "github.com/genshen/wssocks/version"
+	_ "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/broker"
+	_ "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/ctl"
+	_ "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/env"
+	_ "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/exec"
+	_ "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/nc"
+	_ "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/resolve"
+	_ "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/route"
+	_ "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/service"
 	_ "github.com/rep1ace/wssocks-plugin-smu/wssocks-ustb/version"
 )
 
 // initialize USTB vpn (n.ustb.edu.cn) plugin
 func init() {
 	vpn := vpn.NewUstbVpnCli()
-	ver := ver.PluginVersionNeg{}
-	client.AddPluginOption(vpn)
-	client.AddPluginRequest(vpn)
-	client.AddPluginVersion(&ver)
+	// the client runs by extra (plugins are added there), so that statistics are available.
+	useExtraClient(vpn)
+	// load client options from configuration file.
+	addConfigFlags()
 }
 
 func main() {
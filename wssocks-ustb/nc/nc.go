@@ -0,0 +1,87 @@
+package nc
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net"
+	"os"
+	"time"
+
+	"github.com/genshen/cmds"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/socks5"
+)
+
+// DefaultProxy is the default socks5 proxy address, the default local address of client.
+const DefaultProxy = "127.0.0.1:1080"
+
+const usage = "usage: nc [options] <host> <port>"
+
+var ncCommand = &cmds.Command{
+	Name:        "nc",
+	Summary:     "relay stdio through socks5 proxy",
+	Description: "connect to host:port through the local socks5 proxy and relay stdin/stdout, e.g. as ssh ProxyCommand:\nssh -o ProxyCommand='wssocks-ustb nc %h %p' user@host\n" + usage,
+	CustomFlags: false,
+	HasOptions:  true,
+}
+
+func init() {
+	runner := &netcat{}
+	ncCommand.FlagSet = newFlagSet(runner)
+	ncCommand.FlagSet.Usage = ncCommand.Usage // use default usage provided by cmds.Command.
+	ncCommand.Runner = runner
+	cmds.AllCommands = append(cmds.AllCommands, ncCommand)
+}
+
+type netcat struct {
+	fs      *flag.FlagSet
+	proxy   string
+	timeout time.Duration
+	addr    string
+}
+
+func newFlagSet(n *netcat) *flag.FlagSet {
+	fs := flag.NewFlagSet("nc", flag.ContinueOnError)
+	fs.StringVar(&n.proxy, "proxy", DefaultProxy, `address of socks5 proxy.`)
+	fs.DurationVar(&n.timeout, "timeout", 30*time.Second, `timeout of connecting through the proxy.`)
+	n.fs = fs
+	return fs
+}
+
+// Main runs nc with args (without the sub-command name), for the programs without cmds sub-commands (e.g. client-ui).
+func Main(args []string) error {
+	n := &netcat{}
+	if err := newFlagSet(n).Parse(args); err != nil {
+		return err
+	}
+	if err := n.PreRun(); err != nil {
+		return err
+	}
+	return n.Run()
+}
+
+func (n *netcat) PreRun() error {
+	args := n.fs.Args()
+	if len(args) < 2 {
+		return errors.New(usage)
+	}
+	// options are also allowed after host and port, e.g. nc %h %p --proxy 127.0.0.1:1080
+	if err := n.fs.Parse(args[2:]); err != nil {
+		return err
+	}
+	if len(n.fs.Args()) != 0 {
+		return errors.New(usage)
+	}
+	n.addr = net.JoinHostPort(args[0], args[1])
+	return nil
+}
+
+func (n *netcat) Run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	conn, err := socks5.Dial(ctx, n.proxy, n.addr)
+	if err != nil {
+		return err
+	}
+	return socks5.Relay(conn, os.Stdin, os.Stdout)
+}
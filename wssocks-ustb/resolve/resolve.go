@@ -0,0 +1,121 @@
+package resolve
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/genshen/cmds"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/config"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/dns"
+)
+
+var resolveCommand = &cmds.Command{
+	Name:        "resolve",
+	Summary:     "debug local dns server",
+	Description: "resolve names by the local dns server of a running client, and show whether they are in internal zones.\nusage: resolve [options] <name>...",
+	CustomFlags: false,
+	HasOptions:  true,
+}
+
+func init() {
+	runner := &resolver{}
+	fs := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	resolveCommand.FlagSet = fs
+	defaultPath, _ := config.DefaultPath()
+	fs.StringVar(&runner.server, "server", "", `address of local dns server (default is the dns addr of profile in configuration file).`)
+	fs.StringVar(&runner.configPath, "config", defaultPath, `path of configuration file.`)
+	fs.StringVar(&runner.profile, "profile", "", `name of profile in configuration file (default profile is used if it is empty).`)
+	fs.StringVar(&runner.qtype, "type", "A", `type of query, A or AAAA.`)
+	resolveCommand.FlagSet.Usage = resolveCommand.Usage // use default usage provided by cmds.Command.
+	resolveCommand.Runner = runner
+	cmds.AllCommands = append(cmds.AllCommands, resolveCommand)
+}
+
+type resolver struct {
+	server     string
+	configPath string
+	profile    string
+	qtype      string
+	zones      []string // internal zones in profile, only for showing
+	noProfile  bool     // the zones are unknown if the profile is not loaded
+	names      []string
+}
+
+func (r *resolver) PreRun() error {
+	r.names = resolveCommand.FlagSet.Args()
+	if len(r.names) == 0 {
+		return errors.New("usage: resolve [options] <name>...")
+	}
+	switch r.qtype = strings.ToUpper(r.qtype); r.qtype {
+	case "A", "AAAA":
+	default:
+		return fmt.Errorf("unsupported query type `%s`", r.qtype)
+	}
+
+	cfg, err := config.Load(r.configPath)
+	if err != nil {
+		if r.server != "" {
+			r.noProfile = true
+			return nil
+		}
+		return fmt.Errorf("%w (or specify dns server by --server)", err)
+	}
+	profile, err := cfg.Profile(r.profile)
+	if err != nil {
+		return err
+	}
+	r.zones = profile.Dns.Zones
+	if r.server != "" {
+		return nil
+	}
+	if profile.Dns.Addr == "" {
+		return errors.New("no dns addr in the profile (or specify dns server by --server)")
+	}
+	r.server = profile.Dns.Addr
+	return nil
+}
+
+func (r *resolver) Run() error {
+	res := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, r.server)
+	}}
+	network := "ip4"
+	if r.qtype == "AAAA" {
+		network = "ip6"
+	}
+	fmt.Printf("server: %s\n", r.server)
+	for _, name := range r.names {
+		via := "system"
+		if zone := dns.MatchZone(r.zones, name); zone != "" {
+			via = "tunnel (zone " + zone + ")"
+		} else if r.noProfile {
+			via = "unknown"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		start := time.Now()
+		ips, err := res.LookupIP(ctx, network, name)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		cancel()
+		if err != nil {
+			// the name server in error is from system configuration, not the dialed one.
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) {
+				err = errors.New(dnsErr.Err)
+			}
+			fmt.Printf("%s\t%s\terror: %v\t%s\n", name, via, err, elapsed)
+			continue
+		}
+		addrs := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			addrs = append(addrs, ip.String())
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", name, via, strings.Join(addrs, ","), elapsed)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package route
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/genshen/cmds"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/config"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/route"
+)
+
+var routeCommand = &cmds.Command{
+	Name:        "route",
+	Summary:     "debug routing rules",
+	Description: "show how connections are routed (tunnel, direct or reject) by the routing rules.\nusage: route [options] test <host:port>...",
+	CustomFlags: false,
+	HasOptions:  true,
+}
+
+func init() {
+	runner := &router{}
+	fs := flag.NewFlagSet("route", flag.ContinueOnError)
+	routeCommand.FlagSet = fs
+	defaultPath, _ := config.DefaultPath()
+	fs.StringVar(&runner.file, "file", "", `file of routing rules (default is the route_file of profile in configuration file).`)
+	fs.StringVar(&runner.configPath, "config", defaultPath, `path of configuration file.`)
+	fs.StringVar(&runner.profile, "profile", "", `name of profile in configuration file (default profile is used if it is empty).`)
+	routeCommand.FlagSet.Usage = routeCommand.Usage // use default usage provided by cmds.Command.
+	routeCommand.Runner = runner
+	cmds.AllCommands = append(cmds.AllCommands, routeCommand)
+}
+
+type router struct {
+	file       string
+	configPath string
+	profile    string
+	addrs      []string
+}
+
+func (r *router) PreRun() error {
+	args := routeCommand.FlagSet.Args()
+	if len(args) < 2 || args[0] != "test" {
+		return errors.New("usage: route [options] test <host:port>...")
+	}
+	for _, addr := range args[1:] {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("bad address `%s`, host:port is required", addr)
+		}
+	}
+	r.addrs = args[1:]
+
+	if r.file != "" {
+		return nil
+	}
+	cfg, err := config.Load(r.configPath)
+	if err != nil {
+		return fmt.Errorf("%w (or specify rules file by --file)", err)
+	}
+	profile, err := cfg.Profile(r.profile)
+	if err != nil {
+		return err
+	}
+	if profile.RouteFile == "" {
+		return errors.New("no route_file in the profile (or specify rules file by --file)")
+	}
+	r.file = profile.RouteFile
+	return nil
+}
+
+func (r *router) Run() error {
+	table, err := route.ParseFile(r.file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rules: %s\n", r.file)
+	for _, addr := range r.addrs {
+		d := table.Match(addr)
+		rule := d.Rule
+		if rule == "" {
+			rule = "default"
+		}
+		fmt.Printf("%s\t%s\t(%s)\n", addr, d.Action, rule)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/genshen/cmds"
+	"github.com/rep1ace/wssocks-plugin-smu/extra/config"
+)
+
+var installCommand = &cmds.Command{
+	Name:        "install-service",
+	Summary:     "install systemd user service",
+	Description: "write a systemd user unit which runs the client in daemon mode with a profile in configuration file.",
+	CustomFlags: false,
+	HasOptions:  true,
+}
+
+func init() {
+	runner := &installer{}
+	fs := flag.NewFlagSet("install-service", flag.ContinueOnError)
+	installCommand.FlagSet = fs
+	defaultPath, _ := config.DefaultPath()
+	fs.StringVar(&runner.name, "name", "wssocks-ustb", `name of the service (unit file is <name>.service).`)
+	fs.StringVar(&runner.configPath, "config", defaultPath, `path of configuration file used by the service.`)
+	fs.StringVar(&runner.profile, "profile", "", `name of profile used by the service (default profile is used if it is empty).`)
+	fs.StringVar(&runner.args, "args", "", `extra options of client sub-command, e.g. "--control-addr unix:/run/user/1000/wssocks.sock".`)
+	fs.IntVar(&runner.watchdogSec, "watchdog-sec", 120, `restart the service if the tunnel is not connected for this seconds (0 to disable).`)
+	fs.BoolVar(&runner.force, "force", false, `overwrite the existing unit file.`)
+	installCommand.FlagSet.Usage = installCommand.Usage // use default usage provided by cmds.Command.
+	installCommand.Runner = runner
+	cmds.AllCommands = append(cmds.AllCommands, installCommand)
+}
+
+type installer struct {
+	name        string
+	configPath  string
+	profile     string
+	args        string
+	watchdogSec int
+	force       bool
+}
+
+func (i *installer) PreRun() error {
+	if i.name == "" || strings.ContainsAny(i.name, "/ ") {
+		return fmt.Errorf("bad service name `%s`", i.name)
+	}
+	if i.configPath == "" {
+		return errors.New("configuration file is required")
+	}
+	path, err := filepath.Abs(i.configPath)
+	if err != nil {
+		return err
+	}
+	i.configPath = path
+	return nil
+}
+
+func (i *installer) Run() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return err
+	}
+	unitPath := filepath.Join(dir, "systemd", "user", i.name+".service")
+	if _, err := os.Stat(unitPath); err == nil && !i.force {
+		return fmt.Errorf("%s already exists (use --force to overwrite)", unitPath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(unitPath, []byte(i.unit(exe)), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("service is written to %s, enable and start it by:\n", unitPath)
+	fmt.Println("\tsystemctl --user daemon-reload")
+	fmt.Printf("\tsystemctl --user enable --now %s\n", i.name)
+	fmt.Printf("logs can be shown by `journalctl --user -u %s`.\n", i.name)
+	return nil
+}
+
+// unit returns the content of unit file, exe is the path of current executable.
+func (i *installer) unit(exe string) string {
+	args := []string{exe, "client", "--daemon", "--config", i.configPath}
+	if i.profile != "" {
+		args = append(args, "--profile", i.profile)
+	}
+	args = append(args, strings.Fields(i.args)...)
+	for k, arg := range args {
+		args[k] = quote(arg)
+	}
+
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=wssocks-ustb client\n")
+	b.WriteString("Wants=network-online.target\n")
+	b.WriteString("After=network-online.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=notify\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(args, " "))
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=10\n")
+	if i.watchdogSec > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%d\n", i.watchdogSec)
+	}
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=default.target\n")
+	return b.String()
+}
+
+// quote quotes arg for the command line in unit file if it contains special characters.
+func quote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\$%") {
+		return arg
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`, `%`, `%%`)
+	return `"` + r.Replace(arg) + `"`
+}